@@ -0,0 +1,237 @@
+// Package chrometrace writes the traces in the Trace Event Format,
+// to be loaded in chrome://tracing or in Perfetto.
+//
+//	w := chrometrace.NewWriter(file)
+//	tracer.RegisterGlobalCb(w.Trace)
+//	tracer.RegisterEventCb(w.Event)
+//	tracer.Run()
+//	err := w.Close()
+//
+// The syscalls are complete events on the track of their thread, the
+// processes and the threads are named from the fork and exec events,
+// and the signals are instant events.
+// The syscalls still running at the end, like exit_group, are written
+// by Close if the enter traces are registered.
+package chrometrace
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jfrabaute/libtrace"
+	"github.com/jfrabaute/libtrace/internal/procfs"
+)
+
+type traceEvent struct {
+	Name     string                 `json:"name"`
+	Category string                 `json:"cat,omitempty"`
+	Phase    string                 `json:"ph"`
+	Ts       float64                `json:"ts"`
+	Dur      *float64               `json:"dur,omitempty"`
+	Pid      int                    `json:"pid"`
+	Tid      int                    `json:"tid"`
+	Scope    string                 `json:"s,omitempty"`
+	Args     map[string]interface{} `json:"args,omitempty"`
+}
+
+type Writer struct {
+	lock    sync.Mutex
+	w       *bufio.Writer
+	err     error // First write error
+	count   int   // Events written
+	start   time.Time
+	last    time.Time
+	tgids   map[int]int         // Process id of the threads
+	names   map[int]string      // Name of the processes
+	pending map[int]*enterTrace // Syscalls running, by thread
+}
+
+type enterTrace struct {
+	name string
+	time time.Time
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:       bufio.NewWriter(w),
+		tgids:   make(map[int]int),
+		names:   make(map[int]string),
+		pending: make(map[int]*enterTrace),
+	}
+}
+
+// Write the syscall on exit, can be registered as a callback
+func (w *Writer) Trace(trace *libtrace.Trace) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.see(trace.Time)
+
+	if !trace.Exit {
+		w.pending[trace.Pid] = &enterTrace{name: trace.Name, time: trace.Time}
+		return
+	}
+	delete(w.pending, trace.Pid)
+
+	args := map[string]interface{}{
+		"ret": int64(trace.Return.Code),
+	}
+	if trace.Return.Failed() {
+		args["error"] = trace.Return.Description
+	}
+	for i, arg := range trace.Args {
+		if i < len(trace.Signature.Args) {
+			args[trace.Signature.Args[i].Name] = arg.Str
+		}
+	}
+	start := trace.Time.Add(-trace.Duration)
+	if start.Before(w.start) {
+		// Use the enter time as the origin if the first trace is an exit
+		start = w.start
+	}
+	w.complete(trace.Name, trace.Pid, start, trace.Time, args)
+}
+
+// Write the process metadata and the signals,
+// can be registered as an event callback
+func (w *Writer) Event(event *libtrace.Event) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.see(event.Time)
+
+	switch event.Type {
+	case libtrace.EventFork, libtrace.EventVfork:
+		w.tgids[event.ChildPid] = event.ChildPid
+		if name, ok := w.names[w.tgid(event.Pid)]; ok {
+			w.nameProcess(event.ChildPid, name)
+		}
+	case libtrace.EventClone:
+		// The threads are created with clone, the processes with fork or vfork
+		w.tgids[event.ChildPid] = w.tgid(event.Pid)
+	case libtrace.EventExec:
+		if event.FormerPid != 0 {
+			delete(w.tgids, event.FormerPid)
+		}
+		w.tgids[event.Pid] = event.Pid
+		w.nameProcess(event.Pid, filepath.Base(event.Path))
+	case libtrace.EventSignal:
+		w.write(&traceEvent{
+			Name:     libtrace.SignalName(event.Signal),
+			Category: "signal",
+			Phase:    "i",
+			Ts:       w.ts(event.Time),
+			Pid:      w.tgid(event.Pid),
+			Tid:      event.Pid,
+			Scope:    "t",
+		})
+	case libtrace.EventExit:
+		delete(w.tgids, event.Pid)
+	}
+}
+
+// Write the syscalls still running and the end of the file,
+// return the first write error
+func (w *Writer) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	tids := make([]int, 0, len(w.pending))
+	for tid := range w.pending {
+		tids = append(tids, tid)
+	}
+	sort.Ints(tids)
+	for _, tid := range tids {
+		p := w.pending[tid]
+		w.complete(p.name, tid, p.time, w.last, map[string]interface{}{"unfinished": true})
+	}
+	w.pending = make(map[int]*enterTrace)
+
+	if w.count == 0 {
+		w.writeString(`{"traceEvents":[`)
+	}
+	w.writeString("\n],\"displayTimeUnit\":\"ns\"}\n")
+	if err := w.w.Flush(); err != nil && w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+func (w *Writer) see(t time.Time) {
+	if w.start.IsZero() {
+		w.start = t
+	}
+	if t.After(w.last) {
+		w.last = t
+	}
+}
+
+func (w *Writer) tgid(tid int) int {
+	if tgid, ok := w.tgids[tid]; ok {
+		return tgid
+	}
+	// The first stop of a thread can come before the clone event
+	tgid := procfs.Tgid(tid)
+	w.tgids[tid] = tgid
+	return tgid
+}
+
+func (w *Writer) nameProcess(pid int, name string) {
+	w.names[pid] = name
+	for _, metadata := range []string{"process_name", "thread_name"} {
+		w.write(&traceEvent{
+			Name:  metadata,
+			Phase: "M",
+			Pid:   pid,
+			Tid:   pid,
+			Args:  map[string]interface{}{"name": name},
+		})
+	}
+}
+
+func (w *Writer) complete(name string, tid int, start, end time.Time, args map[string]interface{}) {
+	dur := float64(end.Sub(start)) / float64(time.Microsecond)
+	w.write(&traceEvent{
+		Name:     name,
+		Category: "syscall",
+		Phase:    "X",
+		Ts:       w.ts(start),
+		Dur:      &dur,
+		Pid:      w.tgid(tid),
+		Tid:      tid,
+		Args:     args,
+	})
+}
+
+// Time in microseconds since the first trace
+func (w *Writer) ts(t time.Time) float64 {
+	return float64(t.Sub(w.start)) / float64(time.Microsecond)
+}
+
+func (w *Writer) write(e *traceEvent) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		if w.err == nil {
+			w.err = err
+		}
+		return
+	}
+	if w.count == 0 {
+		w.writeString("{\"traceEvents\":[\n")
+	} else {
+		w.writeString(",\n")
+	}
+	w.count++
+	if _, err := w.w.Write(data); err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *Writer) writeString(s string) {
+	if _, err := w.w.WriteString(s); err != nil && w.err == nil {
+		w.err = err
+	}
+}
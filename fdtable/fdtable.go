@@ -0,0 +1,579 @@
+// Package fdtable keeps the table of the open fds of each process, with
+// their kind, path, flags and the syscall which created them, and
+// reports the fds left open at exit.
+//
+//	fds := fdtable.New(nil)
+//	tracer.SetFollowForks(true)
+//	tracer.RegisterGlobalCbOnExit(fds.Trace) // Before the callbacks using Lookup
+//	tracer.RegisterEventCb(fds.Event)
+//	tracer.RegisterCbOnExit(func(trace *libtrace.Trace) {
+//		if fd, ok := fds.Lookup(trace.Pid, int(trace.Args[0].Raw)); ok {
+//			log.Printf("read from %s\n", fd.Path)
+//		}
+//	}, "read")
+//	tracer.Run()
+//	for _, leak := range fds.Leaks() {
+//		...
+//	}
+//
+// The fds opened before the tracing, and the fds of the syscalls without
+// a signature in the syscall table, are read from /proc when looked up.
+// close_range is not in the tables, the fds are read again from /proc
+// after it.
+package fdtable
+
+import (
+	"encoding/binary"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jfrabaute/libtrace"
+	"github.com/jfrabaute/libtrace/internal/procfs"
+)
+
+type Kind string
+
+const (
+	KindFile     Kind = "file"
+	KindSocket   Kind = "socket"
+	KindPipe     Kind = "pipe"
+	KindEventfd  Kind = "eventfd"
+	KindEpoll    Kind = "epoll"
+	KindSignalfd Kind = "signalfd"
+	KindTimerfd  Kind = "timerfd"
+	KindInotify  Kind = "inotify"
+	KindFanotify Kind = "fanotify"
+	KindMemfd    Kind = "memfd"
+	KindUnknown  Kind = "unknown"
+)
+
+// Open fd of a process
+type FD struct {
+	Fd      int
+	Kind    Kind
+	Path    string // Path of the file, or target in /proc/pid/fd like "pipe:[1234]"
+	Flags   uint64 // Flags of the creation, like the open flags
+	Cloexec bool
+	Site    *Site // Nil if opened before the tracing
+	owner   int   // Process which created the fd, the inherited fds are not leaked by the children
+}
+
+// Syscall which created an fd
+type Site struct {
+	Syscall            string
+	Pid                int // Task
+	Time               time.Time
+	InstructionPointer uint64
+}
+
+type EventType int
+
+const (
+	FdOpened EventType = iota + 1
+	FdClosed
+	FdLeaked // Opened during the tracing and still open at the exit of the process
+)
+
+func (t EventType) String() string {
+	switch t {
+	case FdOpened:
+		return "opened"
+	case FdClosed:
+		return "closed"
+	}
+	return "leaked"
+}
+
+type FdEvent struct {
+	Type EventType
+	Pid  int // Process
+	Time time.Time
+	FD   FD
+}
+
+type Leak struct {
+	Pid  int
+	Time time.Time // Exit of the process
+	FD   FD
+}
+
+type Tracker struct {
+	lock   sync.Mutex
+	cb     func(FdEvent)
+	events []FdEvent // Events to send after the unlock
+	procs  map[int]map[int]*FD
+	tgids  map[int]int // Process id of the threads
+	leaks  []Leak
+}
+
+const (
+	oCloexec       = 02000000
+	fdCloexec      = 1
+	msgCmsgCloexec = 0x40000000
+
+	fDupfd        = 0
+	fSetfd        = 2
+	fDupfdCloexec = 1030
+	fionclex      = 0x5450
+	fioclex       = 0x5451
+
+	solSocket  = 1
+	scmRights  = 1
+	maxControl = 4096
+)
+
+// Kinds of the fds created by the syscalls, the files are opened by the
+// syscalls with a path
+var syscallKinds = map[string]Kind{
+	"socket":         KindSocket,
+	"accept":         KindSocket,
+	"accept4":        KindSocket,
+	"socketpair":     KindSocket,
+	"pipe":           KindPipe,
+	"pipe2":          KindPipe,
+	"eventfd":        KindEventfd,
+	"eventfd2":       KindEventfd,
+	"epoll_create":   KindEpoll,
+	"epoll_create1":  KindEpoll,
+	"signalfd":       KindSignalfd,
+	"signalfd4":      KindSignalfd,
+	"timerfd_create": KindTimerfd,
+	"inotify_init":   KindInotify,
+	"inotify_init1":  KindInotify,
+	"fanotify_init":  KindFanotify,
+}
+
+// Names of the fd args in the syscall tables
+var fdArgs = map[string]bool{
+	"fd":          true,
+	"ufd":         true,
+	"fildes":      true,
+	"oldfd":       true,
+	"newfd":       true,
+	"dfd":         true,
+	"epfd":        true,
+	"in_fd":       true,
+	"out_fd":      true,
+	"fd_in":       true,
+	"fd_out":      true,
+	"fdin":        true,
+	"fdout":       true,
+	"group_fd":    true,
+	"fanotify_fd": true,
+}
+
+// The callback is called for the opened, closed and leaked fds,
+// it can be nil
+func New(cb func(FdEvent)) *Tracker {
+	return &Tracker{
+		cb:    cb,
+		procs: make(map[int]map[int]*FD),
+		tgids: make(map[int]int),
+	}
+}
+
+// Update the table from the trace, to register on exit
+func (t *Tracker) Trace(trace *libtrace.Trace) {
+	if !trace.Exit {
+		return
+	}
+	t.lock.Lock()
+	t.trace(trace)
+	t.unlock()
+}
+
+func (t *Tracker) trace(trace *libtrace.Trace) {
+	tgid := t.tgid(trace.Pid)
+	ret := trace.Return
+	if ret.Failed() {
+		return
+	}
+	if trace.Signature.Args == nil {
+		if trace.Name == "close_range" {
+			t.resync(tgid, trace.Time)
+		}
+		return
+	}
+	site := &Site{
+		Syscall:            trace.Name,
+		Pid:                trace.Pid,
+		Time:               trace.Time,
+		InstructionPointer: trace.InstructionPointer,
+	}
+	fd := int(int32(ret.Code))
+
+	switch trace.Name {
+	case "open", "openat", "creat", "open_by_handle_at":
+		flags, _ := arg(trace, "flags")
+		t.open(tgid, &FD{Fd: fd, Kind: KindFile, Flags: flags, Cloexec: flags&oCloexec != 0, Site: site})
+	case "socket", "socketpair":
+		typ, _ := arg(trace, "type")
+		fds := []int{fd}
+		if trace.Name == "socketpair" {
+			vec, _ := arg(trace, "usockvec")
+			fds = readFds(trace.Pid, vec)
+		}
+		for _, fd := range fds {
+			t.open(tgid, &FD{Fd: fd, Kind: KindSocket, Flags: typ, Cloexec: typ&oCloexec != 0, Site: site})
+		}
+	case "pipe", "pipe2":
+		flags, _ := arg(trace, "flags")
+		vec, _ := arg(trace, "filedes")
+		for _, fd := range readFds(trace.Pid, vec) {
+			t.open(tgid, &FD{Fd: fd, Kind: KindPipe, Flags: flags, Cloexec: flags&oCloexec != 0, Site: site})
+		}
+	case "accept", "accept4", "eventfd", "eventfd2", "epoll_create", "epoll_create1",
+		"signalfd", "signalfd4", "timerfd_create", "inotify_init", "inotify_init1", "fanotify_init":
+		if ufd, ok := arg(trace, "ufd"); ok && int32(ufd) != -1 {
+			// signalfd changing the mask of an existing signalfd
+			return
+		}
+		flags, _ := arg(trace, "flags")
+		t.open(tgid, &FD{Fd: fd, Kind: syscallKinds[trace.Name], Flags: flags, Cloexec: flags&oCloexec != 0, Site: site})
+	case "dup", "dup2", "dup3":
+		oldfd, ok := arg(trace, "oldfd")
+		if !ok {
+			oldfd, _ = arg(trace, "fildes")
+		}
+		flags, _ := arg(trace, "flags")
+		t.dup(tgid, int(int32(oldfd)), fd, flags&oCloexec != 0, site)
+	case "fcntl":
+		fdArg, _ := arg(trace, "fd")
+		cmd, _ := arg(trace, "cmd")
+		switch cmd {
+		case fDupfd, fDupfdCloexec:
+			t.dup(tgid, int(int32(fdArg)), fd, cmd == fDupfdCloexec, site)
+		case fSetfd:
+			v, _ := arg(trace, "arg")
+			if f := t.lookup(tgid, int(int32(fdArg))); f != nil {
+				f.Cloexec = v&fdCloexec != 0
+			}
+		}
+	case "ioctl":
+		fdArg, _ := arg(trace, "fd")
+		cmd, _ := arg(trace, "cmd")
+		if cmd == fioclex || cmd == fionclex {
+			if f := t.lookup(tgid, int(int32(fdArg))); f != nil {
+				f.Cloexec = cmd == fioclex
+			}
+		}
+	case "recvmsg":
+		msg, _ := arg(trace, "msg")
+		flags, _ := arg(trace, "flags")
+		for _, fd := range receivedFds(trace, msg) {
+			t.open(tgid, &FD{Fd: fd, Kind: KindUnknown, Cloexec: flags&msgCmsgCloexec != 0, Site: site})
+		}
+	case "close":
+		fdArg, _ := arg(trace, "fd")
+		t.close(tgid, int(int32(fdArg)), trace.Time)
+	}
+}
+
+// Follow the processes, to register as an event callback
+func (t *Tracker) Event(event *libtrace.Event) {
+	t.lock.Lock()
+	defer t.unlock()
+
+	switch event.Type {
+	case libtrace.EventFork, libtrace.EventVfork:
+		parent := t.table(t.tgid(event.Pid))
+		t.tgids[event.ChildPid] = event.ChildPid
+		if _, ok := t.procs[event.ChildPid]; !ok {
+			child := make(map[int]*FD, len(parent))
+			for fd, f := range parent {
+				copy := *f
+				child[fd] = &copy
+			}
+			t.procs[event.ChildPid] = child
+		}
+	case libtrace.EventClone:
+		// The threads are created with clone, the processes with fork or vfork
+		t.tgids[event.ChildPid] = t.tgid(event.Pid)
+	case libtrace.EventExec:
+		if event.FormerPid != 0 {
+			delete(t.tgids, event.FormerPid)
+		}
+		t.tgids[event.Pid] = event.Pid
+		for fd, f := range t.table(event.Pid) {
+			if f.Cloexec {
+				t.close(event.Pid, fd, event.Time)
+			}
+		}
+	case libtrace.EventExit:
+		if table, ok := t.procs[event.Pid]; ok {
+			for _, f := range sortedFds(table) {
+				// The standard fds are expected to stay open
+				if f.Site == nil || f.owner != event.Pid || f.Fd <= 2 {
+					continue
+				}
+				t.leaks = append(t.leaks, Leak{Pid: event.Pid, Time: event.Time, FD: *f})
+				t.events = append(t.events, FdEvent{Type: FdLeaked, Pid: event.Pid, Time: event.Time, FD: *f})
+			}
+			delete(t.procs, event.Pid)
+		}
+		delete(t.tgids, event.Pid)
+	}
+}
+
+// Fd of the task, read from /proc if unknown
+func (t *Tracker) Lookup(pid, fd int) (FD, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if f := t.lookup(t.tgid(pid), fd); f != nil {
+		return *f, true
+	}
+	return FD{}, false
+}
+
+// Fds of the fd args of the trace, by arg name, like "fd" or "oldfd"
+func (t *Tracker) Fds(trace *libtrace.Trace) map[string]FD {
+	fds := make(map[string]FD)
+	if trace.Args == nil {
+		return fds
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	tgid := t.tgid(trace.Pid)
+	for i, a := range trace.Signature.Args {
+		if !fdArgs[a.Name] || i >= len(trace.Args) {
+			continue
+		}
+		if f := t.lookup(tgid, int(int32(trace.Args[i].Raw))); f != nil {
+			fds[a.Name] = *f
+		}
+	}
+	return fds
+}
+
+// Open fds of the process, sorted
+func (t *Tracker) Table(pid int) []FD {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var fds []FD
+	for _, f := range sortedFds(t.procs[t.tgid(pid)]) {
+		fds = append(fds, *f)
+	}
+	return fds
+}
+
+// Fds opened during the tracing and still open at the exit of their process
+func (t *Tracker) Leaks() []Leak {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]Leak(nil), t.leaks...)
+}
+
+// Unlock, then send the events
+func (t *Tracker) unlock() {
+	events := t.events
+	t.events = nil
+	t.lock.Unlock()
+	if t.cb != nil {
+		for _, event := range events {
+			t.cb(event)
+		}
+	}
+}
+
+func (t *Tracker) tgid(tid int) int {
+	if tgid, ok := t.tgids[tid]; ok {
+		return tgid
+	}
+	// The first stop of a thread can come before the clone event
+	tgid := procfs.Tgid(tid)
+	t.tgids[tid] = tgid
+	return tgid
+}
+
+func (t *Tracker) table(pid int) map[int]*FD {
+	table, ok := t.procs[pid]
+	if !ok {
+		table = make(map[int]*FD)
+		t.procs[pid] = table
+	}
+	return table
+}
+
+func (t *Tracker) lookup(pid, fd int) *FD {
+	table := t.table(pid)
+	if f, ok := table[fd]; ok {
+		return f
+	}
+	target := procfs.Fd(pid, fd)
+	if target == "" {
+		return nil
+	}
+	f := &FD{Fd: fd, Kind: kindOf(target), Path: target}
+	if flags, ok := procfs.FdFlags(pid, fd); ok {
+		f.Flags, f.Cloexec = flags, flags&oCloexec != 0
+	}
+	table[fd] = f
+	return f
+}
+
+func (t *Tracker) open(pid int, f *FD) {
+	table := t.table(pid)
+	if _, ok := table[f.Fd]; ok {
+		// Missed close, like by a syscall without signature
+		t.close(pid, f.Fd, f.Site.Time)
+	}
+	if target := procfs.Fd(pid, f.Fd); target != "" {
+		f.Path = target
+		if f.Kind == KindUnknown || f.Kind == KindFile {
+			f.Kind = kindOf(target)
+		}
+	}
+	f.owner = pid
+	table[f.Fd] = f
+	t.events = append(t.events, FdEvent{Type: FdOpened, Pid: pid, Time: f.Site.Time, FD: *f})
+}
+
+func (t *Tracker) dup(pid, oldfd, newfd int, cloexec bool, site *Site) {
+	if oldfd == newfd {
+		return
+	}
+	t.close(pid, newfd, site.Time)
+	f := &FD{Fd: newfd, Kind: KindUnknown, Cloexec: cloexec, Site: site}
+	if old := t.lookup(pid, oldfd); old != nil {
+		f.Kind, f.Path, f.Flags = old.Kind, old.Path, old.Flags&^oCloexec
+	}
+	t.open(pid, f)
+}
+
+func (t *Tracker) close(pid, fd int, now time.Time) {
+	table := t.table(pid)
+	f, ok := table[fd]
+	if !ok {
+		return
+	}
+	delete(table, fd)
+	t.events = append(t.events, FdEvent{Type: FdClosed, Pid: pid, Time: now, FD: *f})
+}
+
+// Read the open fds from /proc, after a syscall changing the table
+// without a signature
+func (t *Tracker) resync(pid int, now time.Time) {
+	fds := procfs.Fds(pid)
+	if fds == nil {
+		return
+	}
+	open := make(map[int]bool, len(fds))
+	for _, fd := range fds {
+		open[fd] = true
+	}
+	table := t.table(pid)
+	for _, f := range sortedFds(table) {
+		if !open[f.Fd] {
+			t.close(pid, f.Fd, now)
+		} else if flags, ok := procfs.FdFlags(pid, f.Fd); ok {
+			f.Cloexec = flags&oCloexec != 0
+		}
+	}
+}
+
+func sortedFds(table map[int]*FD) []*FD {
+	fds := make([]*FD, 0, len(table))
+	for _, f := range table {
+		fds = append(fds, f)
+	}
+	sort.Slice(fds, func(i, j int) bool { return fds[i].Fd < fds[j].Fd })
+	return fds
+}
+
+// Kind of the target of a /proc/pid/fd link
+func kindOf(target string) Kind {
+	switch {
+	case strings.HasPrefix(target, "socket:"):
+		return KindSocket
+	case strings.HasPrefix(target, "pipe:"):
+		return KindPipe
+	case strings.HasPrefix(target, "/memfd:"):
+		return KindMemfd
+	case strings.HasPrefix(target, "/"):
+		return KindFile
+	case target == "anon_inode:[eventfd]":
+		return KindEventfd
+	case target == "anon_inode:[eventpoll]":
+		return KindEpoll
+	case target == "anon_inode:[signalfd]":
+		return KindSignalfd
+	case target == "anon_inode:[timerfd]":
+		return KindTimerfd
+	case target == "anon_inode:inotify":
+		return KindInotify
+	case target == "anon_inode:[fanotify]":
+		return KindFanotify
+	}
+	return KindUnknown
+}
+
+// The 2 fds of pipe and socketpair
+func readFds(tid int, addr uint64) []int {
+	b := procfs.ReadBytes(tid, addr, 8)
+	if len(b) != 8 {
+		return nil
+	}
+	return []int{int(int32(binary.LittleEndian.Uint32(b))), int(int32(binary.LittleEndian.Uint32(b[4:])))}
+}
+
+// Fds received with SCM_RIGHTS by recvmsg
+func receivedFds(trace *libtrace.Trace, msg uint64) []int {
+	// Offsets in struct msghdr and struct cmsghdr
+	word := 8
+	if trace.Personality == libtrace.PersonalityI386 {
+		word = 4
+	}
+	readWord := func(b []byte) uint64 {
+		if word == 4 {
+			return uint64(binary.LittleEndian.Uint32(b))
+		}
+		return binary.LittleEndian.Uint64(b)
+	}
+	hdr := procfs.ReadBytes(trace.Pid, msg, 6*word)
+	if len(hdr) != 6*word {
+		return nil
+	}
+	control, controlLen := readWord(hdr[4*word:]), readWord(hdr[5*word:])
+	if control == 0 || controlLen == 0 {
+		return nil
+	}
+	if controlLen > maxControl {
+		controlLen = maxControl
+	}
+	b := procfs.ReadBytes(trace.Pid, control, int(controlLen))
+
+	var fds []int
+	cmsgHdr := word + 8 // len, level, type
+	for len(b) >= cmsgHdr {
+		size := int(readWord(b))
+		if size < cmsgHdr || size > len(b) {
+			break
+		}
+		level := binary.LittleEndian.Uint32(b[word:])
+		typ := binary.LittleEndian.Uint32(b[word+4:])
+		if level == solSocket && typ == scmRights {
+			for data := b[cmsgHdr:size]; len(data) >= 4; data = data[4:] {
+				fds = append(fds, int(int32(binary.LittleEndian.Uint32(data))))
+			}
+		}
+		// CMSG_ALIGN
+		size = (size + word - 1) &^ (word - 1)
+		if size > len(b) {
+			break
+		}
+		b = b[size:]
+	}
+	return fds
+}
+
+// Raw value of the arg of the trace by name
+func arg(trace *libtrace.Trace, name string) (uint64, bool) {
+	for i, a := range trace.Signature.Args {
+		if a.Name == name && i < len(trace.Args) {
+			return trace.Args[i].Raw, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,470 @@
+// Package fileaccess reports the files read, written, created, deleted
+// and probed by each process.
+//
+//	tracer.SetFollowForks(true)
+//	tracker := fileaccess.New()
+//	tracer.RegisterGlobalCb(tracker.Trace)
+//	tracer.RegisterEventCb(tracker.Event)
+//	tracer.Run()
+//	for _, report := range tracker.Reports() {
+//		...
+//	}
+//
+// The paths are read in the tracee memory, the tracker must be registered
+// as a callback. On a replay, they are read from the decoded args (set a
+// max string size of 4096 when recording).
+// The relative paths are resolved against the current directory and the
+// directory fds of the process. The current directory and the unknown
+// fds are read from /proc when the process is first seen.
+// The creations by open with O_CREAT (without O_EXCL) are only known if
+// the enter traces are registered too, and not on a replay. The path args
+// are found with libtrace.PathArgs, the syscalls without args in the
+// syscall tables (like the *at syscalls of i386) are only tracked live:
+// their flags are read from /proc.
+package fileaccess
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/jfrabaute/libtrace"
+	"github.com/jfrabaute/libtrace/internal/procfs"
+)
+
+// Files accessed by a process, the paths are sorted
+type Report struct {
+	Pid      int
+	Exe      string   // Last executable of the process
+	Read     []string // Opened for reading, executed or read as a link
+	Written  []string // Opened for writing, truncated or changed
+	Created  []string
+	Deleted  []string
+	Probed   []string // Existing, checked with stat, access...
+	Missing  []string // Not existing when opened or checked
+	Finished bool     // The process exited
+}
+
+type Tracker struct {
+	lock      sync.Mutex
+	processes []*process       // In creation order
+	procs     map[int]*process // Running processes, by process id
+	tgids     map[int]int      // Process id of the threads
+	creating  map[int]bool     // O_CREAT opens in progress, by thread: the file did not exist
+}
+
+type process struct {
+	report Report
+	cwd    string
+	fds    map[int]string
+	access map[string]access
+}
+
+type access uint8
+
+const (
+	accessRead access = 1 << iota
+	accessWrite
+	accessCreate
+	accessDelete
+	accessProbe
+	accessMissing
+)
+
+const (
+	atFdcwd = -100
+	pathMax = 4096
+
+	oWronly = 01
+	oCreat  = 0100
+	oExcl   = 0200
+	oTrunc  = 01000
+
+	fDupfd        = 0
+	fDupfdCloexec = 1030
+)
+
+func New() *Tracker {
+	return &Tracker{
+		procs:    make(map[int]*process),
+		tgids:    make(map[int]int),
+		creating: make(map[int]bool),
+	}
+}
+
+// Track the file accesses of the trace, to register on enter and exit
+func (t *Tracker) Trace(trace *libtrace.Trace) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	p := t.process(t.tgid(trace.Pid))
+
+	if !trace.Exit {
+		switch trace.Name {
+		case "open", "openat", "openat2", "creat":
+			t.creating[trace.Pid] = p.creating(trace)
+		}
+		return
+	}
+	creating := t.creating[trace.Pid]
+	delete(t.creating, trace.Pid)
+
+	ret := trace.Return
+	paths := p.paths(trace)
+	var path string
+	if len(paths) > 0 {
+		path = paths[0]
+	}
+	switch trace.Name {
+	case "open", "openat", "openat2", "creat":
+		if path == "" {
+			break
+		}
+		if ret.Failed() {
+			p.missing(path, ret)
+			break
+		}
+		flags := openFlags(trace)
+		var a access
+		switch flags & 3 {
+		case 0:
+			a = accessRead
+		case oWronly:
+			a = accessWrite
+		default:
+			a = accessRead | accessWrite
+		}
+		if flags&oTrunc != 0 {
+			a |= accessWrite
+		}
+		if flags&oCreat != 0 && (flags&oExcl != 0 || creating) {
+			a |= accessCreate | accessWrite
+		}
+		p.add(path, a)
+		p.fds[int(int32(ret.Code))] = path
+	case "stat", "lstat", "newfstatat", "stat64", "lstat64", "fstatat64", "statx",
+		"access", "faccessat", "faccessat2", "statfs", "statfs64":
+		if path == "" {
+			break
+		}
+		if ret.Failed() {
+			p.missing(path, ret)
+		} else {
+			p.add(path, accessProbe)
+		}
+	case "readlink", "readlinkat":
+		if path == "" {
+			break
+		}
+		if ret.Failed() {
+			p.missing(path, ret)
+		} else {
+			p.add(path, accessRead)
+		}
+	case "execve", "execveat":
+		// The executed file is known from the exec event
+		if path != "" && ret.Failed() {
+			p.missing(path, ret)
+		}
+	case "truncate", "truncate64", "chmod", "fchmodat", "fchmodat2", "chown", "chown32",
+		"lchown", "lchown32", "fchownat", "utime", "utimes", "futimesat", "utimensat":
+		if path != "" && !ret.Failed() {
+			p.add(path, accessWrite)
+		}
+	case "mkdir", "mkdirat", "mknod", "mknodat":
+		if path != "" && !ret.Failed() {
+			p.add(path, accessCreate)
+		}
+	case "unlink", "unlinkat", "rmdir":
+		if path != "" && !ret.Failed() {
+			p.add(path, accessDelete)
+		}
+	case "rename", "renameat", "renameat2":
+		if len(paths) != 2 || ret.Failed() {
+			break
+		}
+		if paths[0] != "" {
+			p.add(paths[0], accessDelete)
+		}
+		if paths[1] != "" {
+			p.add(paths[1], accessCreate|accessWrite)
+		}
+	case "link", "linkat", "symlink", "symlinkat":
+		// The new name is the last path
+		if len(paths) > 0 && paths[len(paths)-1] != "" && !ret.Failed() {
+			p.add(paths[len(paths)-1], accessCreate)
+		}
+	case "chdir":
+		if path != "" && !ret.Failed() {
+			p.cwd = path
+		}
+	case "fchdir":
+		if fd, ok := arg(trace, "fd"); ok && !ret.Failed() {
+			p.cwd = p.fd(int(int32(fd.Raw)))
+		}
+	case "close":
+		if fd, ok := arg(trace, "fd"); ok {
+			delete(p.fds, int(int32(fd.Raw)))
+		}
+	case "dup", "dup2", "dup3", "fcntl":
+		if ret.Failed() {
+			break
+		}
+		if cmd, ok := arg(trace, "cmd"); ok && cmd.Raw != fDupfd && cmd.Raw != fDupfdCloexec {
+			break
+		}
+		oldfd, ok := arg(trace, "oldfd")
+		if !ok {
+			oldfd, ok = arg(trace, "fildes")
+		}
+		if !ok {
+			oldfd, ok = arg(trace, "fd")
+		}
+		if ok {
+			if path := p.fd(int(int32(oldfd.Raw))); path != "" {
+				p.fds[int(int32(ret.Code))] = path
+			}
+		}
+	}
+}
+
+// Follow the processes, to register as an event callback
+func (t *Tracker) Event(event *libtrace.Event) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	switch event.Type {
+	case libtrace.EventFork, libtrace.EventVfork:
+		parent := t.process(t.tgid(event.Pid))
+		t.tgids[event.ChildPid] = event.ChildPid
+		if _, ok := t.procs[event.ChildPid]; !ok {
+			child := newProcess(event.ChildPid)
+			child.report.Exe = parent.report.Exe
+			child.cwd = parent.cwd
+			for fd, path := range parent.fds {
+				child.fds[fd] = path
+			}
+			t.add(child)
+		}
+	case libtrace.EventClone:
+		// The threads are created with clone, the processes with fork or vfork
+		t.tgids[event.ChildPid] = t.tgid(event.Pid)
+	case libtrace.EventExec:
+		if event.FormerPid != 0 {
+			delete(t.tgids, event.FormerPid)
+		}
+		t.tgids[event.Pid] = event.Pid
+		p := t.process(event.Pid)
+		p.report.Exe = event.Path
+		if event.Path != "" {
+			p.add(event.Path, accessRead)
+		}
+	case libtrace.EventExit:
+		if p, ok := t.procs[event.Pid]; ok {
+			p.report.Finished = true
+			delete(t.procs, event.Pid)
+		}
+		delete(t.tgids, event.Pid)
+		delete(t.creating, event.Pid)
+	}
+}
+
+// Reports of the processes, in creation order
+func (t *Tracker) Reports() []Report {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	reports := make([]Report, 0, len(t.processes))
+	for _, p := range t.processes {
+		reports = append(reports, p.makeReport())
+	}
+	return reports
+}
+
+func (t *Tracker) tgid(tid int) int {
+	if tgid, ok := t.tgids[tid]; ok {
+		return tgid
+	}
+	// The first stop of a thread can come before the clone event
+	tgid := procfs.Tgid(tid)
+	t.tgids[tid] = tgid
+	return tgid
+}
+
+func (t *Tracker) process(pid int) *process {
+	if p, ok := t.procs[pid]; ok {
+		return p
+	}
+	p := newProcess(pid)
+	p.report.Exe = procfs.Exe(pid)
+	p.cwd = procfs.Cwd(pid)
+	t.add(p)
+	return p
+}
+
+func (t *Tracker) add(p *process) {
+	t.procs[p.report.Pid] = p
+	t.processes = append(t.processes, p)
+}
+
+func newProcess(pid int) *process {
+	return &process{
+		report: Report{Pid: pid},
+		fds:    make(map[int]string),
+		access: make(map[string]access),
+	}
+}
+
+func (p *process) add(path string, a access) {
+	p.access[path] |= a
+}
+
+func (p *process) missing(path string, ret libtrace.ReturnValue) {
+	if errno := ret.Errno(); errno == syscall.ENOENT || errno == syscall.ENOTDIR {
+		p.add(path, accessMissing)
+	}
+}
+
+// True if the open will create the file: the args are not decoded on
+// enter, they are read from /proc
+func (p *process) creating(trace *libtrace.Trace) bool {
+	args, ok := procfs.SyscallArgs(trace.Pid)
+	if !ok {
+		return false
+	}
+	dirfd, path, flags := int32(atFdcwd), args[0], uint64(oCreat)
+	switch trace.Name {
+	case "open":
+		flags = args[1]
+	case "openat":
+		dirfd, path, flags = int32(args[0]), args[1], args[2]
+	case "openat2":
+		dirfd, path, flags = int32(args[0]), args[1], howFlags(trace.Pid, args[2])
+	}
+	if flags&oCreat == 0 || flags&oExcl != 0 {
+		return false
+	}
+	name, ok := procfs.ReadString(trace.Pid, path, pathMax)
+	if !ok || name == "" {
+		return false
+	}
+	name = p.resolve(name, int(dirfd))
+	if !filepath.IsAbs(name) {
+		return false
+	}
+	_, err := os.Lstat(name)
+	return os.IsNotExist(err)
+}
+
+// Flags of the open, read from /proc for the syscalls without args
+// in the syscall tables
+func openFlags(trace *libtrace.Trace) uint64 {
+	var args [6]uint64
+	if trace.Signature.Args != nil {
+		for i := 0; i < len(args) && i < len(trace.Args); i++ {
+			args[i] = trace.Args[i].Raw
+		}
+	} else if a, ok := procfs.SyscallArgs(trace.Pid); ok {
+		args = a
+	} else {
+		return 0
+	}
+	switch trace.Name {
+	case "open":
+		return args[1]
+	case "openat":
+		return args[2]
+	case "openat2":
+		return howFlags(trace.Pid, args[2])
+	}
+	return oCreat | oWronly | oTrunc
+}
+
+// Flags of the struct open_how of openat2, the first field
+func howFlags(pid int, addr uint64) uint64 {
+	how := procfs.ReadBytes(pid, addr, 8)
+	if len(how) < 8 {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(how)
+}
+
+// Path of the fd, "" if unknown
+func (p *process) fd(fd int) string {
+	if path, ok := p.fds[fd]; ok {
+		return path
+	}
+	path := procfs.Fd(p.report.Pid, fd)
+	if strings.HasPrefix(path, "/") {
+		p.fds[fd] = path
+		return path
+	}
+	return ""
+}
+
+// Absolute paths of the path args of the syscall,
+// "" for the ones unreadable or empty
+func (p *process) paths(trace *libtrace.Trace) []string {
+	args := libtrace.PathArgs(trace)
+	paths := make([]string, len(args))
+	for i, arg := range args {
+		if arg.Err == nil && arg.Path != "" {
+			paths[i] = p.resolve(arg.Path, arg.Dirfd)
+		}
+	}
+	return paths
+}
+
+// Path relative to the directory fd made absolute,
+// left relative if the directory is unknown
+func (p *process) resolve(path string, dirfd int) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	dir := p.cwd
+	if dirfd != atFdcwd {
+		dir = p.fd(dirfd)
+	}
+	if dir == "" {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(dir, path)
+}
+
+func (p *process) makeReport() Report {
+	r := p.report
+	lists := []struct {
+		a    access
+		list *[]string
+	}{
+		{accessRead, &r.Read},
+		{accessWrite, &r.Written},
+		{accessCreate, &r.Created},
+		{accessDelete, &r.Deleted},
+		{accessProbe, &r.Probed},
+		{accessMissing, &r.Missing},
+	}
+	for path, a := range p.access {
+		for _, l := range lists {
+			if a&l.a != 0 {
+				*l.list = append(*l.list, path)
+			}
+		}
+	}
+	for _, l := range lists {
+		sort.Strings(*l.list)
+	}
+	return r
+}
+
+// Arg of the trace by name
+func arg(trace *libtrace.Trace, name string) (libtrace.ArgValue, bool) {
+	for i, a := range trace.Signature.Args {
+		if a.Name == name && i < len(trace.Args) {
+			return trace.Args[i], true
+		}
+	}
+	return libtrace.ArgValue{}, false
+}
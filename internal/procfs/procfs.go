@@ -0,0 +1,222 @@
+// Package procfs reads the task information from /proc.
+package procfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Process id of the task, the task id if unknown
+func Tgid(tid int) int {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", tid))
+	if err != nil {
+		return tid
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "Tgid:") {
+			if tgid, err := strconv.Atoi(strings.TrimSpace(line[len("Tgid:"):])); err == nil {
+				return tgid
+			}
+		}
+	}
+	return tid
+}
+
+// Path of the executable of the process, "" if unknown
+func Exe(pid int) string {
+	path, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// Current directory of the process, "" if unknown
+func Cwd(pid int) string {
+	path, err := os.Readlink(fmt.Sprintf("/proc/%d/cwd", pid))
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// Target of the fd of the process, "" if unknown
+func Fd(pid, fd int) string {
+	path, err := os.Readlink(fmt.Sprintf("/proc/%d/fd/%d", pid, fd))
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// Args of the syscall the task is stopped in, from /proc/pid/syscall
+func SyscallArgs(tid int) (args [6]uint64, ok bool) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/syscall", tid))
+	if err != nil {
+		return
+	}
+	// Syscall number, 6 args, stack and instruction pointers
+	fields := strings.Fields(string(data))
+	if len(fields) < 7 {
+		return
+	}
+	for i := range args {
+		v, err := strconv.ParseUint(strings.TrimPrefix(fields[i+1], "0x"), 16, 64)
+		if err != nil {
+			return
+		}
+		args[i] = v
+	}
+	return args, true
+}
+
+// Read a C string from the memory of the task
+func ReadString(tid int, addr uint64, max int) (string, bool) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/mem", tid))
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	buf := make([]byte, max)
+	n, _ := f.ReadAt(buf, int64(addr))
+	if i := bytes.IndexByte(buf[:n], 0); i >= 0 {
+		return string(buf[:i]), true
+	}
+	return "", false
+}
+
+// Read the memory of the task, the bytes read before an error are returned
+func ReadBytes(tid int, addr uint64, size int) []byte {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/mem", tid))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	buf := make([]byte, size)
+	n, _ := f.ReadAt(buf, int64(addr))
+	return buf[:n]
+}
+
+// Inode of the socket of the fd, 0 if not a socket
+func SocketInode(pid, fd int) uint64 {
+	target := Fd(pid, fd)
+	if !strings.HasPrefix(target, "socket:[") || !strings.HasSuffix(target, "]") {
+		return 0
+	}
+	inode, _ := strconv.ParseUint(target[len("socket:["):len(target)-1], 10, 64)
+	return inode
+}
+
+// Local and remote addresses of the socket, from the tables of
+// /proc/pid/net. The unix sockets only have a local path.
+func SocketEndpoints(pid int, inode uint64) (local, remote string, ok bool) {
+	for _, table := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		data, err := os.ReadFile(fmt.Sprintf("/proc/%d/net/%s", pid, table))
+		if err != nil {
+			continue
+		}
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+		for _, line := range strings.Split(string(data), "\n")[1:] {
+			fields := strings.Fields(line)
+			if len(fields) < 10 || fields[9] != strconv.FormatUint(inode, 10) {
+				continue
+			}
+			return hexEndpoint(fields[1]), hexEndpoint(fields[2]), true
+		}
+	}
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/net/unix", pid))
+	if err != nil {
+		return
+	}
+	// Num RefCount Protocol Flags Type St Inode Path
+	for _, line := range strings.Split(string(data), "\n")[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 7 || fields[6] != strconv.FormatUint(inode, 10) {
+			continue
+		}
+		if len(fields) > 7 {
+			local = fields[7]
+		}
+		return local, "", true
+	}
+	return
+}
+
+// Address like "0100007F:0050", the ip is in 32 bits words
+// in the host byte order
+func hexEndpoint(s string) string {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return s
+	}
+	ipHex, portHex := s[:i], s[i+1:]
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil || len(ipHex)%8 != 0 {
+		return s
+	}
+	ip := make(net.IP, 0, len(ipHex)/2)
+	for w := 0; w < len(ipHex); w += 8 {
+		word, err := strconv.ParseUint(ipHex[w:w+8], 16, 32)
+		if err != nil {
+			return s
+		}
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], uint32(word))
+		ip = append(ip, b[:]...)
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+}
+
+// Command line of the process, nil if unknown
+func Cmdline(pid int) []string {
+	return readList(fmt.Sprintf("/proc/%d/cmdline", pid))
+}
+
+// Environment of the process at its exec, nil if unknown
+func Environ(pid int) []string {
+	return readList(fmt.Sprintf("/proc/%d/environ", pid))
+}
+
+// Null separated strings
+func readList(path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\x00"), "\x00")
+}
+
+// Open fds of the process, nil if unknown
+func Fds(pid int) []int {
+	entries, err := os.ReadDir(fmt.Sprintf("/proc/%d/fd", pid))
+	if err != nil {
+		return nil
+	}
+	fds := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		if fd, err := strconv.Atoi(entry.Name()); err == nil {
+			fds = append(fds, fd)
+		}
+	}
+	return fds
+}
+
+// Open flags of the fd, with O_CLOEXEC, from /proc/pid/fdinfo
+func FdFlags(pid, fd int) (uint64, bool) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/fdinfo/%d", pid, fd))
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "flags:") {
+			flags, err := strconv.ParseUint(strings.TrimSpace(line[len("flags:"):]), 8, 64)
+			return flags, err == nil
+		}
+	}
+	return 0, false
+}
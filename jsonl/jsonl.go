@@ -0,0 +1,251 @@
+// Package jsonl encodes the traces and the events as JSON Lines,
+// one JSON object per line.
+//
+// Schema (version 1). All the records have the fields:
+//
+//	v         schema version (1)
+//	type      "syscall", or the event type: "fork", "vfork", "clone",
+//	          "exec", "exit", "signal", "policy"
+//	time      time of the stop, RFC 3339 with nanoseconds
+//	pid       task id
+//
+// The syscall records add:
+//
+//	phase         "enter" or "exit"
+//	syscall       syscall name
+//	id            syscall number in the table of the arch
+//	personality   "x86_64" or "i386"
+//	args          exit only, list of:
+//	  name        name of the arg in the signature
+//	  type        "int", "uint", "string", "buffer", "pointer" or "unknown"
+//	  raw         value of the register
+//	  value       int, uint: the value, sign extended to the size of the type
+//	              buffer: the bytes read, base64 encoded
+//	  str         decoded value, as formatted by libtrace
+//	  error       error while decoding the arg
+//	ret           exit only, return value
+//	errno         exit only, errno name if the syscall failed, like "ENOENT"
+//	error         exit only, description of the errno
+//	duration_ns   exit only, time since the enter stop
+//	policy        action of the policy if not "allow": "deny", "kill" or "log"
+//
+// The event records add:
+//
+//	child_pid     fork, vfork, clone: the new task
+//	former_pid    exec: the thread which did the exec, if not the leader
+//	path          exec: path of the executable
+//	exit_code     exit: exit status, if not killed
+//	signal        signal, exit: signal name, like "SIGTERM"
+//	core_dump     exit: true if killed with a core dump
+//	policy        policy: action applied, "deny", "kill" or "log"
+//	rule          policy: index of the matching rule, -1 for the default action
+//	syscall, id, personality, args
+//	              policy: the syscall, with its args
+//
+// New fields can be added without changing the version, the existing
+// fields are only changed with a new version.
+package jsonl
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jfrabaute/libtrace"
+)
+
+const SchemaVersion = 1
+
+type record struct {
+	Version int    `json:"v"`
+	Type    string `json:"type"`
+	Time    string `json:"time"`
+	Pid     int    `json:"pid"`
+
+	Phase       string `json:"phase,omitempty"`
+	Syscall     string `json:"syscall,omitempty"`
+	Id          *int64 `json:"id,omitempty"`
+	Personality string `json:"personality,omitempty"`
+	Args        []arg  `json:"args,omitempty"`
+	Ret         *int64 `json:"ret,omitempty"`
+	Errno       string `json:"errno,omitempty"`
+	Error       string `json:"error,omitempty"`
+	DurationNs  *int64 `json:"duration_ns,omitempty"`
+
+	ChildPid  int    `json:"child_pid,omitempty"`
+	FormerPid int    `json:"former_pid,omitempty"`
+	Path      string `json:"path,omitempty"`
+	ExitCode  *int   `json:"exit_code,omitempty"`
+	Signal    string `json:"signal,omitempty"`
+	CoreDump  bool   `json:"core_dump,omitempty"`
+
+	Policy string `json:"policy,omitempty"`
+	Rule   *int   `json:"rule,omitempty"`
+}
+
+type arg struct {
+	Name  string      `json:"name"`
+	Type  string      `json:"type"`
+	Raw   uint64      `json:"raw"`
+	Value interface{} `json:"value,omitempty"`
+	Str   string      `json:"str,omitempty"`
+	Error string      `json:"error,omitempty"`
+}
+
+type Encoder struct {
+	lock sync.Mutex
+	enc  *json.Encoder
+	err  error // First write error
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{enc: json.NewEncoder(w)}
+}
+
+// Encode the trace, can be registered as a callback
+func (e *Encoder) Trace(trace *libtrace.Trace) {
+	id := int64(trace.Id)
+	r := record{
+		Version:     SchemaVersion,
+		Type:        "syscall",
+		Time:        formatTime(trace.Time),
+		Pid:         trace.Pid,
+		Phase:       "enter",
+		Syscall:     trace.Name,
+		Id:          &id,
+		Personality: trace.Personality.String(),
+	}
+	if trace.Policy != libtrace.PolicyAllow {
+		r.Policy = trace.Policy.String()
+	}
+	if trace.Exit {
+		r.Phase = "exit"
+		if trace.Signature.Args != nil {
+			r.Args = make([]arg, 0, len(trace.Args))
+			for i, value := range trace.Args {
+				if i < len(trace.Signature.Args) {
+					r.Args = append(r.Args, encodeArg(trace.Signature.Args[i], value))
+				}
+			}
+		}
+		ret := int64(trace.Return.Code)
+		r.Ret = &ret
+		if trace.Return.Failed() {
+			r.Errno, r.Error = splitDescription(trace.Return)
+		}
+		duration := int64(trace.Duration)
+		r.DurationNs = &duration
+	}
+	e.encode(&r)
+}
+
+// Encode the event, can be registered as an event callback
+func (e *Encoder) Event(event *libtrace.Event) {
+	r := record{
+		Version:   SchemaVersion,
+		Type:      event.Type.String(),
+		Time:      formatTime(event.Time),
+		Pid:       event.Pid,
+		ChildPid:  event.ChildPid,
+		FormerPid: event.FormerPid,
+		Path:      event.Path,
+		CoreDump:  event.CoreDump,
+	}
+	if event.Signal != 0 {
+		r.Signal = libtrace.SignalName(event.Signal)
+	}
+	if event.Type == libtrace.EventExit && event.Signal == 0 {
+		code := event.ExitCode
+		r.ExitCode = &code
+	}
+	if event.Type == libtrace.EventPolicy {
+		rule := event.Rule
+		r.Policy, r.Rule = event.Action.String(), &rule
+		if trace := event.Trace; trace != nil {
+			id := int64(trace.Id)
+			r.Syscall, r.Id = trace.Name, &id
+			r.Personality = trace.Personality.String()
+			for i, value := range trace.Args {
+				if i < len(trace.Signature.Args) {
+					r.Args = append(r.Args, encodeArg(trace.Signature.Args[i], value))
+				}
+			}
+		}
+	}
+	e.encode(&r)
+}
+
+// First write error
+func (e *Encoder) Err() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.err
+}
+
+func (e *Encoder) encode(r *record) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if err := e.enc.Encode(r); err != nil && e.err == nil {
+		e.err = err
+	}
+}
+
+func formatTime(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
+
+func encodeArg(sig libtrace.Arg, value libtrace.ArgValue) arg {
+	a := arg{
+		Name: sig.Name,
+		Type: "unknown",
+		Raw:  value.Raw,
+		Str:  value.Str,
+	}
+	if value.Err != nil {
+		a.Error = value.Err.Error()
+	}
+
+	switch sig.Type.(type) {
+	case libtrace.StringC:
+		a.Type = "string"
+	case libtrace.Buffer:
+		a.Type = "buffer"
+		if b, ok := value.Value.([]byte); ok && value.Err == nil {
+			a.Value = b
+		}
+	// The int and uint of the signatures are the 32 bits C types
+	case int, int32:
+		a.Type, a.Value = "int", int64(int32(value.Raw))
+	case int8:
+		a.Type, a.Value = "int", int64(int8(value.Raw))
+	case int16:
+		a.Type, a.Value = "int", int64(int16(value.Raw))
+	case int64:
+		a.Type, a.Value = "int", int64(value.Raw)
+	case uint, uint32:
+		a.Type, a.Value = "uint", uint64(uint32(value.Raw))
+	case uint8:
+		a.Type, a.Value = "uint", uint64(uint8(value.Raw))
+	case uint16:
+		a.Type, a.Value = "uint", uint64(uint16(value.Raw))
+	case uint64, uintptr:
+		a.Type, a.Value = "uint", value.Raw
+	default:
+		if sig.Type != nil && reflect.TypeOf(sig.Type).Kind() == reflect.Ptr {
+			a.Type = "pointer"
+		}
+	}
+	return a
+}
+
+// Split a description like "ENOENT (No such file or directory)"
+func splitDescription(ret libtrace.ReturnValue) (name, msg string) {
+	d := ret.Description
+	if i := strings.Index(d, " ("); i > 0 && strings.HasSuffix(d, ")") {
+		return d[:i], d[i+2 : len(d)-1]
+	}
+	return "", ret.Errno().Error()
+}
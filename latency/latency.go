@@ -0,0 +1,233 @@
+// Package latency keeps histograms of the syscall durations, and exposes
+// them in the Prometheus text format.
+//
+//	h := latency.New(latency.Options{ByErrno: true})
+//	tracer.RegisterGlobalCbOnExit(h.Trace)
+//	http.Handle("/metrics", h)
+//	go http.ListenAndServe(":9100", nil)
+//	tracer.Run()
+//
+// The buckets are powers of two, from 1µs to 2^(NumBuckets-1)µs (about 16s).
+package latency
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/bits"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jfrabaute/libtrace"
+)
+
+const (
+	NumBuckets = 25
+	MetricName = "libtrace_syscall_duration_seconds"
+)
+
+type Options struct {
+	ByErrno bool // Label the histograms with the errno name ("" on success)
+	// Label the histograms with the target of the fd of the first arg,
+	// like "/etc/passwd" or "socket:[1234]". It reads /proc at each
+	// syscall, and can make a lot of histograms.
+	ByFd bool
+	// Logger of the errors while serving the histograms,
+	// nil to discard them (like the tracer logger)
+	Logger libtrace.Logger
+}
+
+// Histogram of a syscall, a snapshot
+type Histogram struct {
+	Syscall string
+	Errno   string
+	Fd      string
+	Buckets []Bucket // Cumulative, the last bucket is +Inf
+	Count   uint64
+	Sum     time.Duration
+}
+
+type Bucket struct {
+	UpperBound time.Duration // -1 for +Inf
+	Count      uint64        // Durations less than or equal to the bound
+}
+
+type Histograms struct {
+	opts   Options
+	lock   sync.Mutex
+	series map[key]*histogram
+}
+
+type key struct {
+	syscall string
+	errno   string
+	fd      string
+}
+
+type histogram struct {
+	counts [NumBuckets + 1]uint64 // Not cumulative, the last one is +Inf
+	count  uint64
+	sum    time.Duration
+}
+
+func New(opts Options) *Histograms {
+	return &Histograms{
+		opts:   opts,
+		series: make(map[key]*histogram),
+	}
+}
+
+// Upper bound of the bucket i
+func bucketBound(i int) time.Duration {
+	return time.Microsecond << uint(i)
+}
+
+func bucketIndex(d time.Duration) int {
+	if d <= time.Microsecond {
+		return 0
+	}
+	i := bits.Len64(uint64((d - 1) / time.Microsecond))
+	if i > NumBuckets {
+		return NumBuckets
+	}
+	return i
+}
+
+// Add the duration of the trace, to register on exit
+func (h *Histograms) Trace(trace *libtrace.Trace) {
+	if !trace.Exit {
+		return
+	}
+	k := key{syscall: trace.Name}
+	if h.opts.ByErrno && trace.Return.Failed() {
+		k.errno = errnoName(trace.Return)
+	}
+	if h.opts.ByFd {
+		k.fd = fdTarget(trace)
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	hist, ok := h.series[k]
+	if !ok {
+		hist = &histogram{}
+		h.series[k] = hist
+	}
+	hist.counts[bucketIndex(trace.Duration)]++
+	hist.count++
+	hist.sum += trace.Duration
+}
+
+// Snapshot of the histograms, sorted by syscall, errno and fd
+func (h *Histograms) Histograms() []Histogram {
+	h.lock.Lock()
+	list := make([]Histogram, 0, len(h.series))
+	for k, hist := range h.series {
+		s := Histogram{
+			Syscall: k.syscall,
+			Errno:   k.errno,
+			Fd:      k.fd,
+			Buckets: make([]Bucket, NumBuckets+1),
+			Count:   hist.count,
+			Sum:     hist.sum,
+		}
+		var count uint64
+		for i, c := range hist.counts {
+			count += c
+			s.Buckets[i] = Bucket{UpperBound: bucketBound(i), Count: count}
+		}
+		s.Buckets[NumBuckets].UpperBound = -1
+		list = append(list, s)
+	}
+	h.lock.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Syscall != b.Syscall {
+			return a.Syscall < b.Syscall
+		}
+		if a.Errno != b.Errno {
+			return a.Errno < b.Errno
+		}
+		return a.Fd < b.Fd
+	})
+	return list
+}
+
+// Write the histograms in the Prometheus text format
+func (h *Histograms) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# HELP %s Duration of the syscalls.\n", MetricName)
+	fmt.Fprintf(bw, "# TYPE %s histogram\n", MetricName)
+	for _, s := range h.Histograms() {
+		labels := h.labels(s)
+		for _, b := range s.Buckets {
+			le := "+Inf"
+			if b.UpperBound >= 0 {
+				le = strconv.FormatFloat(b.UpperBound.Seconds(), 'g', -1, 64)
+			}
+			fmt.Fprintf(bw, "%s_bucket{%s,le=\"%s\"} %d\n", MetricName, labels, le, b.Count)
+		}
+		fmt.Fprintf(bw, "%s_sum{%s} %s\n", MetricName, labels, strconv.FormatFloat(s.Sum.Seconds(), 'g', -1, 64))
+		fmt.Fprintf(bw, "%s_count{%s} %d\n", MetricName, labels, s.Count)
+	}
+	return bw.Flush()
+}
+
+func (h *Histograms) labels(s Histogram) string {
+	labels := `syscall="` + escapeLabel(s.Syscall) + `"`
+	if h.opts.ByErrno {
+		labels += `,errno="` + escapeLabel(s.Errno) + `"`
+	}
+	if h.opts.ByFd {
+		labels += `,fd="` + escapeLabel(s.Fd) + `"`
+	}
+	return labels
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// Serve the histograms in the Prometheus text format
+func (h *Histograms) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := h.WritePrometheus(w); err != nil {
+		// The status is already sent, the scraper sees a truncated body
+		h.logf("latency: can't write the histograms to %s: %s", r.RemoteAddr, err)
+	}
+}
+
+func (h *Histograms) logf(format string, v ...interface{}) {
+	if h.opts.Logger != nil {
+		h.opts.Logger.Printf(format, v...)
+	}
+}
+
+// Errno name from a description like "ENOENT (No such file or directory)"
+func errnoName(ret libtrace.ReturnValue) string {
+	if i := strings.Index(ret.Description, " ("); i > 0 {
+		return ret.Description[:i]
+	}
+	return strconv.Itoa(int(ret.Errno()))
+}
+
+// Target of the fd of the first arg, "" if the syscall has no fd
+// or if the fd is closed
+func fdTarget(trace *libtrace.Trace) string {
+	if trace.Signature.Args == nil || len(trace.Args) == 0 || trace.Signature.Args[0].Name != "fd" {
+		return ""
+	}
+	target, err := os.Readlink(fmt.Sprintf("/proc/%d/fd/%d", trace.Pid, int32(trace.Args[0].Raw)))
+	if err != nil {
+		return ""
+	}
+	return target
+}
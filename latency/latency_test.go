@@ -0,0 +1,157 @@
+package latency
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jfrabaute/libtrace"
+)
+
+func TestBucketIndex(t *testing.T) {
+	top := time.Microsecond << (NumBuckets - 1)
+	tests := []struct {
+		d     time.Duration
+		index int
+	}{
+		{0, 0},
+		{time.Microsecond, 0},
+		{time.Microsecond + 1, 1},
+		{2 * time.Microsecond, 1},
+		{2*time.Microsecond + 1, 2},
+		{4 * time.Microsecond, 2},
+		{4*time.Microsecond + 1, 3},
+		{time.Millisecond, 10},
+		{1024 * time.Microsecond, 10},
+		{1024*time.Microsecond + 1, 11},
+		{top, NumBuckets - 1},
+		{top + 1, NumBuckets},
+		{time.Hour, NumBuckets},
+	}
+	for _, test := range tests {
+		if i := bucketIndex(test.d); i != test.index {
+			t.Errorf("bucketIndex(%s) = %d, want %d", test.d, i, test.index)
+		}
+		if test.index < NumBuckets && test.d > bucketBound(test.index) {
+			t.Errorf("%s is above the bound %s of the bucket %d", test.d, bucketBound(test.index), test.index)
+		}
+	}
+}
+
+func exitTrace(name string, d time.Duration, ret libtrace.ReturnValue) *libtrace.Trace {
+	return &libtrace.Trace{
+		Signature: &libtrace.Signature{Name: name},
+		Exit:      true,
+		Duration:  d,
+		Return:    ret,
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	h := New(Options{ByErrno: true})
+	enoent := libtrace.ReturnValue{Code: -2, Description: "ENOENT (No such file or directory)"}
+	h.Trace(exitTrace("read", time.Microsecond, libtrace.ReturnValue{Code: 10}))
+	h.Trace(exitTrace("read", 3*time.Microsecond, libtrace.ReturnValue{Code: 10}))
+	h.Trace(exitTrace("read", 4*time.Microsecond, libtrace.ReturnValue{}))
+	h.Trace(exitTrace("read", time.Hour, libtrace.ReturnValue{}))
+	h.Trace(exitTrace("openat", 5*time.Microsecond, enoent))
+	// Ignored at the enter stop
+	h.Trace(&libtrace.Trace{Signature: &libtrace.Signature{Name: "read"}})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type %q", ct)
+	}
+	lines := strings.Split(strings.TrimSuffix(rec.Body.String(), "\n"), "\n")
+
+	if len(lines) != 2+2*(NumBuckets+3) {
+		t.Fatalf("%d lines, want %d:\n%s", len(lines), 2+2*(NumBuckets+3), rec.Body.String())
+	}
+	want := []string{
+		"# HELP " + MetricName + " Duration of the syscalls.",
+		"# TYPE " + MetricName + " histogram",
+	}
+	for i, line := range want {
+		if lines[i] != line {
+			t.Errorf("line %d: %q, want %q", i, lines[i], line)
+		}
+	}
+
+	// Sorted by syscall: openat, then read
+	openat := lines[2 : 2+NumBuckets+3]
+	read := lines[2+NumBuckets+3:]
+	checkHistogram(t, openat, `syscall="openat",errno="ENOENT"`,
+		map[string]uint64{"1e-06": 0, "2e-06": 0, "4e-06": 0, "8e-06": 1, "16.777216": 1, "+Inf": 1},
+		"5e-06", 1)
+	checkHistogram(t, read, `syscall="read",errno=""`,
+		map[string]uint64{"1e-06": 1, "2e-06": 1, "4e-06": 3, "8e-06": 3, "16.777216": 3, "+Inf": 4},
+		"3600.000008", 4)
+}
+
+// Check the cumulative buckets (by le), the sum and the count of a histogram
+func checkHistogram(t *testing.T, lines []string, labels string, buckets map[string]uint64, sum string, count uint64) {
+	t.Helper()
+	var previous uint64
+	for i, line := range lines[:NumBuckets+1] {
+		prefix := fmt.Sprintf("%s_bucket{%s,le=\"", MetricName, labels)
+		if !strings.HasPrefix(line, prefix) {
+			t.Errorf("bucket %d: %q, want the prefix %q", i, line, prefix)
+			continue
+		}
+		var le string
+		var n uint64
+		if _, err := fmt.Sscanf(strings.Replace(line[len(prefix):], `"}`, " ", 1), "%s %d", &le, &n); err != nil {
+			t.Errorf("bucket %d: %q: %s", i, line, err)
+			continue
+		}
+		if n < previous {
+			t.Errorf("bucket %d: %q, not cumulative (previous %d)", i, line, previous)
+		}
+		previous = n
+		if want, ok := buckets[le]; ok && n != want {
+			t.Errorf("bucket le=%s: %d, want %d", le, n, want)
+		}
+		if i == NumBuckets && le != "+Inf" {
+			t.Errorf("last bucket le=%s, want +Inf", le)
+		}
+	}
+	if want := fmt.Sprintf("%s_sum{%s} %s", MetricName, labels, sum); lines[NumBuckets+1] != want {
+		t.Errorf("%q, want %q", lines[NumBuckets+1], want)
+	}
+	if want := fmt.Sprintf("%s_count{%s} %d", MetricName, labels, count); lines[NumBuckets+2] != want {
+		t.Errorf("%q, want %q", lines[NumBuckets+2], want)
+	}
+}
+
+// ResponseWriter of a scraper gone
+type brokenWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w *brokenWriter) Write(b []byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+type logger []string
+
+func (l *logger) Printf(format string, v ...interface{}) {
+	*l = append(*l, fmt.Sprintf(format, v...))
+}
+
+func TestServeHTTPWriteError(t *testing.T) {
+	var l logger
+	h := New(Options{Logger: &l})
+	h.Trace(exitTrace("read", time.Microsecond, libtrace.ReturnValue{}))
+	h.ServeHTTP(&brokenWriter{httptest.NewRecorder()}, httptest.NewRequest("GET", "/metrics", nil))
+	if len(l) != 1 || !strings.Contains(l[0], "broken pipe") {
+		t.Errorf("logged %q, want the write error", l)
+	}
+}
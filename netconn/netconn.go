@@ -0,0 +1,565 @@
+// Package netconn follows the sockets of the processes: the connections
+// made and accepted, the listening sockets and the bytes transferred.
+//
+//	tracker := netconn.New(func(event netconn.ConnEvent) {
+//		log.Println(event.Type, event.Conn.Remote)
+//	})
+//	tracer.SetFollowForks(true)
+//	tracer.RegisterGlobalCbOnExit(tracker.Trace)
+//	tracer.RegisterEventCb(tracker.Event)
+//	tracer.Run()
+//	for _, conn := range tracker.Conns() {
+//		...
+//	}
+//
+// The addresses are read from the memory of the process and from
+// /proc/pid/net, they are not known on a replay.
+// The fds with the close-on-exec flag (SOCK_CLOEXEC, O_CLOEXEC of dup3,
+// F_DUPFD_CLOEXEC, F_SETFD or FIOCLEX) are closed by the exec events.
+package netconn
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jfrabaute/libtrace"
+	"github.com/jfrabaute/libtrace/internal/procfs"
+)
+
+type Direction int
+
+const (
+	None     Direction = iota // Not connected, like an unconnected udp socket
+	Outbound                  // Connected with connect
+	Inbound                   // Accepted
+	Listen
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Outbound:
+		return "outbound"
+	case Inbound:
+		return "inbound"
+	case Listen:
+		return "listen"
+	}
+	return "none"
+}
+
+// Socket of a process
+type Conn struct {
+	Pid           int
+	Fd            int    // First fd of the socket
+	Family        string // "inet", "inet6", "unix", "netlink"...
+	Type          string // "stream", "dgram", "seqpacket", "raw"
+	Protocol      int
+	Direction     Direction
+	Local         string // Like "127.0.0.1:8080" or "/run/socket"
+	Remote        string
+	Error         string // Error of the connect
+	BytesSent     uint64
+	BytesReceived uint64
+	Opened        time.Time
+	Closed        time.Time // Zero if still open
+}
+
+type EventType int
+
+const (
+	ConnOpened EventType = iota + 1 // Connected, accepted or listening
+	ConnClosed
+)
+
+func (t EventType) String() string {
+	if t == ConnOpened {
+		return "opened"
+	}
+	return "closed"
+}
+
+type ConnEvent struct {
+	Type EventType
+	Time time.Time
+	Conn Conn
+}
+
+type Tracker struct {
+	lock   sync.Mutex
+	cb     func(ConnEvent)
+	events []ConnEvent // Events to send after the unlock
+	conns  []*conn     // In creation order
+	procs  map[int]*process
+	tgids  map[int]int // Process id of the threads
+}
+
+type conn struct {
+	Conn
+	refs   int  // Fds of the socket, in all the processes
+	opened bool // The opened event was sent
+}
+
+type process struct {
+	fds     map[int]*conn
+	cloexec map[int]bool // Fds closed on exec
+}
+
+const (
+	afUnix    = 1
+	afInet    = 2
+	afInet6   = 10
+	afNetlink = 16
+
+	sockTypeMask = 0xf
+	sockCloexec  = 0x80000 // O_CLOEXEC too
+
+	fDupfd        = 0
+	fSetfd        = 2
+	fDupfdCloexec = 1030
+	fdCloexec     = 1
+
+	fionclex = 0x5450
+	fioclex  = 0x5451
+
+	maxSockaddr = 128
+)
+
+var families = map[uint64]string{
+	afUnix:    "unix",
+	afInet:    "inet",
+	afInet6:   "inet6",
+	afNetlink: "netlink",
+	17:        "packet",
+}
+
+var sockTypes = map[uint64]string{
+	1: "stream",
+	2: "dgram",
+	3: "raw",
+	5: "seqpacket",
+}
+
+// The callback is called for the opened and closed connections,
+// it can be nil
+func New(cb func(ConnEvent)) *Tracker {
+	return &Tracker{
+		cb:    cb,
+		procs: make(map[int]*process),
+		tgids: make(map[int]int),
+	}
+}
+
+// Follow the sockets of the trace, to register on exit
+func (t *Tracker) Trace(trace *libtrace.Trace) {
+	if !trace.Exit || trace.Signature.Args == nil {
+		return
+	}
+	t.lock.Lock()
+	t.trace(trace)
+	t.unlock()
+}
+
+func (t *Tracker) trace(trace *libtrace.Trace) {
+	tgid := t.tgid(trace.Pid)
+	p := t.process(tgid)
+	ret := trace.Return
+
+	switch trace.Name {
+	case "socket":
+		if ret.Failed() {
+			return
+		}
+		c := t.newConn(tgid, int(ret.Code), trace.Time)
+		c.setKind(arg(trace, "family"), arg(trace, "type"), arg(trace, "protocol"))
+		p.fds[c.Fd] = c
+		p.setCloexec(c.Fd, arg(trace, "type")&sockCloexec != 0)
+	case "socketpair":
+		if ret.Failed() {
+			return
+		}
+		fds := procfs.ReadBytes(trace.Pid, arg(trace, "usockvec"), 8)
+		if len(fds) != 8 {
+			return
+		}
+		for i := 0; i < 2; i++ {
+			c := t.newConn(tgid, int(int32(binary.LittleEndian.Uint32(fds[i*4:]))), trace.Time)
+			c.setKind(arg(trace, "family"), arg(trace, "type"), arg(trace, "protocol"))
+			p.fds[c.Fd] = c
+			p.setCloexec(c.Fd, arg(trace, "type")&sockCloexec != 0)
+		}
+	case "connect":
+		c := t.conn(p, tgid, fdArg(trace, "fd"), trace.Time)
+		if ret.Failed() && ret.Errno() != syscall.EINPROGRESS {
+			c.Error = ret.Description
+			return
+		}
+		c.Direction = Outbound
+		if remote := t.sockaddr(trace.Pid, arg(trace, "uservaddr"), c); remote != "" {
+			c.Remote = remote
+		}
+		t.endpoints(tgid, c)
+		t.open(c, trace.Time)
+	case "bind":
+		if ret.Failed() {
+			return
+		}
+		c := t.conn(p, tgid, fdArg(trace, "fd"), trace.Time)
+		if local := t.sockaddr(trace.Pid, arg(trace, "umyaddr"), c); local != "" {
+			c.Local = local
+		}
+		t.endpoints(tgid, c)
+	case "listen":
+		if ret.Failed() {
+			return
+		}
+		c := t.conn(p, tgid, fdArg(trace, "fd"), trace.Time)
+		c.Direction = Listen
+		t.endpoints(tgid, c)
+		t.open(c, trace.Time)
+	case "accept", "accept4":
+		if ret.Failed() {
+			return
+		}
+		listener := t.conn(p, tgid, fdArg(trace, "fd"), trace.Time)
+		c := t.newConn(tgid, int(ret.Code), trace.Time)
+		c.Family, c.Type, c.Protocol = listener.Family, listener.Type, listener.Protocol
+		c.Direction = Inbound
+		c.Local = listener.Local
+		c.Remote = t.sockaddr(trace.Pid, arg(trace, "upeer_sockaddr"), c)
+		t.endpoints(tgid, c)
+		p.fds[c.Fd] = c
+		// The flags of accept4
+		p.setCloexec(c.Fd, arg(trace, "flags")&sockCloexec != 0)
+		t.open(c, trace.Time)
+	case "sendto", "recvfrom":
+		c, ok := p.fds[fdArg(trace, "fd")]
+		if !ok || ret.Failed() {
+			return
+		}
+		if c.Remote == "" {
+			c.Remote = t.sockaddr(trace.Pid, arg(trace, "addr"), c)
+		}
+		if trace.Name == "sendto" {
+			c.BytesSent += uint64(ret.Code)
+		} else {
+			c.BytesReceived += uint64(ret.Code)
+		}
+	case "write", "writev", "send", "sendmsg":
+		if c, ok := p.fds[fdArg(trace, "fd")]; ok && !ret.Failed() {
+			c.BytesSent += uint64(ret.Code)
+		}
+	case "read", "readv", "recv", "recvmsg":
+		if c, ok := p.fds[fdArg(trace, "fd")]; ok && !ret.Failed() {
+			c.BytesReceived += uint64(ret.Code)
+		}
+	case "dup", "dup2", "dup3", "fcntl":
+		if ret.Failed() {
+			return
+		}
+		cmd := arg(trace, "cmd")
+		if trace.Name == "fcntl" {
+			switch cmd {
+			case fSetfd:
+				p.setCloexec(fdArg(trace, "fd"), arg(trace, "arg")&fdCloexec != 0)
+				return
+			case fDupfd, fDupfdCloexec:
+			default:
+				return
+			}
+		}
+		oldfd := fdArg(trace, "oldfd")
+		if trace.Name == "dup" {
+			oldfd = fdArg(trace, "fildes")
+		} else if trace.Name == "fcntl" {
+			oldfd = fdArg(trace, "fd")
+		}
+		newfd := int(int32(ret.Code))
+		if newfd == oldfd {
+			return
+		}
+		// dup2 and dup3 close the new fd
+		t.close(p, newfd, trace.Time)
+		if c, ok := p.fds[oldfd]; ok {
+			c.refs++
+			p.fds[newfd] = c
+			p.setCloexec(newfd, trace.Name == "dup3" && arg(trace, "flags")&sockCloexec != 0 ||
+				trace.Name == "fcntl" && cmd == fDupfdCloexec)
+		}
+	case "ioctl":
+		if ret.Failed() {
+			return
+		}
+		switch arg(trace, "cmd") {
+		case fioclex:
+			p.setCloexec(fdArg(trace, "fd"), true)
+		case fionclex:
+			p.setCloexec(fdArg(trace, "fd"), false)
+		}
+	case "close":
+		if !ret.Failed() {
+			t.close(p, fdArg(trace, "fd"), trace.Time)
+		}
+	}
+}
+
+// Follow the processes, to register as an event callback
+func (t *Tracker) Event(event *libtrace.Event) {
+	t.lock.Lock()
+	defer t.unlock()
+
+	switch event.Type {
+	case libtrace.EventFork, libtrace.EventVfork:
+		parent := t.process(t.tgid(event.Pid))
+		t.tgids[event.ChildPid] = event.ChildPid
+		if _, ok := t.procs[event.ChildPid]; !ok {
+			child := newProcess()
+			for fd, c := range parent.fds {
+				c.refs++
+				child.fds[fd] = c
+			}
+			for fd := range parent.cloexec {
+				child.cloexec[fd] = true
+			}
+			t.procs[event.ChildPid] = child
+		}
+	case libtrace.EventClone:
+		// The threads are created with clone, the processes with fork or vfork
+		t.tgids[event.ChildPid] = t.tgid(event.Pid)
+	case libtrace.EventExec:
+		if event.FormerPid != 0 {
+			delete(t.tgids, event.FormerPid)
+		}
+		t.tgids[event.Pid] = event.Pid
+		if p, ok := t.procs[event.Pid]; ok {
+			for fd := range p.cloexec {
+				t.close(p, fd, event.Time)
+			}
+		}
+	case libtrace.EventExit:
+		if p, ok := t.procs[event.Pid]; ok {
+			for fd := range p.fds {
+				t.close(p, fd, event.Time)
+			}
+			delete(t.procs, event.Pid)
+		}
+		delete(t.tgids, event.Pid)
+	}
+}
+
+// Sockets of the processes, in creation order
+func (t *Tracker) Conns() []Conn {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	conns := make([]Conn, 0, len(t.conns))
+	for _, c := range t.conns {
+		conns = append(conns, c.Conn)
+	}
+	return conns
+}
+
+// Unlock, then send the events
+func (t *Tracker) unlock() {
+	events := t.events
+	t.events = nil
+	t.lock.Unlock()
+	if t.cb != nil {
+		for _, event := range events {
+			t.cb(event)
+		}
+	}
+}
+
+func (t *Tracker) tgid(tid int) int {
+	if tgid, ok := t.tgids[tid]; ok {
+		return tgid
+	}
+	// The first stop of a thread can come before the clone event
+	tgid := procfs.Tgid(tid)
+	t.tgids[tid] = tgid
+	return tgid
+}
+
+func (t *Tracker) process(pid int) *process {
+	if p, ok := t.procs[pid]; ok {
+		return p
+	}
+	p := newProcess()
+	t.procs[pid] = p
+	return p
+}
+
+func newProcess() *process {
+	return &process{
+		fds:     make(map[int]*conn),
+		cloexec: make(map[int]bool),
+	}
+}
+
+// Set or clear the close-on-exec flag of a socket fd
+func (p *process) setCloexec(fd int, cloexec bool) {
+	if _, ok := p.fds[fd]; ok && cloexec {
+		p.cloexec[fd] = true
+	} else {
+		delete(p.cloexec, fd)
+	}
+}
+
+func (t *Tracker) newConn(pid, fd int, now time.Time) *conn {
+	c := &conn{Conn: Conn{Pid: pid, Fd: fd, Opened: now}, refs: 1}
+	t.conns = append(t.conns, c)
+	return c
+}
+
+// Socket of the fd, created before the tracing if unknown
+func (t *Tracker) conn(p *process, pid, fd int, now time.Time) *conn {
+	if c, ok := p.fds[fd]; ok {
+		return c
+	}
+	c := t.newConn(pid, fd, now)
+	p.fds[fd] = c
+	return c
+}
+
+func (t *Tracker) open(c *conn, now time.Time) {
+	if c.opened {
+		return
+	}
+	c.opened = true
+	t.events = append(t.events, ConnEvent{Type: ConnOpened, Time: now, Conn: c.Conn})
+}
+
+func (t *Tracker) close(p *process, fd int, now time.Time) {
+	c, ok := p.fds[fd]
+	if !ok {
+		return
+	}
+	delete(p.fds, fd)
+	delete(p.cloexec, fd)
+	if c.refs--; c.refs > 0 {
+		return
+	}
+	c.Closed = now
+	if c.opened {
+		t.events = append(t.events, ConnEvent{Type: ConnClosed, Time: now, Conn: c.Conn})
+	}
+}
+
+func (c *conn) setKind(family, typ, protocol uint64) {
+	c.Family = families[family]
+	if c.Family == "" {
+		c.Family = strconv.FormatUint(family, 10)
+	}
+	c.Type = sockTypes[typ&sockTypeMask]
+	if c.Type == "" {
+		c.Type = strconv.FormatUint(typ&sockTypeMask, 10)
+	}
+	c.Protocol = int(protocol)
+}
+
+// Fill the missing endpoints from /proc/pid/net
+func (t *Tracker) endpoints(pid int, c *conn) {
+	if !unspecifiedPort(c.Local) && (c.Remote != "" || c.Direction == Listen) {
+		return
+	}
+	inode := procfs.SocketInode(pid, c.Fd)
+	if inode == 0 {
+		return
+	}
+	local, remote, ok := procfs.SocketEndpoints(pid, inode)
+	if !ok {
+		return
+	}
+	if unspecifiedPort(c.Local) {
+		c.Local = local
+	}
+	if c.Remote == "" && !unspecified(remote) {
+		c.Remote = remote
+	}
+}
+
+// Unconnected address, like 0.0.0.0:0
+func unspecified(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr == ""
+	}
+	ip := net.ParseIP(host)
+	return port == "0" && (ip == nil || ip.IsUnspecified())
+}
+
+// Address without a port yet, like 127.0.0.1:0
+func unspecifiedPort(addr string) bool {
+	if addr == "" {
+		return true
+	}
+	_, port, err := net.SplitHostPort(addr)
+	return err == nil && port == "0"
+}
+
+// Address of the sockaddr in the memory of the task,
+// the family of the socket is set if unknown
+func (t *Tracker) sockaddr(tid int, addr uint64, c *conn) string {
+	if addr == 0 {
+		return ""
+	}
+	b := procfs.ReadBytes(tid, addr, maxSockaddr)
+	if len(b) < 2 {
+		return ""
+	}
+	family := uint64(binary.LittleEndian.Uint16(b))
+	if c.Family == "" {
+		c.Family = families[family]
+	}
+	switch family {
+	case afInet:
+		if len(b) < 8 {
+			return ""
+		}
+		port := binary.BigEndian.Uint16(b[2:])
+		return net.JoinHostPort(net.IP(b[4:8]).String(), strconv.Itoa(int(port)))
+	case afInet6:
+		if len(b) < 24 {
+			return ""
+		}
+		port := binary.BigEndian.Uint16(b[2:])
+		return net.JoinHostPort(net.IP(b[8:24]).String(), strconv.Itoa(int(port)))
+	case afUnix:
+		path := b[2:]
+		if len(path) > 0 && path[0] == 0 {
+			// Abstract socket
+			return "@" + string(trimZeros(path[1:]))
+		}
+		for i, ch := range path {
+			if ch == 0 {
+				return string(path[:i])
+			}
+		}
+		return string(path)
+	}
+	return ""
+}
+
+func trimZeros(b []byte) []byte {
+	for len(b) > 0 && b[len(b)-1] == 0 {
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+// Raw value of the arg of the trace by name, 0 if not found
+func arg(trace *libtrace.Trace, name string) uint64 {
+	for i, a := range trace.Signature.Args {
+		if a.Name == name && i < len(trace.Args) {
+			return trace.Args[i].Raw
+		}
+	}
+	return 0
+}
+
+func fdArg(trace *libtrace.Trace, name string) int {
+	return int(int32(arg(trace, name)))
+}
@@ -0,0 +1,529 @@
+// Package otlp exports the syscalls as OpenTelemetry spans, with the
+// OTLP/HTTP JSON protocol.
+//
+//	e := otlp.NewExporter(otlp.Options{ServiceName: "myservice"})
+//	tracer.RegisterGlobalCbOnExit(e.Trace)
+//	tracer.RegisterEventCb(e.Event)
+//	tracer.Run()
+//	err := e.Close()
+//
+// Each process has a root span, from its first syscall (or its fork)
+// to its exit, with the syscalls as child spans. The root span of a
+// forked process is a child of the root span of its parent, in the
+// same trace.
+// The spans are sent in batches by a goroutine, the spans are dropped
+// when the queue is full.
+package otlp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	mrand "math/rand"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jfrabaute/libtrace"
+	"github.com/jfrabaute/libtrace/internal/procfs"
+)
+
+// Decides if the span of an exit trace is exported, traceId is the
+// trace of its process (in hex). The root spans are always exported.
+type Sampler func(traceId string, trace *libtrace.Trace) bool
+
+// Export all the syscalls
+func SampleAll(traceId string, trace *libtrace.Trace) bool {
+	return true
+}
+
+// Export the syscalls of a part of the traces, between 0 and 1.
+// The decision only depends on the trace id (like the TraceIdRatioBased
+// sampler of OpenTelemetry): the syscalls of a process tree are all
+// exported, or all dropped.
+func SampleRatio(ratio float64) Sampler {
+	var bound uint64
+	switch {
+	case ratio >= 1:
+		bound = math.MaxUint64
+	case ratio > 0:
+		bound = uint64(ratio * (1 << 63))
+	}
+	return func(traceId string, trace *libtrace.Trace) bool {
+		if len(traceId) < 16 {
+			return false
+		}
+		// The low 64 bits of the id
+		x, err := strconv.ParseUint(traceId[len(traceId)-16:], 16, 64)
+		return err == nil && x>>1 < bound
+	}
+}
+
+type Options struct {
+	Endpoint      string // Default: http://localhost:4318/v1/traces
+	Headers       map[string]string
+	ServiceName   string        // Default: "libtrace"
+	BatchSize     int           // Max spans per request, default: 512
+	MaxQueueSize  int           // Default: 8192
+	FlushInterval time.Duration // Default: 5s
+	Sampler       Sampler       // Default: SampleAll
+	Client        *http.Client  // Default: a client with a timeout of 10s
+}
+
+type Exporter struct {
+	opts    Options
+	lock    sync.Mutex
+	queue   []queued
+	dropped uint64
+	err     error // First export error
+	closed  bool
+	last    time.Time
+	tgids   map[int]int      // Process id of the threads
+	threads map[int]int      // Number of threads in tgids, by process id
+	procs   map[int]*process // Processes with a root span, by process id
+	// Processes whose root span has ended, by process id: their threads
+	// still running keep the trace of the process, until the last one exits
+	ended   map[int]*process
+	kick    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+type process struct {
+	traceId  string
+	spanId   string
+	parentId string
+	start    time.Time
+	pid      int
+	path     string
+	resource *resource
+}
+
+type queued struct {
+	resource *resource
+	span     *span
+}
+
+// OTLP JSON encoding
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type span struct {
+	TraceId           string     `json:"traceId"`
+	SpanId            string     `json:"spanId"`
+	ParentSpanId      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            *status    `json:"status,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"` // int64 are strings in JSON
+}
+
+type status struct {
+	Message string `json:"message,omitempty"`
+	Code    int    `json:"code"`
+}
+
+type resourceSpans struct {
+	Resource   *resource    `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type scopeSpans struct {
+	Scope scope   `json:"scope"`
+	Spans []*span `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+const (
+	spanKindInternal = 1
+	statusError      = 2
+)
+
+func NewExporter(opts Options) *Exporter {
+	if opts.Endpoint == "" {
+		opts.Endpoint = "http://localhost:4318/v1/traces"
+	}
+	if opts.ServiceName == "" {
+		opts.ServiceName = "libtrace"
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 512
+	}
+	if opts.MaxQueueSize <= 0 {
+		opts.MaxQueueSize = 8192
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 5 * time.Second
+	}
+	if opts.Sampler == nil {
+		opts.Sampler = SampleAll
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	e := &Exporter{
+		opts:    opts,
+		tgids:   make(map[int]int),
+		threads: make(map[int]int),
+		procs:   make(map[int]*process),
+		ended:   make(map[int]*process),
+		kick:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Export the syscall, to register on exit
+func (e *Exporter) Trace(trace *libtrace.Trace) {
+	if !trace.Exit {
+		return
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed {
+		return
+	}
+	e.see(trace.Time)
+	start := trace.Time.Add(-trace.Duration)
+	p := e.process(e.tgid(trace.Pid), start)
+	if !e.opts.Sampler(p.traceId, trace) {
+		return
+	}
+
+	s := &span{
+		TraceId:           p.traceId,
+		SpanId:            newId(8),
+		ParentSpanId:      p.spanId,
+		Name:              trace.Name,
+		Kind:              spanKindInternal,
+		StartTimeUnixNano: unixNano(start),
+		EndTimeUnixNano:   unixNano(trace.Time),
+		Attributes: []keyValue{
+			intAttribute("thread.id", int64(trace.Pid)),
+			intAttribute("syscall.id", int64(trace.Id)),
+			intAttribute("syscall.return", int64(trace.Return.Code)),
+		},
+	}
+	for i, arg := range trace.Args {
+		if i < len(trace.Signature.Args) {
+			s.Attributes = append(s.Attributes, stringAttribute("syscall.arg."+trace.Signature.Args[i].Name, arg.Str))
+		}
+	}
+	if trace.Return.Failed() {
+		s.Status = &status{Code: statusError, Message: trace.Return.Description}
+	}
+	e.enqueue(p.resource, s)
+}
+
+// Follow the processes, to register as an event callback
+func (e *Exporter) Event(event *libtrace.Event) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed {
+		return
+	}
+	e.see(event.Time)
+
+	switch event.Type {
+	case libtrace.EventFork, libtrace.EventVfork:
+		parent := e.process(e.tgid(event.Pid), event.Time)
+		e.setTgid(event.ChildPid, event.ChildPid)
+		// The pid of a process ended is reused
+		delete(e.ended, event.ChildPid)
+		if _, ok := e.procs[event.ChildPid]; !ok {
+			p := &process{
+				traceId:  parent.traceId,
+				spanId:   newId(8),
+				parentId: parent.spanId,
+				start:    event.Time,
+				pid:      event.ChildPid,
+				path:     parent.path,
+			}
+			p.resource = e.resource(p)
+			e.procs[event.ChildPid] = p
+		}
+	case libtrace.EventClone:
+		// The threads are created with clone, the processes with fork or vfork
+		e.setTgid(event.ChildPid, e.tgid(event.Pid))
+	case libtrace.EventExec:
+		e.setTgid(event.Pid, event.Pid)
+		if event.FormerPid != 0 {
+			e.deleteThread(event.FormerPid)
+		}
+		p := e.process(event.Pid, event.Time)
+		p.path = event.Path
+		p.resource = e.resource(p)
+	case libtrace.EventExit:
+		if p, ok := e.procs[event.Pid]; ok {
+			e.end(p, event.Time)
+		}
+		e.deleteThread(event.Pid)
+	}
+}
+
+// Spans dropped because the queue was full
+func (e *Exporter) Dropped() uint64 {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.dropped
+}
+
+// End the root spans, export the spans queued, and return the
+// first export error
+func (e *Exporter) Close() error {
+	e.lock.Lock()
+	if !e.closed {
+		e.closed = true
+		for _, p := range e.procs {
+			e.end(p, e.last)
+		}
+		close(e.done)
+	}
+	e.lock.Unlock()
+
+	<-e.stopped
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.err
+}
+
+func (e *Exporter) see(t time.Time) {
+	if t.After(e.last) {
+		e.last = t
+	}
+}
+
+func (e *Exporter) tgid(tid int) int {
+	if tgid, ok := e.tgids[tid]; ok {
+		return tgid
+	}
+	// The first stop of a thread can come before the clone event
+	tgid := procfs.Tgid(tid)
+	e.setTgid(tid, tgid)
+	return tgid
+}
+
+func (e *Exporter) setTgid(tid, tgid int) {
+	if old, ok := e.tgids[tid]; ok {
+		if old == tgid {
+			return
+		}
+		e.deleteThread(tid)
+	}
+	e.tgids[tid] = tgid
+	e.threads[tgid]++
+}
+
+// Forget the thread exited, and its process once the last thread has
+// exited: no more spans in its trace
+func (e *Exporter) deleteThread(tid int) {
+	tgid, ok := e.tgids[tid]
+	if !ok {
+		return
+	}
+	delete(e.tgids, tid)
+	e.threads[tgid]--
+	if e.threads[tgid] > 0 {
+		return
+	}
+	delete(e.threads, tgid)
+	delete(e.ended, tgid)
+}
+
+// Process with a root span, started at t if new
+func (e *Exporter) process(pid int, t time.Time) *process {
+	if p, ok := e.procs[pid]; ok {
+		return p
+	}
+	if p, ok := e.ended[pid]; ok {
+		return p
+	}
+	p := &process{
+		traceId: newId(16),
+		spanId:  newId(8),
+		start:   t,
+		pid:     pid,
+		path:    procfs.Exe(pid),
+	}
+	p.resource = e.resource(p)
+	e.procs[pid] = p
+	return p
+}
+
+func (e *Exporter) resource(p *process) *resource {
+	r := &resource{Attributes: []keyValue{
+		stringAttribute("service.name", e.opts.ServiceName),
+		intAttribute("process.pid", int64(p.pid)),
+	}}
+	if p.path != "" {
+		r.Attributes = append(r.Attributes,
+			stringAttribute("process.executable.path", p.path),
+			stringAttribute("process.executable.name", filepath.Base(p.path)))
+	}
+	return r
+}
+
+// End the root span of the process
+func (e *Exporter) end(p *process, t time.Time) {
+	name := "process"
+	if p.path != "" {
+		name += " " + filepath.Base(p.path)
+	}
+	e.enqueue(p.resource, &span{
+		TraceId:           p.traceId,
+		SpanId:            p.spanId,
+		ParentSpanId:      p.parentId,
+		Name:              name,
+		Kind:              spanKindInternal,
+		StartTimeUnixNano: unixNano(p.start),
+		EndTimeUnixNano:   unixNano(t),
+	})
+	delete(e.procs, p.pid)
+	e.ended[p.pid] = p
+}
+
+func (e *Exporter) enqueue(r *resource, s *span) {
+	if len(e.queue) >= e.opts.MaxQueueSize {
+		e.dropped++
+		return
+	}
+	e.queue = append(e.queue, queued{resource: r, span: s})
+	if len(e.queue) >= e.opts.BatchSize {
+		select {
+		case e.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *Exporter) run() {
+	defer close(e.stopped)
+	ticker := time.NewTicker(e.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.kick:
+		case <-ticker.C:
+		case <-e.done:
+			e.export()
+			return
+		}
+		e.export()
+	}
+}
+
+// Send the spans queued, by batches
+func (e *Exporter) export() {
+	for {
+		e.lock.Lock()
+		n := len(e.queue)
+		if n > e.opts.BatchSize {
+			n = e.opts.BatchSize
+		}
+		batch := e.queue[:n:n]
+		e.queue = e.queue[n:]
+		e.lock.Unlock()
+		if n == 0 {
+			return
+		}
+
+		err := e.send(batch)
+		if err != nil {
+			e.lock.Lock()
+			if e.err == nil {
+				e.err = err
+			}
+			e.lock.Unlock()
+		}
+	}
+}
+
+func (e *Exporter) send(batch []queued) error {
+	var request struct {
+		ResourceSpans []*resourceSpans `json:"resourceSpans"`
+	}
+	byResource := make(map[*resource]*resourceSpans)
+	for _, q := range batch {
+		rs, ok := byResource[q.resource]
+		if !ok {
+			rs = &resourceSpans{
+				Resource:   q.resource,
+				ScopeSpans: []scopeSpans{{Scope: scope{Name: "libtrace"}}},
+			}
+			byResource[q.resource] = rs
+			request.ResourceSpans = append(request.ResourceSpans, rs)
+		}
+		rs.ScopeSpans[0].Spans = append(rs.ScopeSpans[0].Spans, q.span)
+	}
+	body, err := json.Marshal(&request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", e.opts.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.opts.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("otlp: export failed: %s", resp.Status)
+	}
+	return nil
+}
+
+func newId(size int) string {
+	id := make([]byte, size)
+	if _, err := rand.Read(id); err != nil {
+		// Not random, but not zero (invalid ids)
+		for i := range id {
+			id[i] = byte(mrand.Intn(math.MaxUint8) + 1)
+		}
+	}
+	return hex.EncodeToString(id)
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func stringAttribute(key, value string) keyValue {
+	return keyValue{Key: key, Value: anyValue{StringValue: &value}}
+}
+
+func intAttribute(key string, value int64) keyValue {
+	v := strconv.FormatInt(value, 10)
+	return keyValue{Key: key, Value: anyValue{IntValue: &v}}
+}
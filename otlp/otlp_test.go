@@ -0,0 +1,252 @@
+package otlp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jfrabaute/libtrace"
+)
+
+// Local stand-in of an OTLP collector
+type collector struct {
+	*httptest.Server
+	lock     sync.Mutex
+	requests [][]*span
+	spans    []*span
+	received chan struct{}
+}
+
+func newCollector(t *testing.T) *collector {
+	c := &collector{received: make(chan struct{}, 100)}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("X-Token") != "secret" {
+			t.Errorf("request %s with %v", r.Method, r.Header)
+		}
+		var request struct {
+			ResourceSpans []resourceSpans `json:"resourceSpans"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("request body: %s", err)
+		}
+		var spans []*span
+		for _, rs := range request.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+		c.lock.Lock()
+		c.requests = append(c.requests, spans)
+		c.spans = append(c.spans, spans...)
+		c.lock.Unlock()
+		c.received <- struct{}{}
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func newTestExporter(c *collector, batchSize int, sampler Sampler) *Exporter {
+	return NewExporter(Options{
+		Endpoint:      c.URL,
+		Headers:       map[string]string{"X-Token": "secret"},
+		BatchSize:     batchSize,
+		FlushInterval: time.Hour,
+		Sampler:       sampler,
+	})
+}
+
+var start = time.Unix(1700000000, 0)
+
+func exitTrace(pid int, name string, ret libtrace.ReturnCode, description string) *libtrace.Trace {
+	return &libtrace.Trace{
+		Signature: &libtrace.Signature{Id: 2, Name: name, Args: []libtrace.Arg{{Name: "pathname"}, {Name: "flags"}}},
+		Pid:       pid,
+		Args:      []libtrace.ArgValue{{Str: `"/etc/hosts"`}, {Str: "O_RDONLY"}},
+		Return:    libtrace.ReturnValue{Code: ret, Description: description},
+		Exit:      true,
+		Time:      start.Add(time.Second),
+		Duration:  time.Millisecond,
+	}
+}
+
+func exec(e *Exporter, pid int) {
+	e.Event(&libtrace.Event{Type: libtrace.EventExec, Pid: pid, Time: start, Path: "/bin/app"})
+}
+
+func TestBatching(t *testing.T) {
+	c := newCollector(t)
+	e := newTestExporter(c, 2, nil)
+	pid := os.Getpid()
+	exec(e, pid)
+	e.Trace(exitTrace(pid, "open", 3, ""))
+	e.Trace(exitTrace(pid, "open", 4, ""))
+
+	// A full batch is sent without waiting for the flush interval
+	select {
+	case <-c.received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch not sent")
+	}
+	e.Trace(exitTrace(pid, "open", 5, ""))
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The last syscall and the root span are flushed on Close
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.requests) != 2 || len(c.requests[0]) != 2 || len(c.requests[1]) != 2 {
+		t.Fatalf("requests %v, want 2 batches of 2 spans", c.requests)
+	}
+	root := c.requests[1][1]
+	if root.Name != "process app" || root.ParentSpanId != "" || root.EndTimeUnixNano != unixNano(start.Add(time.Second)) {
+		t.Errorf("root span %+v", root)
+	}
+	for _, s := range c.spans[:3] {
+		if s.TraceId != root.TraceId || s.ParentSpanId != root.SpanId {
+			t.Errorf("span %+v not a child of %+v", s, root)
+		}
+	}
+	if e.Dropped() != 0 {
+		t.Errorf("%d spans dropped", e.Dropped())
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	c := newCollector(t)
+	e := newTestExporter(c, 512, nil)
+	pid := os.Getpid()
+	exec(e, pid)
+	e.Trace(exitTrace(pid, "open", -2, "ENOENT (No such file or directory)"))
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.spans) != 2 {
+		t.Fatalf("%d spans, want 2", len(c.spans))
+	}
+
+	data, err := json.Marshal(c.spans[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := c.spans[0]
+	want := fmt.Sprintf(`{"traceId":"%s","spanId":"%s","parentSpanId":"%s","name":"open","kind":1,`+
+		`"startTimeUnixNano":"1700000000999000000","endTimeUnixNano":"1700000001000000000",`+
+		`"attributes":[{"key":"thread.id","value":{"intValue":"%d"}},`+
+		`{"key":"syscall.id","value":{"intValue":"2"}},`+
+		`{"key":"syscall.return","value":{"intValue":"-2"}},`+
+		`{"key":"syscall.arg.pathname","value":{"stringValue":"\"/etc/hosts\""}},`+
+		`{"key":"syscall.arg.flags","value":{"stringValue":"O_RDONLY"}}],`+
+		`"status":{"message":"ENOENT (No such file or directory)","code":2}}`,
+		s.TraceId, s.SpanId, s.ParentSpanId, pid)
+	if string(data) != want {
+		t.Errorf("span\n%s\nwant\n%s", data, want)
+	}
+	if len(s.TraceId) != 32 || len(s.SpanId) != 16 {
+		t.Errorf("ids %s %s", s.TraceId, s.SpanId)
+	}
+
+	// The root span has no status, nor attributes
+	data, err = json.Marshal(c.spans[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var root map[string]interface{}
+	json.Unmarshal(data, &root)
+	if _, ok := root["status"]; ok {
+		t.Errorf("root span %s with a status", data)
+	}
+	if _, ok := root["attributes"]; ok {
+		t.Errorf("root span %s with attributes", data)
+	}
+}
+
+func TestSampleRatio(t *testing.T) {
+	half := SampleRatio(0.5)
+	none := SampleRatio(0)
+	all := SampleRatio(1)
+	sampled := 0
+	const n = 10000
+	for i := 0; i < n; i++ {
+		id := newId(16)
+		first := half(id, nil)
+		for j := 0; j < 10; j++ {
+			if half(id, nil) != first {
+				t.Fatalf("SampleRatio(0.5) changes its decision for %s", id)
+			}
+		}
+		if first {
+			sampled++
+		}
+		if none(id, nil) || !all(id, nil) {
+			t.Fatalf("SampleRatio(0) or SampleRatio(1) wrong for %s", id)
+		}
+	}
+	if sampled < n*45/100 || sampled > n*55/100 {
+		t.Errorf("SampleRatio(0.5) sampled %d of %d traces", sampled, n)
+	}
+
+	// Bound on the low 64 bits, shifted right by 1
+	if !half("00000000000000007fffffffffffffff", nil) || half("00000000000000008000000000000000", nil) {
+		t.Error("SampleRatio(0.5) bound")
+	}
+	if half("", nil) {
+		t.Error("SampleRatio(0.5) sampled an invalid id")
+	}
+}
+
+// A thread still running after the exit of the main thread stays in the
+// trace of its process
+func TestThreadAfterRootEnd(t *testing.T) {
+	c := newCollector(t)
+	e := newTestExporter(c, 512, nil)
+	pid := os.Getpid()
+	tid := pid + 1
+	exec(e, pid)
+	e.Event(&libtrace.Event{Type: libtrace.EventClone, Pid: pid, ChildPid: tid, Time: start})
+	e.Event(&libtrace.Event{Type: libtrace.EventExit, Pid: pid, Time: start.Add(time.Second)})
+	e.Trace(exitTrace(tid, "write", 1, ""))
+
+	// Forgotten once the last thread has exited
+	e.Event(&libtrace.Event{Type: libtrace.EventExit, Pid: tid, Time: start.Add(2 * time.Second)})
+	e.lock.Lock()
+	if len(e.ended) != 0 || len(e.tgids) != 0 || len(e.threads) != 0 {
+		t.Errorf("process not forgotten: ended %v, tgids %v, threads %v", e.ended, e.tgids, e.threads)
+	}
+	e.lock.Unlock()
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.spans) != 2 {
+		t.Fatalf("%d spans, want the root span and the syscall", len(c.spans))
+	}
+	root, s := c.spans[0], c.spans[1]
+	if s.TraceId != root.TraceId || s.ParentSpanId != root.SpanId {
+		t.Errorf("span %+v not a child of the root span %+v", s, root)
+	}
+}
+
+func TestSampledOut(t *testing.T) {
+	c := newCollector(t)
+	e := newTestExporter(c, 512, SampleRatio(0))
+	pid := os.Getpid()
+	exec(e, pid)
+	e.Trace(exitTrace(pid, "open", 3, ""))
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.spans) != 1 || c.spans[0].Name != "process app" {
+		t.Errorf("spans %v, want only the root span", c.spans)
+	}
+}
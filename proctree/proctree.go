@@ -0,0 +1,284 @@
+// Package proctree builds the tree of the traced processes, with their
+// command lines and their execs, from the process events.
+//
+//	tree := proctree.New(proctree.Options{})
+//	tracer.SetFollowForks(true)
+//	tracer.RegisterEventCb(tree.Event)
+//	tracer.RegisterGlobalCbOnEnter(tree.Trace) // Optional, see Tree.Trace
+//	tracer.Run()
+//	tree.Print(os.Stdout)
+//
+// The command lines, the current directories and the environments are
+// read from /proc at the exec and when a process is first seen, they are
+// not known on a replay. The tree is read once Run returned.
+package proctree
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jfrabaute/libtrace"
+	"github.com/jfrabaute/libtrace/internal/procfs"
+)
+
+type Options struct {
+	Env bool // Read the environment of the processes
+}
+
+// Process
+type Node struct {
+	Pid      int
+	Parent   *Node // Nil for the roots
+	Children []*Node
+	Threads  []int // Other tasks of the process
+	Exe      string
+	Argv     []string
+	Cwd      string
+	Env      []string // With Options.Env
+	Execs    []Exec   // Exec history, the last one is the current program
+	Start    time.Time
+	End      time.Time
+	Exited   bool
+	ExitCode int            // If exited without a signal
+	Signal   syscall.Signal // If killed
+	CoreDump bool
+}
+
+type Exec struct {
+	Time time.Time
+	Path string
+	Argv []string
+	Cwd  string
+	Env  []string
+}
+
+type Tree struct {
+	opts  Options
+	lock  sync.Mutex
+	roots []*Node
+	procs map[int]*Node // Running processes
+	tgids map[int]int   // Process id of the threads
+}
+
+func New(opts Options) *Tree {
+	return &Tree{
+		opts:  opts,
+		procs: make(map[int]*Node),
+		tgids: make(map[int]int),
+	}
+}
+
+// Notice the processes when they are first seen, to register on enter.
+// Without it, a process started before the tracing is only known at its
+// first event, like its exit.
+func (t *Tree) Trace(trace *libtrace.Trace) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.process(t.tgid(trace.Pid), trace.Time)
+}
+
+// Build the tree, to register as an event callback
+func (t *Tree) Event(event *libtrace.Event) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	switch event.Type {
+	case libtrace.EventFork, libtrace.EventVfork:
+		parent := t.process(t.tgid(event.Pid), event.Time)
+		t.tgids[event.ChildPid] = event.ChildPid
+		if child, ok := t.procs[event.ChildPid]; ok {
+			// The child stopped before the fork event
+			if child.Parent == nil {
+				t.removeRoot(child)
+				t.adopt(parent, child)
+			}
+			return
+		}
+		child := &Node{
+			Pid:   event.ChildPid,
+			Exe:   parent.Exe,
+			Argv:  parent.Argv,
+			Cwd:   parent.Cwd,
+			Env:   parent.Env,
+			Start: event.Time,
+		}
+		t.adopt(parent, child)
+		t.procs[child.Pid] = child
+	case libtrace.EventClone:
+		// The threads are created with clone, the processes with fork or vfork
+		tgid := t.tgid(event.Pid)
+		p := t.process(tgid, event.Time)
+		t.tgids[event.ChildPid] = tgid
+		p.Threads = append(p.Threads, event.ChildPid)
+	case libtrace.EventExec:
+		if event.FormerPid != 0 {
+			delete(t.tgids, event.FormerPid)
+		}
+		t.tgids[event.Pid] = event.Pid
+		p := t.process(event.Pid, event.Time)
+		exec := Exec{
+			Time: event.Time,
+			Path: event.Path,
+			Argv: procfs.Cmdline(event.Pid),
+			Cwd:  procfs.Cwd(event.Pid),
+		}
+		if t.opts.Env {
+			exec.Env = procfs.Environ(event.Pid)
+		}
+		p.Execs = append(p.Execs, exec)
+		p.Exe, p.Argv, p.Cwd, p.Env = exec.Path, exec.Argv, exec.Cwd, exec.Env
+	case libtrace.EventExit:
+		if p, ok := t.procs[event.Pid]; ok {
+			p.End = event.Time
+			p.Exited = true
+			p.ExitCode = event.ExitCode
+			p.Signal = event.Signal
+			p.CoreDump = event.CoreDump
+			delete(t.procs, event.Pid)
+		}
+		delete(t.tgids, event.Pid)
+	}
+}
+
+// Processes without a traced parent
+func (t *Tree) Roots() []*Node {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]*Node(nil), t.roots...)
+}
+
+// Call fn for the processes, depth first, the children in creation order.
+// The children of a node are skipped if fn returns false.
+func (t *Tree) Walk(fn func(node *Node, depth int) bool) {
+	for _, root := range t.Roots() {
+		walk(root, 0, fn)
+	}
+}
+
+func walk(node *Node, depth int, fn func(node *Node, depth int) bool) {
+	if !fn(node, depth) {
+		return
+	}
+	for _, child := range node.Children {
+		walk(child, depth+1, fn)
+	}
+}
+
+// Print the tree like pstree -a -p:
+//
+//	bash,100 -c make
+//	  └─make,101
+//	      ├─cc,102 -c a.c
+//	      └─cc,103 -c b.c (exit 1)
+func (t *Tree) Print(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, root := range t.Roots() {
+		printNode(bw, root, "", "")
+	}
+	return bw.Flush()
+}
+
+func printNode(w *bufio.Writer, node *Node, prefix, branch string) {
+	w.WriteString(prefix + branch + node.String() + "\n")
+	switch branch {
+	case "├─":
+		prefix += "│ "
+	case "└─":
+		prefix += "  "
+	}
+	for i, child := range node.Children {
+		if i == len(node.Children)-1 {
+			printNode(w, child, prefix, "└─")
+		} else {
+			printNode(w, child, prefix, "├─")
+		}
+	}
+}
+
+// Name, pid, args and exit status, like "cc,102 -c a.c (exit 1)"
+func (n *Node) String() string {
+	name := filepath.Base(n.Exe)
+	if n.Exe == "" {
+		name = "?"
+		if len(n.Argv) > 0 {
+			name = filepath.Base(n.Argv[0])
+		}
+	}
+	s := name + "," + strconv.Itoa(n.Pid)
+	if len(n.Argv) > 1 {
+		s += " " + strings.Join(quoteArgs(n.Argv[1:]), " ")
+	}
+	switch {
+	case n.Exited && n.Signal != 0:
+		s += " (" + libtrace.SignalName(n.Signal)
+		if n.CoreDump {
+			s += ", core dumped"
+		}
+		s += ")"
+	case n.Exited && n.ExitCode != 0:
+		s += fmt.Sprintf(" (exit %d)", n.ExitCode)
+	}
+	return s
+}
+
+func quoteArgs(args []string) []string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\") {
+			arg = strconv.Quote(arg)
+		}
+		quoted[i] = arg
+	}
+	return quoted
+}
+
+func (t *Tree) tgid(tid int) int {
+	if tgid, ok := t.tgids[tid]; ok {
+		return tgid
+	}
+	// The first stop of a thread can come before the clone event
+	tgid := procfs.Tgid(tid)
+	t.tgids[tid] = tgid
+	return tgid
+}
+
+// Process, a new root if unknown
+func (t *Tree) process(pid int, now time.Time) *Node {
+	if p, ok := t.procs[pid]; ok {
+		return p
+	}
+	p := &Node{
+		Pid:   pid,
+		Exe:   procfs.Exe(pid),
+		Argv:  procfs.Cmdline(pid),
+		Cwd:   procfs.Cwd(pid),
+		Start: now,
+	}
+	if t.opts.Env {
+		p.Env = procfs.Environ(pid)
+	}
+	t.roots = append(t.roots, p)
+	t.procs[pid] = p
+	return p
+}
+
+func (t *Tree) adopt(parent, child *Node) {
+	child.Parent = parent
+	parent.Children = append(parent.Children, child)
+}
+
+func (t *Tree) removeRoot(node *Node) {
+	for i, root := range t.roots {
+		if root == node {
+			t.roots = append(t.roots[:i], t.roots[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package strace
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jfrabaute/libtrace"
+)
+
+// Symbolic name of a flag bit or of a value
+type flag struct {
+	value uint64
+	name  string
+}
+
+const atFdcwd = -100
+
+var openFlags = []flag{
+	{0100, "O_CREAT"},
+	{0200, "O_EXCL"},
+	{0400, "O_NOCTTY"},
+	{01000, "O_TRUNC"},
+	{02000, "O_APPEND"},
+	{04000, "O_NONBLOCK"},
+	{04010000, "O_SYNC"},
+	{010000, "O_DSYNC"},
+	{020000, "O_ASYNC"},
+	{040000, "O_DIRECT"},
+	{0100000, "O_LARGEFILE"},
+	{0400000, "O_NOFOLLOW"},
+	{01000000, "O_NOATIME"},
+	{02000000, "O_CLOEXEC"},
+	{010000000, "O_PATH"},
+	{020200000, "O_TMPFILE"},
+	{0200000, "O_DIRECTORY"},
+}
+
+var openAccModes = []string{"O_RDONLY", "O_WRONLY", "O_RDWR", "O_ACCMODE"}
+
+var accessModes = []flag{
+	{4, "R_OK"},
+	{2, "W_OK"},
+	{1, "X_OK"},
+}
+
+var atFlags = []flag{
+	{0x100, "AT_SYMLINK_NOFOLLOW"},
+	{0x200, "AT_REMOVEDIR"},
+	{0x400, "AT_SYMLINK_FOLLOW"},
+	{0x800, "AT_NO_AUTOMOUNT"},
+	{0x1000, "AT_EMPTY_PATH"},
+}
+
+var mmapProts = []flag{
+	{1, "PROT_READ"},
+	{2, "PROT_WRITE"},
+	{4, "PROT_EXEC"},
+}
+
+var mmapFlags = []flag{
+	{0x01, "MAP_SHARED"},
+	{0x02, "MAP_PRIVATE"},
+	{0x10, "MAP_FIXED"},
+	{0x20, "MAP_ANONYMOUS"},
+	{0x40, "MAP_32BIT"},
+	{0x100, "MAP_GROWSDOWN"},
+	{0x800, "MAP_DENYWRITE"},
+	{0x1000, "MAP_EXECUTABLE"},
+	{0x2000, "MAP_LOCKED"},
+	{0x4000, "MAP_NORESERVE"},
+	{0x8000, "MAP_POPULATE"},
+	{0x10000, "MAP_NONBLOCK"},
+	{0x20000, "MAP_STACK"},
+	{0x40000, "MAP_HUGETLB"},
+	{0x100000, "MAP_FIXED_NOREPLACE"},
+}
+
+var seekWhences = []string{"SEEK_SET", "SEEK_CUR", "SEEK_END", "SEEK_DATA", "SEEK_HOLE"}
+
+// Formatters of the args, by syscall and arg name
+var argFormatters = map[string]func(v uint64) string{
+	"open.flags":             formatOpenFlags,
+	"openat.flags":           formatOpenFlags,
+	"open.mode":              formatMode,
+	"openat.mode":            formatMode,
+	"creat.mode":             formatMode,
+	"mkdir.mode":             formatMode,
+	"mkdirat.mode":           formatMode,
+	"chmod.mode":             formatMode,
+	"fchmod.mode":            formatMode,
+	"fchmodat.mode":          formatMode,
+	"umask.mask":             formatMode,
+	"access.mode":            formatAccessMode,
+	"faccessat.mode":         formatAccessMode,
+	"newfstatat.flag":        formatAtFlags,
+	"fstatat64.flag":         formatAtFlags,
+	"unlinkat.flag":          formatAtFlags,
+	"linkat.flags":           formatAtFlags,
+	"fchownat.flag":          formatAtFlags,
+	"mmap.addr":              formatAddress,
+	"mmap2.addr":             formatAddress,
+	"mmap.prot":              formatMmapProt,
+	"mmap2.prot":             formatMmapProt,
+	"mprotect.prot":          formatMmapProt,
+	"mmap.flags":             formatMmapFlags,
+	"mmap2.flags":            formatMmapFlags,
+	"mmap.fd":                formatInt,
+	"mmap2.fd":               formatInt,
+	"mmap.off":               formatHex,
+	"mprotect.start":         formatAddress,
+	"munmap.addr":            formatAddress,
+	"brk.brk":                formatAddress,
+	"lseek.origin":           formatWhence,
+	"lseek.whence":           formatWhence,
+	"arch_prctl.addr":        formatAddress,
+	"set_tid_address.tidptr": formatAddress,
+}
+
+// Args holding a directory fd
+var dirFdArgs = map[string]bool{
+	"dfd":   true,
+	"dirfd": true,
+}
+
+// Args holding a directory fd in the *at syscalls (renameat, linkat...),
+// not in dup2 or dup3
+var atDirFdArgs = map[string]bool{
+	"oldfd": true,
+	"newfd": true,
+}
+
+func isDirFd(syscall string, name string) bool {
+	return dirFdArgs[name] ||
+		atDirFdArgs[name] && (strings.HasSuffix(syscall, "at") || strings.HasSuffix(syscall, "at2"))
+}
+
+// Format the args of an exit trace
+func formatArgs(trace *libtrace.Trace) string {
+	return formatArgRange(trace, 0, len(trace.Args))
+}
+
+// Format the args from to end (excluded) of a trace
+func formatArgRange(trace *libtrace.Trace, from, end int) string {
+	if trace.Signature.Args == nil {
+		return "..."
+	}
+	sig := trace.Signature.Args
+	strs := make([]string, 0, end-from)
+	for i := from; i < end && i < len(trace.Args); i++ {
+		arg := trace.Args[i]
+		if i >= len(sig) {
+			break
+		}
+		if (trace.Name == "open" || trace.Name == "openat") && sig[i].Name == "mode" &&
+			i > 0 && trace.Args[i-1].Raw&(0100|020000000) == 0 {
+			// The mode is only used with O_CREAT or O_TMPFILE
+			continue
+		}
+		strs = append(strs, formatArg(trace.Name, sig[i], arg))
+	}
+	return strings.Join(strs, ", ")
+}
+
+func formatArg(syscall string, sig libtrace.Arg, arg libtrace.ArgValue) string {
+	if arg.Err != nil {
+		if arg.Str != "" {
+			return arg.Str
+		}
+		return formatHex(arg.Raw)
+	}
+	if fn, ok := argFormatters[syscall+"."+sig.Name]; ok {
+		return fn(arg.Raw)
+	}
+	if isDirFd(syscall, sig.Name) && int32(arg.Raw) == atFdcwd {
+		return "AT_FDCWD"
+	}
+
+	switch sig.Type.(type) {
+	case libtrace.StringC, libtrace.Buffer:
+		if arg.Str == "" {
+			return `""`
+		}
+		return arg.Str
+	// The int and uint of the signatures are the 32 bits C types
+	case int, int32:
+		return fmt.Sprintf("%d", int32(arg.Raw))
+	case int8:
+		return fmt.Sprintf("%d", int8(arg.Raw))
+	case int16:
+		return fmt.Sprintf("%d", int16(arg.Raw))
+	case int64:
+		return fmt.Sprintf("%d", int64(arg.Raw))
+	case uint, uint32:
+		return fmt.Sprintf("%d", uint32(arg.Raw))
+	case uint8:
+		return fmt.Sprintf("%d", uint8(arg.Raw))
+	case uint16:
+		return fmt.Sprintf("%d", uint16(arg.Raw))
+	case uint64, uintptr:
+		return fmt.Sprintf("%d", arg.Raw)
+	}
+	if reflect.TypeOf(sig.Type).Kind() == reflect.Ptr {
+		return formatAddress(arg.Raw)
+	}
+	return arg.Str
+}
+
+// Number of args before the first output arg (a buffer, or a pointer
+// to a number, written by the syscall): like strace, only these args
+// are printed at the enter of the syscall. The pointers to the structs
+// are printed as addresses, known at the enter.
+func inputArgs(sig []libtrace.Arg) int {
+	for i, arg := range sig {
+		if arg.Const || arg.Type == nil {
+			continue
+		}
+		switch arg.Type.(type) {
+		case libtrace.StringC, libtrace.Buffer:
+			return i
+		}
+		if typ := reflect.TypeOf(arg.Type); typ.Kind() == reflect.Ptr && typ.Elem().Kind() != reflect.Struct {
+			return i
+		}
+	}
+	return len(sig)
+}
+
+// Names of the flags set, with the unknown bits in hex
+func formatFlags(v uint64, flags []flag) string {
+	var names []string
+	for _, f := range flags {
+		if v&f.value == f.value {
+			names = append(names, f.name)
+			v &^= f.value
+		}
+	}
+	if v != 0 || len(names) == 0 {
+		names = append(names, formatHex(v))
+	}
+	return strings.Join(names, "|")
+}
+
+func formatOpenFlags(v uint64) string {
+	v = uint64(uint32(v))
+	str := openAccModes[v&3]
+	if v &^= 3; v != 0 {
+		str += "|" + formatFlags(v, openFlags)
+	}
+	return str
+}
+
+func formatAccessMode(v uint64) string {
+	if uint32(v) == 0 {
+		return "F_OK"
+	}
+	return formatFlags(uint64(uint32(v)), accessModes)
+}
+
+func formatAtFlags(v uint64) string {
+	if uint32(v) == 0 {
+		return "0"
+	}
+	return formatFlags(uint64(uint32(v)), atFlags)
+}
+
+func formatMmapProt(v uint64) string {
+	if v == 0 {
+		return "PROT_NONE"
+	}
+	return formatFlags(v, mmapProts)
+}
+
+func formatMmapFlags(v uint64) string {
+	return formatFlags(v, mmapFlags)
+}
+
+func formatWhence(v uint64) string {
+	if v < uint64(len(seekWhences)) {
+		return seekWhences[v]
+	}
+	return formatHex(v)
+}
+
+func formatMode(v uint64) string {
+	return fmt.Sprintf("%#03o", uint32(v))
+}
+
+func formatAddress(v uint64) string {
+	if v == 0 {
+		return "NULL"
+	}
+	return formatHex(v)
+}
+
+func formatHex(v uint64) string {
+	if v == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%#x", v)
+}
+
+func formatInt(v uint64) string {
+	return fmt.Sprintf("%d", int32(v))
+}
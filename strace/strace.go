@@ -0,0 +1,221 @@
+// Package strace formats the traces in the strace output format:
+//
+//	openat(AT_FDCWD, "/etc/passwd", O_RDONLY|O_CLOEXEC) = 3
+//
+// The formatter receives the enter and exit traces (or only the exit
+// traces) and prints one line per syscall, or an <unfinished ...> and a
+// <... resumed> line when the syscalls of several tasks are interleaved.
+//
+// The args of the enter traces are only printed (on the <unfinished ...>
+// lines, and for exit_group) when the tracer decodes them:
+//
+//	tracer.SetDecodeArgsOnEnter(true)
+package strace
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jfrabaute/libtrace"
+)
+
+// Format of the timestamps
+type TimeFormat int
+
+const (
+	NoTime        TimeFormat = iota
+	Time                     // -t: 15:04:05
+	TimeMicro                // -tt: 15:04:05.000000
+	TimeUnixMicro            // -ttt: 1136214245.000000
+)
+
+type Options struct {
+	Pids      bool       // Prefix the lines with [pid N] (-f)
+	Time      TimeFormat // Print the time of the syscalls (-t, -tt, -ttt)
+	Durations bool       // Print the time spent in the syscalls (-T)
+}
+
+// Column of the = of the return values
+const returnColumn = 40
+
+// Syscalls not returning when they succeed
+var noReturn = map[string]bool{
+	"exit":       true,
+	"exit_group": true,
+}
+
+type Formatter struct {
+	w    io.Writer
+	opts Options
+
+	lock       sync.Mutex
+	current    *libtrace.Trace         // Enter trace of the line being written
+	unfinished map[int]*libtrace.Trace // Enter traces printed <unfinished ...>
+	err        error                   // First write error
+}
+
+func NewFormatter(w io.Writer, opts Options) *Formatter {
+	return &Formatter{
+		w:          w,
+		opts:       opts,
+		unfinished: make(map[int]*libtrace.Trace),
+	}
+}
+
+// Format the trace, can be registered as a callback
+func (f *Formatter) Trace(trace *libtrace.Trace) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if !trace.Exit {
+		f.finishCurrent()
+		if noReturn[trace.Name] {
+			f.printNoReturn(trace)
+			return
+		}
+		f.current = trace
+		return
+	}
+
+	if f.current != nil && f.current.Pid == trace.Pid {
+		enter := f.current
+		f.current = nil
+		f.printf("%s\n", f.line(f.prefix(trace.Pid, enter.Time)+
+			trace.Name+"("+formatArgs(trace)+")", trace))
+		return
+	}
+	f.finishCurrent()
+
+	if enter, ok := f.unfinished[trace.Pid]; ok {
+		delete(f.unfinished, trace.Pid)
+		// The args not printed on the <unfinished ...> line
+		f.printf("%s\n", f.line(f.prefix(trace.Pid, trace.Time)+
+			"<... "+trace.Name+" resumed>"+formatArgRange(trace, enterArgCount(enter), len(trace.Args))+")", trace))
+		return
+	}
+	f.printf("%s\n", f.line(f.prefix(trace.Pid, trace.Time.Add(-trace.Duration))+
+		trace.Name+"("+formatArgs(trace)+")", trace))
+}
+
+// Print the syscall not finished yet, and return the first write error
+func (f *Formatter) Flush() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.current != nil {
+		trace := f.current
+		f.current = nil
+		f.printNoReturn(trace)
+	}
+	return f.err
+}
+
+// Print the syscall of an enter trace, without return value
+func (f *Formatter) printNoReturn(trace *libtrace.Trace) {
+	args, _ := enterArgs(trace, "...")
+	f.printf("%s= ?\n", column(f.prefix(trace.Pid, trace.Time)+trace.Name+"("+args+")"))
+}
+
+// Print the line being written as unfinished
+func (f *Formatter) finishCurrent() {
+	if f.current == nil {
+		return
+	}
+	trace := f.current
+	f.current = nil
+	f.unfinished[trace.Pid] = trace
+	args, more := enterArgs(trace, "")
+	if more && args != "" {
+		// Like strace: "read(3,  <unfinished ...>"
+		args += ", "
+	}
+	f.printf("%s%s(%s <unfinished ...>\n", f.prefix(trace.Pid, trace.Time), trace.Name, args)
+}
+
+func (f *Formatter) printf(format string, v ...interface{}) {
+	if _, err := fmt.Fprintf(f.w, format, v...); err != nil && f.err == nil {
+		f.err = err
+	}
+}
+
+func (f *Formatter) prefix(pid int, t time.Time) string {
+	var prefix string
+	if f.opts.Pids {
+		prefix = fmt.Sprintf("[pid %5d] ", pid)
+	}
+	switch f.opts.Time {
+	case Time:
+		prefix += t.Format("15:04:05 ")
+	case TimeMicro:
+		prefix += t.Format("15:04:05.000000 ")
+	case TimeUnixMicro:
+		prefix += fmt.Sprintf("%d.%06d ", t.Unix(), t.Nanosecond()/1000)
+	}
+	return prefix
+}
+
+// Pad the call up to the column of the return value
+func column(call string) string {
+	if len(call) < returnColumn {
+		return call + strings.Repeat(" ", returnColumn-len(call))
+	}
+	return call + " "
+}
+
+// Complete the call with the return value
+func (f *Formatter) line(call string, trace *libtrace.Trace) string {
+	line := column(call) + "= " + formatReturn(trace)
+	if f.opts.Durations {
+		line += fmt.Sprintf(" <%d.%06d>", trace.Duration/time.Second, (trace.Duration%time.Second)/time.Microsecond)
+	}
+	return line
+}
+
+// Input args of an enter trace, none if its args are not decoded.
+// more is true when output args follow.
+func enterArgs(trace *libtrace.Trace, none string) (args string, more bool) {
+	if len(trace.Args) == 0 {
+		return none, false
+	}
+	if trace.Signature.Args == nil {
+		return formatArgs(trace), false
+	}
+	n := enterArgCount(trace)
+	return formatArgRange(trace, 0, n), n < len(trace.Args)
+}
+
+// Number of args of an enter trace printed before <unfinished ...>
+func enterArgCount(trace *libtrace.Trace) int {
+	if len(trace.Args) == 0 || trace.Signature.Args == nil {
+		return 0
+	}
+	return inputArgs(trace.Signature.Args)
+}
+
+func formatReturn(trace *libtrace.Trace) string {
+	ret := trace.Return
+	if ret.Failed() {
+		if ret.Description != "" {
+			return "-1 " + ret.Description
+		}
+		return fmt.Sprintf("-1 %s", ret.Errno())
+	}
+	if addressReturn[trace.Name] {
+		if ret.Code >= 0 {
+			return fmt.Sprintf("%#x", uint64(ret.Code))
+		}
+		return fmt.Sprintf("%#x", uint32(ret.Code))
+	}
+	return fmt.Sprintf("%d", ret.Code)
+}
+
+// Syscalls returning an address
+var addressReturn = map[string]bool{
+	"brk":    true,
+	"mmap":   true,
+	"mmap2":  true,
+	"mremap": true,
+	"shmat":  true,
+}
@@ -0,0 +1,99 @@
+package strace
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jfrabaute/libtrace"
+)
+
+var (
+	openatSig = &libtrace.Signature{Id: 257, Name: "openat", Args: []libtrace.Arg{
+		{Name: "dfd", Type: int(0)},
+		{Name: "filename", Type: libtrace.StringC(""), Const: true},
+		{Name: "flags", Type: int(0)},
+		{Name: "mode", Type: int(0)},
+	}}
+	readSig = &libtrace.Signature{Id: 0, Name: "read", Args: []libtrace.Arg{
+		{Name: "fd", Type: uint(0)},
+		{Name: "buf", Type: libtrace.Buffer(-1)},
+		{Name: "count", Type: uint64(0)},
+	}}
+	closeSig = &libtrace.Signature{Id: 3, Name: "close", Args: []libtrace.Arg{
+		{Name: "fd", Type: uint(0)},
+	}}
+)
+
+var start = time.Unix(1700000000, 0)
+
+func trace(sig *libtrace.Signature, pid int, exit bool, ret libtrace.ReturnCode, args ...libtrace.ArgValue) *libtrace.Trace {
+	return &libtrace.Trace{
+		Signature: sig,
+		Pid:       pid,
+		Args:      args,
+		Return:    libtrace.ReturnValue{Code: ret},
+		Exit:      exit,
+		Time:      start,
+	}
+}
+
+func format(opts Options, traces ...*libtrace.Trace) []string {
+	var out bytes.Buffer
+	f := NewFormatter(&out, opts)
+	for _, trace := range traces {
+		f.Trace(trace)
+	}
+	f.Flush()
+	return strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+}
+
+func checkLines(t *testing.T, lines, want []string) {
+	t.Helper()
+	if len(lines) != len(want) {
+		t.Fatalf("%d lines:\n%s\nwant:\n%s", len(lines), strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+	for i := range lines {
+		if lines[i] != want[i] {
+			t.Errorf("line %d:\n%q\nwant\n%q", i, lines[i], want[i])
+		}
+	}
+}
+
+func TestOpenat(t *testing.T) {
+	lines := format(Options{}, trace(openatSig, 10, true, 3,
+		libtrace.ArgValue{Raw: 0xffffff9c},
+		libtrace.ArgValue{Str: `"/etc/passwd"`},
+		libtrace.ArgValue{Raw: 02000000},
+		libtrace.ArgValue{Raw: 0}))
+	checkLines(t, lines, []string{
+		`openat(AT_FDCWD, "/etc/passwd", O_RDONLY|O_CLOEXEC) = 3`,
+	})
+}
+
+// The syscalls of two tasks interleaved
+func TestUnfinished(t *testing.T) {
+	lines := format(Options{Pids: true},
+		trace(readSig, 10, false, 0, libtrace.ArgValue{Raw: 3}, libtrace.ArgValue{}, libtrace.ArgValue{Raw: 10}),
+		trace(closeSig, 11, false, 0, libtrace.ArgValue{Raw: 4}),
+		trace(closeSig, 11, true, 0, libtrace.ArgValue{Raw: 4}),
+		trace(readSig, 10, true, 5, libtrace.ArgValue{Raw: 3}, libtrace.ArgValue{Str: `"hello"`}, libtrace.ArgValue{Raw: 10}),
+	)
+	checkLines(t, lines, []string{
+		`[pid    10] read(3,  <unfinished ...>`,
+		`[pid    11] close(4)                    = 0`,
+		`[pid    10] <... read resumed>"hello", 10) = 5`,
+	})
+}
+
+func TestDurations(t *testing.T) {
+	closeTrace := trace(closeSig, 10, true, -9, libtrace.ArgValue{Raw: 42})
+	closeTrace.Return.Description = "EBADF (Bad file descriptor)"
+	closeTrace.Duration = 1500 * time.Microsecond
+	closeTrace.Time = start.Add(closeTrace.Duration)
+	lines := format(Options{Durations: true, Time: TimeUnixMicro}, closeTrace)
+	checkLines(t, lines, []string{
+		`1700000000.000000 close(42)             = -1 EBADF (Bad file descriptor) <0.001500>`,
+	})
+}
@@ -0,0 +1,196 @@
+// Package summary counts the syscalls and the time spent in them,
+// per process and overall, like strace -c.
+//
+//	s := summary.New()
+//	tracer.RegisterGlobalCbOnExit(s.Trace)
+//	tracer.RegisterEventCb(s.Event)
+//	tracer.Run()
+//	s.Report().WriteTable(os.Stderr)
+package summary
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jfrabaute/libtrace"
+	"github.com/jfrabaute/libtrace/internal/procfs"
+)
+
+// Statistics of a syscall
+type SyscallStats struct {
+	Name    string
+	Calls   uint64
+	Errors  uint64
+	Total   time.Duration
+	Min     time.Duration
+	Max     time.Duration
+	Avg     time.Duration
+	Percent float64 // Of the total time
+}
+
+type Report struct {
+	Syscalls []SyscallStats // By total time, longest first
+	Total    SyscallStats
+}
+
+type Summary struct {
+	lock      sync.Mutex
+	total     map[string]*SyscallStats
+	processes map[int]map[string]*SyscallStats // By process id
+	tgids     map[int]int                      // Process id of the tasks
+}
+
+func New() *Summary {
+	return &Summary{
+		total:     make(map[string]*SyscallStats),
+		processes: make(map[int]map[string]*SyscallStats),
+		tgids:     make(map[int]int),
+	}
+}
+
+// Count the trace, to register on exit
+func (s *Summary) Trace(trace *libtrace.Trace) {
+	if !trace.Exit {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	tgid, ok := s.tgids[trace.Pid]
+	if !ok {
+		tgid = procfs.Tgid(trace.Pid)
+		s.tgids[trace.Pid] = tgid
+	}
+	process, ok := s.processes[tgid]
+	if !ok {
+		process = make(map[string]*SyscallStats)
+		s.processes[tgid] = process
+	}
+	add(s.total, trace)
+	add(process, trace)
+}
+
+// Forget the exited tasks, to register as an event callback
+// (the pids can be reused)
+func (s *Summary) Event(event *libtrace.Event) {
+	if event.Type != libtrace.EventExit {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.tgids, event.Pid)
+}
+
+func add(m map[string]*SyscallStats, trace *libtrace.Trace) {
+	stats, ok := m[trace.Name]
+	if !ok {
+		stats = &SyscallStats{Name: trace.Name, Min: trace.Duration}
+		m[trace.Name] = stats
+	}
+	stats.Calls++
+	if trace.Return.Failed() {
+		stats.Errors++
+	}
+	stats.Total += trace.Duration
+	if trace.Duration < stats.Min {
+		stats.Min = trace.Duration
+	}
+	if trace.Duration > stats.Max {
+		stats.Max = trace.Duration
+	}
+}
+
+// Statistics of all the processes
+func (s *Summary) Report() Report {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return report(s.total)
+}
+
+// Statistics of a process
+func (s *Summary) ProcessReport(pid int) Report {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return report(s.processes[pid])
+}
+
+// Ids of the processes having done syscalls
+func (s *Summary) Pids() []int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	pids := make([]int, 0, len(s.processes))
+	for pid := range s.processes {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	return pids
+}
+
+func report(m map[string]*SyscallStats) (r Report) {
+	r.Total.Name = "total"
+	for _, stats := range m {
+		r.Syscalls = append(r.Syscalls, *stats)
+		r.Total.Calls += stats.Calls
+		r.Total.Errors += stats.Errors
+		r.Total.Total += stats.Total
+		if r.Total.Calls == stats.Calls || stats.Min < r.Total.Min {
+			r.Total.Min = stats.Min
+		}
+		if stats.Max > r.Total.Max {
+			r.Total.Max = stats.Max
+		}
+	}
+	for i := range r.Syscalls {
+		r.Syscalls[i].finish(r.Total.Total)
+	}
+	r.Total.finish(r.Total.Total)
+	sort.Slice(r.Syscalls, func(i, j int) bool {
+		a, b := r.Syscalls[i], r.Syscalls[j]
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		if a.Calls != b.Calls {
+			return a.Calls > b.Calls
+		}
+		return a.Name < b.Name
+	})
+	return
+}
+
+func (s *SyscallStats) finish(total time.Duration) {
+	if s.Calls > 0 {
+		s.Avg = s.Total / time.Duration(s.Calls)
+	}
+	if total > 0 {
+		s.Percent = float64(s.Total) * 100 / float64(total)
+	}
+}
+
+const tableSeparator = "------ ----------- ----------- --------- --------- ----------------\n"
+
+// Print the report in the strace -c table format
+func (r Report) WriteTable(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%% time     seconds  usecs/call     calls    errors syscall\n")
+	bw.WriteString(tableSeparator)
+	for _, stats := range r.Syscalls {
+		writeRow(bw, stats)
+	}
+	bw.WriteString(tableSeparator)
+	writeRow(bw, r.Total)
+	return bw.Flush()
+}
+
+func writeRow(w io.Writer, s SyscallStats) {
+	errors := ""
+	if s.Errors > 0 {
+		errors = strconv.FormatUint(s.Errors, 10)
+	}
+	fmt.Fprintf(w, "%6.2f %11.6f %11d %9d %9s %s\n",
+		s.Percent, s.Total.Seconds(), s.Avg/time.Microsecond, s.Calls, errors, s.Name)
+}
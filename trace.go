@@ -1,44 +1,86 @@
 package libtrace
 
+import (
+	"syscall"
+	"time"
+)
+
 type Tracer interface {
 	// Register a callback that will be called
 	// in the enter phase when
 	// the named syscalls will be executed
-	RegisterCbOnEnter(cb TracerCb, fnNames ...string)
+	RegisterCbOnEnter(cb TracerCb, fnNames ...string) Handle
 	// Register a callback that will be called
 	// in the exit phase when
 	// the named syscalls will be executed
-	RegisterCbOnExit(cb TracerCb, fnNames ...string)
+	RegisterCbOnExit(cb TracerCb, fnNames ...string) Handle
 	// Shorcut for RegisterCbOnEnter + RegisterCbOnExit
-	RegisterCb(cb TracerCb, fnNames ...string)
+	RegisterCb(cb TracerCb, fnNames ...string) Handle
 	// Register a callback that will be called
 	// in the enter phase for all the syscalls
-	RegisterGlobalCbOnEnter(cb TracerCb)
+	RegisterGlobalCbOnEnter(cb TracerCb) Handle
 	// Register a callback that will be called
 	// in the exit phase for all the syscalls
-	RegisterGlobalCbOnExit(cb TracerCb)
+	RegisterGlobalCbOnExit(cb TracerCb) Handle
 	// Shortcut for RegisterGlobalCbOnEnter + RegisterGlobalCbOnExit
-	RegisterGlobalCb(cb TracerCb)
+	RegisterGlobalCb(cb TracerCb) Handle
 	// Register a channel where the Trace info
 	// will be sent in the enter phase
 	// when the named syscalls will be executed
-	RegisterChannelOnEnter(out chan<- *Trace, fnNames ...string)
+	RegisterChannelOnEnter(out chan<- *Trace, fnNames ...string) Handle
 	// Register a channel where the Trace info
 	// will be sent in the exit phase
 	// when the named syscalls will be executed
-	RegisterChannelOnExit(out chan<- *Trace, fnNames ...string)
+	RegisterChannelOnExit(out chan<- *Trace, fnNames ...string) Handle
 	// Shortcut for RegisterChannelOnEnter + RegisterChannelOnExit
-	RegisterChannel(out chan<- *Trace, fnNames ...string)
+	RegisterChannel(out chan<- *Trace, fnNames ...string) Handle
 	// Register a channel where the Trace info
 	// will be sent in the enter phase
 	// for all the syscalls
-	RegisterGlobalChannelOnEnter(out chan<- *Trace)
+	RegisterGlobalChannelOnEnter(out chan<- *Trace) Handle
 	// Register a channel where the Trace info
 	// will be sent in the exit phase
 	// for all the syscalls
-	RegisterGlobalChannelOnExit(out chan<- *Trace)
+	RegisterGlobalChannelOnExit(out chan<- *Trace) Handle
 	// Shortcut for RegisterGlobalChannelOnEnter + RegisterGlobalChannelOnExit
-	RegisterGlobalChannel(out chan<- *Trace)
+	RegisterGlobalChannel(out chan<- *Trace) Handle
+	// Register a callback that will be called
+	// for the process events (fork, exec, exit, signal)
+	RegisterEventCb(cb EventCb) Handle
+	// Register a channel where the process events
+	// (and the policy events) will be sent
+	RegisterEventChannel(out chan<- *Event) Handle
+	// Set the delivery policy of a registered channel,
+	// for all its registrations (ignored if not registered)
+	// Default to DeliveryBlock
+	SetChannelPolicy(out chan<- *Trace, policy ChannelPolicy)
+
+	// Register a delay rule applied to the named syscalls:
+	// the tracee is held at the enter (or exit) stop
+	// for the delay before being resumed
+	RegisterDelay(rule DelayRule, fnNames ...string) Handle
+	// Register a delay rule applied to all the syscalls
+	RegisterGlobalDelay(rule DelayRule) Handle
+
+	// Register a redirection of the paths passed to the syscalls,
+	// rewritten in the tracee memory at the enter stop.
+	// The whole syscalls are traced while a redirection is registered.
+	RegisterRedirect(rule RedirectRule) Handle
+
+	// Follow the threads and the child processes
+	// created by the traced process
+	// Default to false
+	SetFollowForks(follow bool)
+
+	// Only stop the tracee on the syscalls having a named
+	// callback, channel or delay registered, using a seccomp
+	// filter installed in the command after its exec.
+	// Ignored when a global callback, channel or delay is registered.
+	// The filter is built when Run starts: the syscalls registered
+	// later are only seen if already in the filter.
+	// Needs Linux >= 4.8. The command runs with the no_new_privs bit set.
+	// Default to false
+	SetSeccomp(enabled bool)
 
 	// Set max string size representation to decode
 	// Default to 32
@@ -48,12 +90,46 @@ type Tracer interface {
 	// Default to 32
 	SetMaxBufferSize(bufferSize uint64)
 
+	// Decode the args of the enter traces too, the output
+	// args are not written by the syscall yet.
+	// Default to false: only the exit traces have args
+	SetDecodeArgsOnEnter(enabled bool)
+
+	// Set the logger of the tracer diagnostics,
+	// nil to discard them.
+	// Default to the standard log package logger
+	SetLogger(logger Logger)
+
+	// Only dispatch the traces matching the filter
+	// to the callbacks and channels, nil for all
+	// Can be called while Run is running
+	SetFilter(filter Filter)
+
+	// Enforce the policy at the syscall enter stops, nil for none.
+	// The whole syscalls are traced while a policy is set:
+	// the seccomp filter is not installed if set when Run starts.
+	// Can be called while Run is running
+	SetPolicy(policy *Policy)
+
+	// Return a virtual clock to the tracee, nil for the real one.
+	// The vDSO is hidden from the programs executed, so that
+	// their clock reads reach the tracer as syscalls.
+	// The whole syscalls are traced while a clock is set.
+	// Must be called before Run
+	SetClock(clock *Clock)
+
+	// Get the statistics of the tracer,
+	// can be called while Run is running
+	Stats() TracerStats
+
 	Run() error
 }
 
 type ArgValue struct {
 	Value interface{}
 	Str   string // String representation of the value
+	Err   error  // Error while decoding the value
+	Raw   uint64 // Value of the register
 }
 
 func (arg ArgValue) String() string {
@@ -65,15 +141,243 @@ type ReturnValue struct {
 	Description string
 }
 
+// Max errno returned (as -errno) by a syscall
+const maxErrno = 4095
+
+// True if the syscall returned an error
+func (r ReturnValue) Failed() bool {
+	return r.Code < 0 && r.Code >= -maxErrno
+}
+
+// Error returned by the syscall, 0 if it succeeded
+func (r ReturnValue) Errno() syscall.Errno {
+	if !r.Failed() {
+		return 0
+	}
+	return syscall.Errno(-r.Code)
+}
+
 type Trace struct {
 	*Signature
+	Pid    int         // Id of the task (process or thread) doing the syscall
 	Args   []ArgValue  // Args passed in
 	Return ReturnValue // Result
 	Exit   bool        // false when entering the syscal, true when exiting
+
+	Time     time.Time     // Time of the stop
+	Duration time.Duration // Time since the enter stop (exit only)
+
+	Personality        Personality
+	Arch               uint32 // AUDIT_ARCH_* value of the syscall
+	InstructionPointer uint64
+	StackPointer       uint64
+
+	Policy PolicyAction // Action of the policy applied at the enter stop
+
+	params *[6]regParam // Args registers, nil for the replayed traces
 }
 
 type TracerCb func(trace *Trace)
 
+type EventCb func(event *Event)
+
+type EventType int
+
+const (
+	EventFork   EventType = iota + 1 // New process created by fork
+	EventVfork                       // New process created by vfork
+	EventClone                       // New task (thread or process) created by clone
+	EventExec                        // Program executed
+	EventExit                        // Task exited or killed
+	EventSignal                      // Signal delivered to the task
+	EventPolicy                      // Syscall denied, killed or logged by the policy
+)
+
+func (e EventType) String() string {
+	switch e {
+	case EventFork:
+		return "fork"
+	case EventVfork:
+		return "vfork"
+	case EventClone:
+		return "clone"
+	case EventExec:
+		return "exec"
+	case EventExit:
+		return "exit"
+	case EventSignal:
+		return "signal"
+	case EventPolicy:
+		return "policy"
+	}
+	return "unknown"
+}
+
+// Event of a traced task
+type Event struct {
+	Type EventType
+	Pid  int
+	Time time.Time
+
+	ChildPid  int            // fork, vfork, clone: the new task
+	FormerPid int            // exec: pid of the thread which did the exec, if not the leader
+	Path      string         // exec: path of the executable
+	ExitCode  int            // exit: exit status, if not killed
+	Signal    syscall.Signal // signal: signal delivered, exit: signal which killed the task
+	CoreDump  bool           // exit: killed with a core dump
+	Action    PolicyAction   // policy: action applied
+	Rule      int            // policy: index of the matching rule, -1 for the default action
+	Trace     *Trace         // policy: enter trace of the syscall, with its args
+}
+
+// Registration of a callback, channel or delay
+type Handle interface {
+	// Stop receiving the traces (or applying the delay),
+	// can be called while Run is running
+	Remove()
+}
+
+// How the traces are delivered to a channel
+type DeliveryMode int
+
+const (
+	// Wait for the channel to accept the trace
+	DeliveryBlock DeliveryMode = iota
+	// Drop the trace if the channel is full
+	DeliveryDropNewest
+	// Queue the traces in a ring buffer of BufferSize traces,
+	// dropping the oldest one when full
+	DeliveryDropOldest
+	// Deliver (blocking) one trace out of SampleRate
+	DeliverySample
+)
+
+type ChannelPolicy struct {
+	Mode       DeliveryMode
+	BufferSize int // Size of the ring buffer of DeliveryDropOldest, default to 64
+	SampleRate int // Rate of DeliverySample
+}
+
+type ChannelStats struct {
+	Channel   chan<- *Trace
+	Policy    ChannelPolicy
+	Delivered uint64 // Traces sent to the channel
+	Dropped   uint64 // Traces lost because the channel was full
+	Skipped   uint64 // Traces not selected by the sampling
+}
+
+type TracerStats struct {
+	Traces   uint64 // Traces dispatched
+	Channels []ChannelStats
+}
+
+// Logger of the tracer diagnostics (*log.Logger implements it)
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Syscall ABI used by the tracee for a syscall
+type Personality int
+
+const (
+	PersonalityI386   Personality = iota + 1 // 32 bits x86, native or ia32 emulation on x86_64
+	PersonalityX86_64                        // 64 bits x86
+)
+
+func (p Personality) String() string {
+	switch p {
+	case PersonalityI386:
+		return "i386"
+	case PersonalityX86_64:
+		return "x86_64"
+	}
+	return "unknown"
+}
+
+// Delay injected in a syscall to simulate a slow call.
+// The delays of all the matching rules add up.
+type DelayRule struct {
+	Duration time.Duration // Fixed delay
+	Jitter   time.Duration // Random extra delay in [0, Jitter)
+	Exit     bool          // Hold the tracee at the exit stop instead of the enter stop
+	Path     string        // If set, only delay when a path arg, made absolute, starts with Path
+}
+
+// Redirection of the paths under From to To, like From "/etc/resolv.conf"
+// to To "/fixtures/resolv.conf", or From "/home/user/.config" to To
+// "/fixtures/config" for the whole directory. The relative paths are
+// made absolute before matching From. The first matching rule applies.
+type RedirectRule struct {
+	From string
+	To   string
+}
+
+type ClockMode int
+
+const (
+	ClockFrozen ClockMode = iota // The clock stays at Time, the sleeps return at once
+	ClockOffset                  // The wall clock is shifted by Offset
+	ClockScaled                  // The clock runs Rate times as fast from Time
+)
+
+// Virtual clock seen by the tracee through clock_gettime, gettimeofday,
+// time, nanosleep and clock_nanosleep, and their time64 variants on i386.
+// The wall clocks (CLOCK_REALTIME, CLOCK_TAI...) start at Time (now if
+// zero), the others keep their value at the start of Run; the CPU time
+// clocks are not changed.
+type Clock struct {
+	Mode   ClockMode
+	Time   time.Time     // ClockFrozen, ClockScaled: start of the wall clocks
+	Offset time.Duration // ClockOffset: added to the wall clocks
+	Rate   float64       // ClockScaled: speed of the clock, 1 if 0
+}
+
+type PolicyAction int
+
+const (
+	PolicyAllow PolicyAction = iota // Run the syscall
+	PolicyDeny                      // Skip the syscall, which fails with the errno of the rule
+	PolicyKill                      // Kill the process with SIGKILL
+	PolicyLog                       // Run the syscall and send an EventPolicy
+)
+
+func (a PolicyAction) String() string {
+	switch a {
+	case PolicyAllow:
+		return "allow"
+	case PolicyDeny:
+		return "deny"
+	case PolicyKill:
+		return "kill"
+	case PolicyLog:
+		return "log"
+	}
+	return "unknown"
+}
+
+// Rule of a policy, matched at the enter stop
+type PolicyRule struct {
+	Match  Filter
+	Action PolicyAction
+	Errno  syscall.Errno // Error of the denied syscall, EPERM if 0
+}
+
+// Rules enforced on the syscalls, the first matching rule applies.
+// An EventPolicy is sent to the event callbacks and channels for the
+// syscalls denied, killed or logged. The syscalls whose ABI can't be
+// determined (an int 0x80 from a 64 bits task, without
+// PTRACE_GET_SYSCALL_INFO) are denied with ENOSYS.
+//
+// The policy is not a security boundary: it is checked at the enter
+// stop, and the tracee memory (like a path) can still be changed by
+// another thread before the syscall reads it. It keeps honest programs
+// in line; hostile ones need a sandbox (seccomp, namespaces...).
+type Policy struct {
+	Rules   []PolicyRule
+	Default PolicyAction  // Action when no rule matches
+	Errno   syscall.Errno // Error of the syscalls denied by default, EPERM if 0
+}
+
 type Arg struct {
 	Name string
 	Type interface{} // Zero value of the type, so we can use type switch to decode it
@@ -0,0 +1,50 @@
+package libtrace
+
+import "fmt"
+
+// Syscall ABI of a personality
+type abi struct {
+	personality Personality
+	arch        uint32 // AUDIT_ARCH_*
+	syscalls    []*Signature
+	// Syscalls called through a multiplexer syscall,
+	// from the id subcallBase (out of the range of the syscalls)
+	subcalls            []*Signature
+	subcallBase         SyscallId
+	decodeReturnCodeFns map[SyscallId]decodeReturnCodeFn
+	// Get the id and args of the syscall called through
+	// a multiplexer syscall (other syscalls are unchanged)
+	demux func(pid int, id SyscallId, args [6]regParam) (SyscallId, [6]regParam, error)
+	// Syscall number to match in the seccomp filter
+	seccompSyscallId func(id SyscallId) SyscallId
+}
+
+// ABI of the AUDIT_ARCH_* value, the native one if unknown
+func abiOfArch(arch uint32) *abi {
+	for _, a := range abis {
+		if a.arch == arch {
+			return a
+		}
+	}
+	return abis[0]
+}
+
+func (a *abi) signature(id SyscallId) *Signature {
+	if id < SyscallId(len(a.syscalls)) && a.syscalls[id] != &unknownSignature {
+		return a.syscalls[id]
+	}
+	if a.subcalls != nil && id >= a.subcallBase && id-a.subcallBase < SyscallId(len(a.subcalls)) &&
+		a.subcalls[id-a.subcallBase] != &unknownSignature {
+		return a.subcalls[id-a.subcallBase]
+	}
+	sig := &Signature{}
+	*sig = unknownSignature
+	sig.Id = id
+	sig.Name = fmt.Sprintf("*UNKNOWN(%d)*", id)
+	return sig
+}
+
+// Signatures of the syscalls and of the subcalls
+func (a *abi) signatures() []*Signature {
+	return append(a.syscalls[:len(a.syscalls):len(a.syscalls)], a.subcalls...)
+}
@@ -0,0 +1,220 @@
+package libtrace
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const defaultChannelBufferSize = 64
+
+// Delivery state of a registered channel
+type channelDelivery struct {
+	// Atomic counters, first to be 64 bits aligned on 386
+	delivered uint64
+	dropped   uint64
+	skipped   uint64
+	seen      uint64
+
+	out    chan<- *Trace
+	policy ChannelPolicy
+
+	// Ring buffer of DeliveryDropOldest,
+	// emptied into the channel by a goroutine
+	lock    sync.Mutex
+	cond    *sync.Cond
+	queue   []*Trace
+	running bool          // Goroutine running
+	closed  bool          // No more traces until the next Run
+	done    chan struct{} // Closed with closed, to stop waiting for the channel
+	removed bool          // The last registration of the channel was removed
+}
+
+func (t *tracerImpl) SetChannelPolicy(out chan<- *Trace, policy ChannelPolicy) {
+	if policy.Mode == DeliveryDropOldest && policy.BufferSize <= 0 {
+		policy.BufferSize = defaultChannelBufferSize
+	}
+	if policy.Mode == DeliverySample && policy.SampleRate <= 0 {
+		policy.SampleRate = 1
+	}
+	t.deliveriesLock.Lock()
+	d, ok := t.deliveries[out]
+	t.deliveriesLock.Unlock()
+	if !ok {
+		// Not registered, or its last registration was removed
+		return
+	}
+	d.lock.Lock()
+	d.policy = policy
+	d.lock.Unlock()
+}
+
+func (t *tracerImpl) Stats() TracerStats {
+	stats := TracerStats{
+		Traces: atomic.LoadUint64(&t.traces),
+	}
+	t.deliveriesLock.Lock()
+	defer t.deliveriesLock.Unlock()
+	for _, d := range t.deliveryOrder {
+		d.lock.Lock()
+		policy := d.policy
+		d.lock.Unlock()
+		stats.Channels = append(stats.Channels, ChannelStats{
+			Channel:   d.out,
+			Policy:    policy,
+			Delivered: atomic.LoadUint64(&d.delivered),
+			Dropped:   atomic.LoadUint64(&d.dropped),
+			Skipped:   atomic.LoadUint64(&d.skipped),
+		})
+	}
+	return stats
+}
+
+// Get (or create) the delivery state of the channel
+func (t *tracerImpl) delivery(out chan<- *Trace) *channelDelivery {
+	t.deliveriesLock.Lock()
+	defer t.deliveriesLock.Unlock()
+	d, ok := t.deliveries[out]
+	if !ok {
+		d = &channelDelivery{out: out, done: make(chan struct{})}
+		d.cond = sync.NewCond(&d.lock)
+		t.deliveries[out] = d
+		t.deliveryOrder = append(t.deliveryOrder, d)
+	}
+	return d
+}
+
+// Forget the delivery state of the channel, when its last registration
+// is removed, and stop its ring buffer goroutine
+func (t *tracerImpl) removeDelivery(out chan<- *Trace) {
+	t.deliveriesLock.Lock()
+	d, ok := t.deliveries[out]
+	if ok {
+		delete(t.deliveries, out)
+		for i, o := range t.deliveryOrder {
+			if o == d {
+				t.deliveryOrder = append(t.deliveryOrder[:i:i], t.deliveryOrder[i+1:]...)
+				break
+			}
+		}
+	}
+	t.deliveriesLock.Unlock()
+	if ok {
+		d.lock.Lock()
+		d.removed = true
+		d.lock.Unlock()
+		d.close()
+	}
+}
+
+// Send the trace to the channel of the entry according to its policy
+func (t *tracerImpl) deliver(d *channelDelivery, trace *Trace) {
+	out := d.out
+	d.lock.Lock()
+	policy := d.policy
+	d.lock.Unlock()
+
+	switch policy.Mode {
+	case DeliveryDropNewest:
+		select {
+		case out <- trace:
+			atomic.AddUint64(&d.delivered, 1)
+		default:
+			atomic.AddUint64(&d.dropped, 1)
+		}
+	case DeliveryDropOldest:
+		d.push(trace)
+	case DeliverySample:
+		if (atomic.AddUint64(&d.seen, 1)-1)%uint64(policy.SampleRate) != 0 {
+			atomic.AddUint64(&d.skipped, 1)
+			return
+		}
+		out <- trace
+		atomic.AddUint64(&d.delivered, 1)
+	default:
+		out <- trace
+		atomic.AddUint64(&d.delivered, 1)
+	}
+}
+
+// Queue the trace, dropping the oldest one if the ring buffer is full
+func (d *channelDelivery) push(trace *Trace) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.removed {
+		atomic.AddUint64(&d.dropped, 1)
+		return
+	}
+	if len(d.queue) >= d.policy.BufferSize {
+		d.queue[0] = nil
+		d.queue = d.queue[1:]
+		atomic.AddUint64(&d.dropped, 1)
+	}
+	d.queue = append(d.queue, trace)
+	if d.closed {
+		// New Run
+		d.closed = false
+		d.done = make(chan struct{})
+	}
+	if !d.running {
+		d.running = true
+		go d.forward()
+	}
+	d.cond.Signal()
+}
+
+// Empty the ring buffer into the channel. Once closed, the traces
+// not accepted at once by the channel are dropped.
+func (d *channelDelivery) forward() {
+	d.lock.Lock()
+	for {
+		for len(d.queue) == 0 && !d.closed {
+			d.cond.Wait()
+		}
+		if len(d.queue) == 0 {
+			d.running = false
+			d.lock.Unlock()
+			return
+		}
+		trace := d.queue[0]
+		d.queue[0] = nil
+		d.queue = d.queue[1:]
+		done := d.done
+		d.lock.Unlock()
+
+		select {
+		case d.out <- trace:
+			atomic.AddUint64(&d.delivered, 1)
+		case <-done:
+			select {
+			case d.out <- trace:
+				atomic.AddUint64(&d.delivered, 1)
+			default:
+				atomic.AddUint64(&d.dropped, 1)
+			}
+		}
+
+		d.lock.Lock()
+	}
+}
+
+// Stop the ring buffer goroutine once the queued traces
+// are delivered or dropped
+func (d *channelDelivery) close() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed {
+		return
+	}
+	d.closed = true
+	close(d.done)
+	d.cond.Broadcast()
+}
+
+// Stop the ring buffer goroutines at the end of Run
+func (t *tracerImpl) closeDeliveries() {
+	t.deliveriesLock.Lock()
+	defer t.deliveriesLock.Unlock()
+	for _, d := range t.deliveries {
+		d.close()
+	}
+}
@@ -0,0 +1,61 @@
+package libtrace
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestDropOldestStopsWhenClosed(t *testing.T) {
+	tr := NewTracer(exec.Command("true")).(*tracerImpl)
+	out := make(chan *Trace)
+	h := tr.RegisterGlobalChannelOnExit(out)
+	tr.SetChannelPolicy(out, ChannelPolicy{Mode: DeliveryDropOldest, BufferSize: 2})
+	d := tr.registry().globalChannelsOnExit[0].delivery
+	for i := 0; i < 3; i++ {
+		tr.deliver(d, &Trace{})
+	}
+	// Nobody reads the channel
+	tr.closeDeliveries()
+	waitStopped(t, d)
+	if dropped := tr.Stats().Channels[0].Dropped; dropped != 3 {
+		t.Errorf("dropped %d traces, want 3", dropped)
+	}
+
+	// Next Run
+	tr.deliver(d, &Trace{})
+	if trace := <-out; trace == nil {
+		t.Errorf("no trace delivered after the restart")
+	}
+
+	h.Remove()
+	waitStopped(t, d)
+	if channels := tr.Stats().Channels; len(channels) != 0 {
+		t.Errorf("channels %v after the removal of the last handle", channels)
+	}
+	tr.deliver(d, &Trace{})
+	if d.running {
+		t.Errorf("goroutine restarted after the removal")
+	}
+}
+
+func waitStopped(t *testing.T, d *channelDelivery) {
+	for i := 0; i < 100; i++ {
+		d.lock.Lock()
+		running := d.running
+		d.lock.Unlock()
+		if !running {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("ring buffer goroutine still running")
+}
+
+func TestSetChannelPolicyUnregistered(t *testing.T) {
+	tr := NewTracer(exec.Command("true")).(*tracerImpl)
+	tr.SetChannelPolicy(make(chan *Trace), ChannelPolicy{Mode: DeliveryDropOldest})
+	if channels := tr.Stats().Channels; len(channels) != 0 {
+		t.Errorf("channels %v without registration", channels)
+	}
+}
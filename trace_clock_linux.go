@@ -0,0 +1,335 @@
+package libtrace
+
+import (
+	"encoding/binary"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Auxiliary vector entry types
+const (
+	_AT_NULL         = 0
+	_AT_IGNORE       = 1
+	_AT_SYSINFO_EHDR = 33 // Address of the vDSO
+)
+
+const _TIMER_ABSTIME = 1
+
+// Clocks changed by the virtual clock, true for the wall clocks
+var virtualClocks = map[int]bool{
+	0:  true,  // CLOCK_REALTIME
+	1:  false, // CLOCK_MONOTONIC
+	4:  false, // CLOCK_MONOTONIC_RAW
+	5:  true,  // CLOCK_REALTIME_COARSE
+	6:  false, // CLOCK_MONOTONIC_COARSE
+	7:  false, // CLOCK_BOOTTIME
+	8:  true,  // CLOCK_REALTIME_ALARM
+	9:  false, // CLOCK_BOOTTIME_ALARM
+	11: true,  // CLOCK_TAI
+}
+
+// Read the real clocks at the start of the virtual clock
+func (t *tracerImpl) startClock() {
+	t.clockBases = make(map[int]int64, len(virtualClocks))
+	for id := range virtualClocks {
+		var ts syscall.Timespec
+		_, _, errno := syscall.RawSyscall(syscall.SYS_CLOCK_GETTIME, uintptr(id), uintptr(unsafe.Pointer(&ts)), 0)
+		if errno == 0 {
+			t.clockBases[id] = ts.Nano()
+		}
+	}
+}
+
+func (t *tracerImpl) clockRate() float64 {
+	if t.clock.Rate <= 0 {
+		return 1
+	}
+	return t.clock.Rate
+}
+
+// Shift of the clock from its real time at the start
+func (t *tracerImpl) clockShift(id int) int64 {
+	if !virtualClocks[id] {
+		return 0
+	}
+	if t.clock.Mode == ClockOffset {
+		return int64(t.clock.Offset)
+	}
+	if t.clock.Time.IsZero() {
+		return 0
+	}
+	return t.clock.Time.UnixNano() - t.clockBases[0]
+}
+
+// Virtual time of the clock, in ns, from its real time
+func (t *tracerImpl) virtualTime(id int, real int64) int64 {
+	base, ok := t.clockBases[id]
+	if !ok {
+		return real
+	}
+	switch t.clock.Mode {
+	case ClockFrozen:
+		return base + t.clockShift(id)
+	case ClockOffset:
+		return real + t.clockShift(id)
+	}
+	return base + t.clockShift(id) + int64(float64(real-base)*t.clockRate())
+}
+
+// Real time of the clock, in ns, from its virtual time
+// (not for a frozen clock)
+func (t *tracerImpl) realTime(id int, virtual int64) int64 {
+	base, ok := t.clockBases[id]
+	if !ok {
+		return virtual
+	}
+	if t.clock.Mode == ClockOffset {
+		return virtual - t.clockShift(id)
+	}
+	return base + int64(float64(virtual-base-t.clockShift(id))/t.clockRate())
+}
+
+// Adjust the sleeps to the virtual clock at the enter stop
+func (t *tracerImpl) clockEnter(trace *Trace, state *syscallState) {
+	switch trace.Name {
+	case "nanosleep":
+		// nanosleep(rqtp, rmtp)
+		t.clockSleep(trace, state, 0, 1, false)
+	case "clock_nanosleep", "clock_nanosleep_time64":
+		// clock_nanosleep(which_clock, flags, rqtp, rmtp)
+		if _, ok := t.clockBases[int(int32(state.param(0)))]; ok {
+			t.clockSleep(trace, state, 2, int(int32(state.param(0))), state.param(1)&_TIMER_ABSTIME != 0)
+		}
+	}
+}
+
+// Skip the sleep of a frozen clock, or pass the real duration
+// (or deadline) of the sleep in a timespec written on the tracee stack
+func (t *tracerImpl) clockSleep(trace *Trace, state *syscallState, arg, id int, abs bool) {
+	switch {
+	case t.clock.Mode == ClockFrozen:
+		state.sleepSkipped = true
+		if err := skipSyscall(trace.Pid); err != nil {
+			t.logf("Clock: can't skip %s of %d: %s", trace.Name, trace.Pid, err)
+		}
+		return
+	case t.clock.Mode == ClockOffset && (!abs || !virtualClocks[id]):
+		return
+	}
+
+	word := timespecWord(trace)
+	ns, err := peekTime(trace.Pid, word, state.param(arg), 1)
+	if err != nil {
+		return
+	}
+	if abs {
+		ns = t.realTime(id, ns)
+	} else {
+		ns = int64(float64(ns) / t.clockRate())
+	}
+	buf := timeBytes(word, ns, 1)
+	addr := (state.sp - redZone - uint64(len(buf))) &^ 15
+	if _, err := syscall.PtracePokeData(trace.Pid, uintptr(addr), buf); err != nil {
+		t.logf("Clock: can't write the timespec of %s in %d: %s", trace.Name, trace.Pid, err)
+		return
+	}
+	if err := setSyscallArgs(trace.Pid, trace.Personality, map[int]regParam{arg: regParam(addr)}); err != nil {
+		t.logf("Clock: can't set the args of %s of %d: %s", trace.Name, trace.Pid, err)
+		return
+	}
+	if state.redirected == nil {
+		state.redirected = make(map[int]regParam)
+	}
+	state.redirected[arg] = state.param(arg)
+}
+
+// Return the virtual time at the exit stop
+func (t *tracerImpl) clockExit(trace *Trace, state *syscallState) {
+	if state.sleepSkipped {
+		state.ret = 0
+		trace.Return.Code = state.ret
+		if err := setSyscallReturn(trace.Pid, state.ret); err != nil {
+			t.logf("Clock: can't set the return of %s of %d: %s", trace.Name, trace.Pid, err)
+		}
+		return
+	}
+	if trace.Return.Failed() {
+		if trace.Return.Errno() == syscall.EINTR && t.clock.Mode == ClockScaled {
+			t.clockRemaining(trace, state)
+		}
+		return
+	}
+
+	var err error
+	switch trace.Name {
+	case "clock_gettime", "clock_gettime64":
+		// clock_gettime(which_clock, tp)
+		err = t.pokeVirtualTime(trace, int(int32(state.param(0))), state.param(1), timespecWord(trace), 1)
+	case "gettimeofday":
+		// gettimeofday(tv, tz)
+		if state.param(0) != 0 {
+			err = t.pokeVirtualTime(trace, 0, state.param(0), timeWord(trace.Personality), 1000)
+		}
+	case "time":
+		// time(tloc): the seconds returned are truncated, the time of the stop is used
+		state.ret = ReturnCode(t.virtualTime(0, state.time.UnixNano()) / 1e9)
+		trace.Return.Code = state.ret
+		if err = setSyscallReturn(trace.Pid, state.ret); err == nil && state.param(0) != 0 {
+			_, err = syscall.PtracePokeData(trace.Pid, uintptr(state.param(0)),
+				timeBytes(timeWord(trace.Personality), int64(state.ret)*1e9, 1e9)[:timeWord(trace.Personality)])
+		}
+	}
+	if err != nil {
+		t.logf("Clock: can't set the time returned by %s to %d: %s", trace.Name, trace.Pid, err)
+	}
+}
+
+// Scale the remaining time of an interrupted relative sleep
+func (t *tracerImpl) clockRemaining(trace *Trace, state *syscallState) {
+	arg := 1
+	if trace.Name == "clock_nanosleep" || trace.Name == "clock_nanosleep_time64" {
+		if state.param(1)&_TIMER_ABSTIME != 0 {
+			return
+		}
+		arg = 3
+	} else if trace.Name != "nanosleep" {
+		return
+	}
+	addr := state.param(arg)
+	if addr == 0 {
+		return
+	}
+	word := timespecWord(trace)
+	ns, err := peekTime(trace.Pid, word, addr, 1)
+	if err != nil {
+		return
+	}
+	ns = int64(float64(ns) * t.clockRate())
+	if _, err := syscall.PtracePokeData(trace.Pid, uintptr(addr), timeBytes(word, ns, 1)); err != nil {
+		t.logf("Clock: can't set the remaining time of %s of %d: %s", trace.Name, trace.Pid, err)
+	}
+}
+
+// Replace the time at addr by the virtual time of the clock
+func (t *tracerImpl) pokeVirtualTime(trace *Trace, id int, addr regParam, word int, unit int64) error {
+	ns, err := peekTime(trace.Pid, word, addr, unit)
+	if err != nil {
+		return err
+	}
+	_, err = syscall.PtracePokeData(trace.Pid, uintptr(addr), timeBytes(word, t.virtualTime(id, ns), unit))
+	return err
+}
+
+// Size of the long of the personality, and of the time_t
+func timeWord(personality Personality) int {
+	if personality == PersonalityI386 {
+		return 4
+	}
+	return 8
+}
+
+// Size of the fields of the struct timespec of the syscall,
+// 64 bits for the time64 syscalls of i386
+func timespecWord(trace *Trace) int {
+	if strings.HasSuffix(trace.Name, "64") {
+		return 8
+	}
+	return timeWord(trace.Personality)
+}
+
+// Read a struct timespec (unit 1) or timeval (unit 1000)
+// with fields of word bytes, in ns
+func peekTime(pid int, word int, addr regParam, unit int64) (int64, error) {
+	buf := make([]byte, 2*word)
+	if n, err := syscall.PtracePeekData(pid, uintptr(addr), buf); err != nil {
+		return 0, err
+	} else if n != len(buf) {
+		return 0, syscall.EFAULT
+	}
+	if word == 4 {
+		return int64(int32(binary.LittleEndian.Uint32(buf)))*1e9 +
+			int64(int32(binary.LittleEndian.Uint32(buf[4:])))*unit, nil
+	}
+	return int64(binary.LittleEndian.Uint64(buf))*1e9 +
+		int64(binary.LittleEndian.Uint64(buf[8:]))*unit, nil
+}
+
+// Bytes of a struct timespec (unit 1) or timeval (unit 1000)
+// with fields of word bytes
+func timeBytes(word int, ns int64, unit int64) []byte {
+	sec, frac := ns/1e9, ns%1e9
+	if frac < 0 {
+		sec, frac = sec-1, frac+1e9
+	}
+	buf := make([]byte, 2*word)
+	if word == 4 {
+		binary.LittleEndian.PutUint32(buf, uint32(sec))
+		binary.LittleEndian.PutUint32(buf[4:], uint32(frac/unit))
+	} else {
+		binary.LittleEndian.PutUint64(buf, uint64(sec))
+		binary.LittleEndian.PutUint64(buf[8:], uint64(frac/unit))
+	}
+	return buf
+}
+
+// Hide the vDSO from the program just executed: the type of the
+// AT_SYSINFO_EHDR entry of its auxiliary vector is changed to AT_IGNORE,
+// so the libc (or the Go runtime) makes the syscalls instead of
+// reading the time in the vDSO
+func (t *tracerImpl) hideVdso(pid int) {
+	sp, personality, err := taskStack(pid)
+	if err != nil {
+		t.logf("Clock: can't get the stack of %d: %s", pid, err)
+		return
+	}
+	word := uint64(timeWord(personality))
+
+	// argc, argv, NULL, envp, NULL, auxv
+	argc, err := peekWord(pid, sp, word)
+	if err != nil {
+		t.logf("Clock: can't read the args of %d: %s", pid, err)
+		return
+	}
+	addr := sp + word*(argc+2)
+	for {
+		env, err := peekWord(pid, addr, word)
+		if err != nil {
+			t.logf("Clock: can't read the environment of %d: %s", pid, err)
+			return
+		}
+		addr += word
+		if env == 0 {
+			break
+		}
+	}
+	for ; ; addr += 2 * word {
+		typ, err := peekWord(pid, addr, word)
+		if err != nil {
+			t.logf("Clock: can't read the auxiliary vector of %d: %s", pid, err)
+			return
+		}
+		switch typ {
+		case _AT_NULL:
+			return
+		case _AT_SYSINFO_EHDR:
+			buf := make([]byte, word)
+			buf[0] = _AT_IGNORE
+			if _, err := syscall.PtracePokeData(pid, uintptr(addr), buf); err != nil {
+				t.logf("Clock: can't hide the vDSO of %d: %s", pid, err)
+			}
+			return
+		}
+	}
+}
+
+// Read a word of the tracee memory
+func peekWord(pid int, addr, word uint64) (uint64, error) {
+	buf := make([]byte, 8)
+	if n, err := syscall.PtracePeekData(pid, uintptr(addr), buf[:word]); err != nil {
+		return 0, err
+	} else if n != int(word) {
+		return 0, syscall.EFAULT
+	}
+	return binary.LittleEndian.Uint64(buf), nil
+}
@@ -0,0 +1,72 @@
+package libtrace
+
+import (
+	"math/rand"
+	"syscall"
+	"time"
+)
+
+// Max size of a path read to match the delay rules
+const pathMax = 4096
+
+// Total delay of the rules matching the trace
+func (t *tracerImpl) delay(trace *Trace, state *syscallState) (d time.Duration) {
+	r := t.registry()
+	named := r.delays[trace.Signature.Name]
+	if len(r.globalDelays) == 0 && len(named) == 0 {
+		return 0
+	}
+
+	var paths []string
+	pathsRead := false
+	for _, rules := range [][]entry{r.globalDelays, named} {
+		for _, e := range rules {
+			rule := e.delay
+			if rule.Exit != trace.Exit {
+				continue
+			}
+			if rule.Path != "" {
+				if !pathsRead {
+					// Matched like the policies and the redirections
+					paths = (&matcher{t: t, trace: trace, state: state}).resolvedPaths()
+					pathsRead = true
+				}
+				if !matchPathPrefix(paths, rule.Path) {
+					continue
+				}
+			}
+			d += rule.Duration
+			if rule.Jitter > 0 {
+				d += time.Duration(rand.Int63n(int64(rule.Jitter)))
+			}
+		}
+	}
+	return
+}
+
+// Read a C string (null terminated) of at most max bytes in the
+// memory of a stopped tracee, like a path arg (not truncated to the
+// max string size of the decoded args). The memory is read with ptrace:
+// only from a callback, not from a channel receiver.
+func PeekString(pid int, addr uint64, max int) (string, error) {
+	return peekStringC(pid, regParam(addr), max)
+}
+
+// Read a C string (null terminated) from the tracee memory
+func peekStringC(pid int, addr regParam, max int) (string, error) {
+	if addr == 0 {
+		return "", syscall.EFAULT
+	}
+	out := []byte{0}
+	str := make([]byte, 0, 64)
+	for len(str) < max {
+		if _, err := syscall.PtracePeekData(pid, uintptr(addr)+uintptr(len(str)), out); err != nil {
+			return string(str), err
+		}
+		if out[0] == 0 {
+			break
+		}
+		str = append(str, out[0])
+	}
+	return string(str), nil
+}
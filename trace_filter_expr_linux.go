@@ -0,0 +1,597 @@
+package libtrace
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error of a filter expression
+type FilterSyntaxError struct {
+	Expr   string
+	Offset int // Offset in Expr
+	Msg    string
+}
+
+func (e *FilterSyntaxError) Error() string {
+	return fmt.Sprintf("filter: %s at offset %d", e.Msg, e.Offset)
+}
+
+// Compile a filter expression, like
+//
+//	syscall in (openat, open) and ret < 0 and path =~ "^/etc/"
+//
+// Fields:
+//
+//	syscall, id, pid              the syscall and the task
+//	ret, errno, duration          the result (exit only)
+//	exit                          true or false
+//	path                          one of the string args
+//	<arg name>                    arg of the signature, like fd or filename
+//
+// A field which is neither one of these fields nor the name of an arg of
+// a syscall is an error, and so is a syscall name missing from the
+// syscall tables.
+//
+// Operators: == != < <= > >= =~ !~ in, not in, combined with and, or, not
+// and parentheses. The strings are quoted with "", the words not needing
+// quotes (syscall names, errno names, numbers, durations like 10ms) can be
+// left bare.
+func CompileFilter(expr string) (Filter, error) {
+	tokens, err := lexFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := filterParser{expr: expr, tokens: tokens}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "unexpected %q", tok.text)
+	}
+	return f, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string // Unquoted for the strings
+	pos  int
+}
+
+var filterOps = []string{"==", "!=", "<=", ">=", "=~", "!~", "&&", "||", "<", ">", "!"}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' || c == '/' ||
+		'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+func lexFilter(expr string) (tokens []token, err error) {
+	i := 0
+	for i < len(expr) {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{tokComma, ",", i})
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(expr) && expr[j] != '"'; j++ {
+				if expr[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(expr) {
+				return nil, &FilterSyntaxError{expr, i, "unterminated string"}
+			}
+			str, err := strconv.Unquote(expr[i : j+1])
+			if err != nil {
+				return nil, &FilterSyntaxError{expr, i, "invalid string: " + err.Error()}
+			}
+			tokens = append(tokens, token{tokString, str, i})
+			i = j + 1
+		case isWordChar(c):
+			j := i
+			for j < len(expr) && isWordChar(expr[j]) {
+				j++
+			}
+			tokens = append(tokens, token{tokWord, expr[i:j], i})
+			i = j
+		default:
+			op := ""
+			for _, o := range filterOps {
+				if strings.HasPrefix(expr[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, &FilterSyntaxError{expr, i, fmt.Sprintf("unexpected character %q", c)}
+			}
+			tokens = append(tokens, token{tokOp, op, i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{tokEOF, "end of expression", len(expr)}), nil
+}
+
+type filterParser struct {
+	expr   string
+	tokens []token
+	i      int
+}
+
+func (p *filterParser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *filterParser) next() token {
+	tok := p.tokens[p.i]
+	if tok.kind != tokEOF {
+		p.i++
+	}
+	return tok
+}
+
+func (p *filterParser) errorf(tok token, format string, v ...interface{}) error {
+	return &FilterSyntaxError{p.expr, tok.pos, fmt.Sprintf(format, v...)}
+}
+
+func (p *filterParser) isKeyword(tok token, word, op string) bool {
+	return tok.kind == tokWord && tok.text == word || tok.kind == tokOp && tok.text == op
+}
+
+func (p *filterParser) or() (Filter, error) {
+	f, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	filters := []Filter{f}
+	for p.isKeyword(p.peek(), "or", "||") {
+		p.next()
+		if f, err = p.and(); err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	if len(filters) == 1 {
+		return filters[0], nil
+	}
+	return Or(filters...), nil
+}
+
+func (p *filterParser) and() (Filter, error) {
+	f, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	filters := []Filter{f}
+	for p.isKeyword(p.peek(), "and", "&&") {
+		p.next()
+		if f, err = p.unary(); err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	if len(filters) == 1 {
+		return filters[0], nil
+	}
+	return And(filters...), nil
+}
+
+func (p *filterParser) unary() (Filter, error) {
+	tok := p.peek()
+	switch {
+	case p.isKeyword(tok, "not", "!"):
+		p.next()
+		f, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return Not(f), nil
+	case tok.kind == tokLParen:
+		p.next()
+		f, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if tok = p.next(); tok.kind != tokRParen {
+			return nil, p.errorf(tok, "expected \")\", got %q", tok.text)
+		}
+		return f, nil
+	}
+	return p.comparison()
+}
+
+func (p *filterParser) comparison() (Filter, error) {
+	field := p.next()
+	if field.kind != tokWord {
+		return nil, p.errorf(field, "expected a field, got %q", field.text)
+	}
+
+	var op string
+	negate := false
+	opTok := p.next()
+	switch {
+	case opTok.kind == tokOp && opTok.text != "!" && opTok.text != "&&" && opTok.text != "||":
+		op = opTok.text
+	case p.isKeyword(opTok, "in", ""):
+		op = "in"
+	case p.isKeyword(opTok, "not", "") && p.isKeyword(p.peek(), "in", ""):
+		p.next()
+		op, negate = "in", true
+	default:
+		return nil, p.errorf(opTok, "expected an operator after %q, got %q", field.text, opTok.text)
+	}
+	switch op {
+	case "!=":
+		op, negate = "==", true
+	case "!~":
+		op, negate = "=~", true
+	}
+
+	var values []token
+	if op == "in" {
+		if tok := p.next(); tok.kind != tokLParen {
+			return nil, p.errorf(tok, "expected \"(\" after in, got %q", tok.text)
+		}
+		for {
+			tok := p.next()
+			if tok.kind != tokWord && tok.kind != tokString {
+				return nil, p.errorf(tok, "expected a value, got %q", tok.text)
+			}
+			values = append(values, tok)
+			if tok = p.next(); tok.kind == tokRParen {
+				break
+			} else if tok.kind != tokComma {
+				return nil, p.errorf(tok, "expected \",\" or \")\", got %q", tok.text)
+			}
+		}
+	} else {
+		tok := p.next()
+		if tok.kind != tokWord && tok.kind != tokString {
+			return nil, p.errorf(tok, "expected a value after %q, got %q", op, tok.text)
+		}
+		values = []token{tok}
+	}
+
+	return p.compileComparison(field, op, negate, values)
+}
+
+// Value of a field of a trace or of a literal
+type exprValue struct {
+	str      string
+	num      int64
+	isNum    bool
+	unsigned bool
+}
+
+func numValue(n int64) exprValue {
+	return exprValue{str: strconv.FormatInt(n, 10), num: n, isNum: true}
+}
+
+func (v exprValue) compare(lit exprValue) int {
+	a, b := v.num, lit.num
+	if v.unsigned {
+		if b < 0 {
+			return 1
+		}
+		ua, ub := uint64(a), uint64(b)
+		if ua < ub {
+			return -1
+		} else if ua > ub {
+			return 1
+		}
+		return 0
+	}
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func (v exprValue) equal(lit exprValue) bool {
+	if v.isNum && lit.isNum {
+		return v.compare(lit) == 0
+	}
+	return v.str == lit.str
+}
+
+// Field of the traces
+type exprField struct {
+	cost    int
+	numeric bool // Only numbers can be compared
+	strOnly bool // Numbers can't be compared
+	literal func(tok token) (exprValue, error)
+	values  func(m *matcher) []exprValue // nil if not available
+}
+
+func (p *filterParser) field(tok token) (exprField, error) {
+	numLiteral := func(tok token) (exprValue, error) {
+		n, err := strconv.ParseInt(tok.text, 0, 64)
+		if err != nil {
+			return exprValue{}, p.errorf(tok, "expected a number, got %q", tok.text)
+		}
+		return numValue(n), nil
+	}
+	strLiteral := func(tok token) (exprValue, error) {
+		return exprValue{str: tok.text}, nil
+	}
+
+	switch tok.text {
+	case "syscall":
+		return exprField{costTrace, false, true, func(tok token) (exprValue, error) {
+			if !isSyscallName(tok.text) {
+				return exprValue{}, p.errorf(tok, "unknown syscall %q", tok.text)
+			}
+			return exprValue{str: tok.text}, nil
+		}, func(m *matcher) []exprValue {
+			return []exprValue{{str: m.trace.Name}}
+		}}, nil
+	case "id":
+		return exprField{costTrace, true, false, numLiteral, func(m *matcher) []exprValue {
+			v := numValue(int64(m.trace.Id))
+			v.unsigned = true
+			return []exprValue{v}
+		}}, nil
+	case "pid":
+		return exprField{costTrace, true, false, numLiteral, func(m *matcher) []exprValue {
+			return []exprValue{numValue(int64(m.trace.Pid))}
+		}}, nil
+	case "ret":
+		return exprField{costTrace, true, false, numLiteral, func(m *matcher) []exprValue {
+			if !m.trace.Exit {
+				return nil
+			}
+			return []exprValue{numValue(int64(m.trace.Return.Code))}
+		}}, nil
+	case "errno":
+		// The errno names can be quoted
+		names := errnoNames()
+		return exprField{costTrace, false, false, func(tok token) (exprValue, error) {
+			if errno, ok := names[tok.text]; ok {
+				return exprValue{str: tok.text, num: int64(errno), isNum: true}, nil
+			}
+			if v, err := numLiteral(tok); err == nil {
+				return v, nil
+			}
+			return exprValue{}, p.errorf(tok, "unknown errno %q", tok.text)
+		}, func(m *matcher) []exprValue {
+			if !m.trace.Exit {
+				return nil
+			}
+			errno := int(m.trace.Return.Errno())
+			return []exprValue{{str: errnoName(errno), num: int64(errno), isNum: true}}
+		}}, nil
+	case "duration":
+		return exprField{costTrace, true, false, func(tok token) (exprValue, error) {
+			d, err := time.ParseDuration(tok.text)
+			if err != nil {
+				return exprValue{}, p.errorf(tok, "expected a duration, got %q", tok.text)
+			}
+			return exprValue{str: d.String(), num: int64(d), isNum: true}, nil
+		}, func(m *matcher) []exprValue {
+			if !m.trace.Exit {
+				return nil
+			}
+			return []exprValue{{str: m.trace.Duration.String(), num: int64(m.trace.Duration), isNum: true}}
+		}}, nil
+	case "exit":
+		return exprField{costTrace, true, false, func(tok token) (exprValue, error) {
+			b, err := strconv.ParseBool(tok.text)
+			if err != nil {
+				return exprValue{}, p.errorf(tok, "expected true or false, got %q", tok.text)
+			}
+			return boolValue(b), nil
+		}, func(m *matcher) []exprValue {
+			return []exprValue{boolValue(m.trace.Exit)}
+		}}, nil
+	case "path":
+		return exprField{costPeek, false, true, strLiteral, func(m *matcher) (values []exprValue) {
+			for _, path := range m.pathArgs() {
+				values = append(values, exprValue{str: path})
+			}
+			return
+		}}, nil
+	}
+
+	name := tok.text
+	if !isArgName(name) {
+		return exprField{}, p.errorf(tok, "unknown field %q", name)
+	}
+	return exprField{costPeek, false, false, func(tok token) (exprValue, error) {
+		if tok.kind == tokWord {
+			if n, err := strconv.ParseInt(tok.text, 0, 64); err == nil {
+				return exprValue{str: tok.text, num: n, isNum: true}, nil
+			}
+		}
+		return exprValue{str: tok.text}, nil
+	}, func(m *matcher) []exprValue {
+		for i, arg := range m.trace.Signature.Args {
+			if arg.Name == name {
+				return []exprValue{m.argValue(i)}
+			}
+		}
+		return nil
+	}}, nil
+}
+
+// True if a syscall (or a subcall) of one of the ABIs has this name
+func isSyscallName(name string) bool {
+	for _, a := range abis {
+		for _, sig := range a.signatures() {
+			if sig != &unknownSignature && sig.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// True if an arg of a syscall of one of the ABIs has this name
+func isArgName(name string) bool {
+	for _, a := range abis {
+		for _, sig := range a.signatures() {
+			for _, arg := range sig.Args {
+				if arg.Name == name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func boolValue(b bool) exprValue {
+	if b {
+		return exprValue{str: "true", num: 1, isNum: true}
+	}
+	return exprValue{str: "false", num: 0, isNum: true}
+}
+
+// Value of the arg i, typed by the signature
+func (m *matcher) argValue(i int) exprValue {
+	raw := m.param(i)
+	var v exprValue
+	switch m.trace.Signature.Args[i].Type.(type) {
+	case StringC:
+		v.str = m.stringArg(i)
+		return v
+	// The int and uint of the signatures are the 32 bits C types
+	case int, int32:
+		v = numValue(int64(int32(raw)))
+	case int8:
+		v = numValue(int64(int8(raw)))
+	case int16:
+		v = numValue(int64(int16(raw)))
+	case int64:
+		v = numValue(int64(raw))
+	case uint, uint32:
+		v = numValue(int64(uint32(raw)))
+	case uint8:
+		v = numValue(int64(uint8(raw)))
+	case uint16:
+		v = numValue(int64(uint16(raw)))
+	case uint64, uintptr:
+		v = exprValue{str: strconv.FormatUint(uint64(raw), 10), num: int64(raw), isNum: true, unsigned: true}
+	default:
+		v.str = m.decodedArgs()[i].Str
+	}
+	return v
+}
+
+func (p *filterParser) compileComparison(fieldTok token, op string, negate bool, valueToks []token) (Filter, error) {
+	field, err := p.field(fieldTok)
+	if err != nil {
+		return nil, err
+	}
+
+	var lits []exprValue
+	var re *regexp.Regexp
+	if op == "=~" {
+		if re, err = regexp.Compile(valueToks[0].text); err != nil {
+			return nil, p.errorf(valueToks[0], "invalid regexp: %s", err)
+		}
+	} else {
+		switch op {
+		case "<", "<=", ">", ">=":
+			if field.strOnly {
+				return nil, p.errorf(fieldTok, "%q can't be compared with %s", fieldTok.text, op)
+			}
+		}
+		for _, tok := range valueToks {
+			if field.numeric && tok.kind == tokString {
+				return nil, p.errorf(tok, "%q can't be compared with a string", fieldTok.text)
+			}
+			lit, err := field.literal(tok)
+			if err != nil {
+				return nil, err
+			}
+			if (op != "==" && op != "in") && !lit.isNum {
+				return nil, p.errorf(tok, "expected a number after %s, got %q", op, tok.text)
+			}
+			lits = append(lits, lit)
+		}
+	}
+
+	match := func(v exprValue) bool {
+		switch op {
+		case "=~":
+			return re.MatchString(v.str)
+		case "==", "in":
+			for _, lit := range lits {
+				if v.equal(lit) {
+					return true
+				}
+			}
+			return false
+		}
+		if !v.isNum {
+			return false
+		}
+		c := v.compare(lits[0])
+		switch op {
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		case ">":
+			return c > 0
+		}
+		return c >= 0
+	}
+
+	return predicate{field.cost, func(m *matcher) bool {
+		values := field.values(m)
+		if values == nil {
+			// Not available for this trace
+			return false
+		}
+		for _, v := range values {
+			if match(v) {
+				return !negate
+			}
+		}
+		return negate
+	}}, nil
+}
+
+// Errno names, like ENOENT
+func errnoNames() map[string]int {
+	names := make(map[string]int, len(linuxReturnCodes))
+	for errno := range linuxReturnCodes {
+		names[errnoName(errno)] = errno
+	}
+	return names
+}
+
+func errnoName(errno int) string {
+	if d, ok := linuxReturnCodes[errno]; ok {
+		return strings.Fields(d)[0]
+	}
+	return strconv.Itoa(errno)
+}
@@ -0,0 +1,123 @@
+package libtrace
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompileFilterErrors(t *testing.T) {
+	tests := []struct {
+		expr   string
+		offset int
+		msg    string
+	}{
+		{`syscall ==`, 10, "expected a value"},
+		{`syscall == bogus`, 11, `unknown syscall "bogus"`},
+		{`syscall in (open, bogus)`, 18, `unknown syscall "bogus"`},
+		{`bogus == 1`, 0, `unknown field "bogus"`},
+		{`syscall < open`, 0, "can't be compared with <"},
+		{`ret < "0"`, 6, "can't be compared with a string"},
+		{`ret == -`, 7, "expected a number"},
+		{`errno == EBOGUS`, 9, `unknown errno "EBOGUS"`},
+		{`duration > 10parsecs`, 11, "expected a duration"},
+		{`exit == maybe`, 8, "expected true or false"},
+		{`path == "/etc`, 8, "unterminated string"},
+		{`path =~ "("`, 8, "invalid regexp"},
+		{`ret # 0`, 4, "unexpected character"},
+		{`(ret < 0`, 8, `expected ")"`},
+		{`ret < 0 pid`, 8, `unexpected "pid"`},
+		{`syscall in (open read)`, 17, `expected "," or ")"`},
+		{`syscall in open`, 11, `expected "(" after in`},
+		{`and ret < 0`, 4, `expected an operator after "and"`},
+	}
+	for _, test := range tests {
+		_, err := CompileFilter(test.expr)
+		var syntaxErr *FilterSyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%s: error %v, want a syntax error", test.expr, err)
+			continue
+		}
+		if syntaxErr.Offset != test.offset || !strings.Contains(syntaxErr.Msg, test.msg) {
+			t.Errorf("%s: %q at offset %d, want %q at offset %d", test.expr, syntaxErr.Msg, syntaxErr.Offset, test.msg, test.offset)
+		}
+	}
+}
+
+// Replayed exit trace of openat(AT_FDCWD, "/etc/passwd", O_RDONLY) = -ENOENT
+func filterTrace() *Trace {
+	return &Trace{
+		Signature: &Signature{Id: 257, Name: "openat", Args: []Arg{
+			{Name: "dfd", Type: type_int},
+			{Name: "filename", Type: type_stringc},
+			{Name: "flags", Type: type_int},
+		}},
+		Pid: 42,
+		Args: []ArgValue{
+			{Str: "AT_FDCWD", Raw: uint64(0xffffff9c)},
+			{Str: `"/etc/passwd"`},
+			{Str: "O_RDONLY", Raw: 0},
+		},
+		Return:   ReturnValue{Code: -2, Description: "ENOENT (No such file or directory)"},
+		Exit:     true,
+		Duration: 5 * time.Millisecond,
+	}
+}
+
+func TestFilterExpr(t *testing.T) {
+	tests := []struct {
+		expr  string
+		match bool
+	}{
+		{`syscall == openat`, true},
+		{`syscall == "openat"`, true},
+		{`syscall != openat`, false},
+		{`syscall == send`, false}, // Only a subcall of socketcall
+		{`syscall =~ "^open"`, true},
+		{`name == openat`, false}, // The name arg of getxattr...
+
+		// in and not in
+		{`syscall in (open, openat)`, true},
+		{`syscall in (open, creat)`, false},
+		{`syscall not in (open, openat)`, false},
+		{`pid in (1, 42)`, true},
+
+		// and binds tighter than or, not tighter than and
+		{`syscall == read or syscall == openat and ret == 0`, false},
+		{`(syscall == read or syscall == openat) and ret < 0`, true},
+		{`syscall == openat or syscall == read and ret == 0`, true},
+		{`not syscall == read and pid == 42`, true},
+		{`not (syscall == openat and pid == 42)`, false},
+		{`syscall == openat && !(pid == 1 || ret == 0)`, true},
+
+		// Regexps on the string args
+		{`path =~ "^/etc/"`, true},
+		{`path !~ "^/etc/"`, false},
+		{`filename =~ "passwd$"`, true},
+		{`filename == "/etc/passwd"`, true},
+
+		// Quoted and bare errno names, or numbers
+		{`errno == ENOENT`, true},
+		{`errno == "ENOENT"`, true},
+		{`errno == 2`, true},
+		{`errno == EACCES`, false},
+		{`errno in (EACCES, "ENOENT")`, true},
+
+		// Numbers and durations
+		{`ret < 0 and duration > 1ms`, true},
+		{`duration >= 10ms`, false},
+		{`flags == 0 and flags != 1`, true},
+		{`exit == true`, true},
+	}
+	for _, test := range tests {
+		f, err := CompileFilter(test.expr)
+		if err != nil {
+			t.Errorf("%s: %s", test.expr, err)
+			continue
+		}
+		if match := f.match(&matcher{trace: filterTrace()}); match != test.match {
+			t.Errorf("%s: match %v, want %v", test.expr, match, test.match)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package libtrace
+
+import (
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Predicate on the traces, built with the Match* functions
+// and combined with And, Or and Not.
+// The cheap predicates are evaluated first, the args are only
+// decoded if a predicate needs them.
+type Filter interface {
+	cost() int
+	match(m *matcher) bool
+}
+
+// Cost of the predicates
+const (
+	costTrace   = iota // Fields of the trace
+	costRegs           // Raw values of the args
+	costPeek           // Strings read from the tracee memory
+	costDecoded        // Args decoded
+)
+
+// Trace being filtered
+type matcher struct {
+	t     *tracerImpl
+	trace *Trace
+	state *syscallState
+
+	args      []ArgValue // Decoded on demand
+	argsRead  bool
+	paths     []string // Read on demand
+	pathsRead bool
+}
+
+func (m *matcher) decodedArgs() []ArgValue {
+	if m.state == nil {
+		// Replayed trace
+		return m.trace.Args
+	}
+	if !m.argsRead {
+		// Decode on a copy: the args of the enter traces aren't populated
+		trace := *m.trace
+		m.t.decodeArgs(&trace, m.state)
+		m.args = trace.Args
+		m.argsRead = true
+	}
+	return m.args
+}
+
+func (m *matcher) pathArgs() []string {
+	if !m.pathsRead {
+		if m.state != nil {
+			m.paths = m.t.pathArgs(m.trace, m.state)
+		} else {
+			for i, arg := range m.trace.Signature.Args {
+				if _, ok := arg.Type.(StringC); ok && i < len(m.trace.Args) {
+					m.paths = append(m.paths, m.stringArg(i))
+				}
+			}
+		}
+		m.pathsRead = true
+	}
+	return m.paths
+}
+
+// Raw value of the arg i
+func (m *matcher) param(i int) regParam {
+	if m.state == nil {
+		if i >= 0 && i < len(m.trace.Args) {
+			return regParam(m.trace.Args[i].Raw)
+		}
+		return 0
+	}
+	return m.state.param(i)
+}
+
+// Value of the string arg i, read from the tracee memory
+// or from the decoded value of a replayed trace
+func (m *matcher) stringArg(i int) string {
+	if m.state == nil {
+		if i >= 0 && i < len(m.trace.Args) {
+			return unquoteArg(m.trace.Args[i].Str)
+		}
+		return ""
+	}
+	str, _ := peekStringC(m.trace.Pid, m.state.param(i), pathMax)
+	return str
+}
+
+// Remove the quotes and the truncation mark of a decoded string
+func unquoteArg(str string) string {
+	str = strings.TrimSuffix(str, "...")
+	if len(str) >= 2 && str[0] == '"' && str[len(str)-1] == '"' {
+		str = str[1 : len(str)-1]
+	}
+	return str
+}
+
+// Raw values of the C string args of the syscall
+func (t *tracerImpl) pathArgs(trace *Trace, state *syscallState) (paths []string) {
+	for i, arg := range trace.Signature.Args {
+		if _, ok := arg.Type.(StringC); !ok {
+			continue
+		}
+		if path, err := peekStringC(trace.Pid, state.param(i), pathMax); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	return
+}
+
+func matchPathPrefix(paths []string, prefix string) bool {
+	for _, path := range paths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *tracerImpl) SetFilter(filter Filter) {
+	t.filter.Store(filterBox{filter})
+}
+
+// atomic.Value can't store a nil interface
+type filterBox struct {
+	filter Filter
+}
+
+func (t *tracerImpl) currentFilter() Filter {
+	if box, ok := t.filter.Load().(filterBox); ok {
+		return box.filter
+	}
+	return nil
+}
+
+type predicate struct {
+	c  int
+	fn func(m *matcher) bool
+}
+
+func (p predicate) cost() int             { return p.c }
+func (p predicate) match(m *matcher) bool { return p.fn(m) }
+
+// Match the syscalls by name
+func MatchSyscall(names ...string) Filter {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return predicate{costTrace, func(m *matcher) bool {
+		return set[m.trace.Name]
+	}}
+}
+
+// Match the syscalls of the tasks
+func MatchPid(pids ...int) Filter {
+	return predicate{costTrace, func(m *matcher) bool {
+		for _, pid := range pids {
+			if m.trace.Pid == pid {
+				return true
+			}
+		}
+		return false
+	}}
+}
+
+// Match the raw value of the arg i
+func MatchArg(i int, match func(value uint64) bool) Filter {
+	return predicate{costRegs, func(m *matcher) bool {
+		if i < 0 || i >= len(m.trace.Signature.Args) {
+			return false
+		}
+		return match(uint64(m.param(i)))
+	}}
+}
+
+// Match the decoded value of the arg i
+func MatchArgValue(i int, match func(arg ArgValue) bool) Filter {
+	return predicate{costDecoded, func(m *matcher) bool {
+		if i < 0 || i >= len(m.trace.Signature.Args) {
+			return false
+		}
+		return match(m.decodedArgs()[i])
+	}}
+}
+
+// Match the syscalls having a string arg (path) matching
+func MatchPath(match func(path string) bool) Filter {
+	return predicate{costPeek, func(m *matcher) bool {
+		for _, path := range m.pathArgs() {
+			if match(path) {
+				return true
+			}
+		}
+		return false
+	}}
+}
+
+// Match the syscalls having a string arg (path) starting with the prefix
+func MatchPathPrefix(prefix string) Filter {
+	return predicate{costPeek, func(m *matcher) bool {
+		return matchPathPrefix(m.pathArgs(), prefix)
+	}}
+}
+
+// Match the syscalls which failed with one of the errors (exit only)
+func MatchErrno(errnos ...syscall.Errno) Filter {
+	return predicate{costTrace, func(m *matcher) bool {
+		if !m.trace.Exit {
+			return false
+		}
+		errno := m.trace.Return.Errno()
+		for _, e := range errnos {
+			if errno == e {
+				return true
+			}
+		}
+		return false
+	}}
+}
+
+// Match the syscalls which failed (exit only)
+func MatchFailed() Filter {
+	return predicate{costTrace, func(m *matcher) bool {
+		return m.trace.Exit && m.trace.Return.Failed()
+	}}
+}
+
+// Match the syscalls which succeeded (exit only)
+func MatchSucceeded() Filter {
+	return predicate{costTrace, func(m *matcher) bool {
+		return m.trace.Exit && !m.trace.Return.Failed()
+	}}
+}
+
+// Match the syscalls which lasted at least min (exit only)
+func MatchDuration(min time.Duration) Filter {
+	return predicate{costTrace, func(m *matcher) bool {
+		return m.trace.Exit && m.trace.Duration >= min
+	}}
+}
+
+// Match with a function, the args are decoded before the call
+func MatchFunc(match func(trace *Trace) bool) Filter {
+	return predicate{costDecoded, func(m *matcher) bool {
+		trace := *m.trace
+		trace.Args = m.decodedArgs()
+		return match(&trace)
+	}}
+}
+
+type and []Filter
+type or []Filter
+type not struct{ f Filter }
+
+// Match when all the filters match
+func And(filters ...Filter) Filter {
+	return and(byCost(filters))
+}
+
+// Match when one of the filters matches
+func Or(filters ...Filter) Filter {
+	return or(byCost(filters))
+}
+
+// Match when the filter doesn't match
+func Not(filter Filter) Filter {
+	return not{filter}
+}
+
+func byCost(filters []Filter) []Filter {
+	sorted := append([]Filter(nil), filters...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].cost() < sorted[j].cost()
+	})
+	return sorted
+}
+
+func (fs and) cost() int { return maxCost(fs) }
+func (fs or) cost() int  { return maxCost(fs) }
+func (n not) cost() int  { return n.f.cost() }
+
+func maxCost(filters []Filter) (c int) {
+	for _, f := range filters {
+		if f.cost() > c {
+			c = f.cost()
+		}
+	}
+	return
+}
+
+func (fs and) match(m *matcher) bool {
+	for _, f := range fs {
+		if !f.match(m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (fs or) match(m *matcher) bool {
+	for _, f := range fs {
+		if f.match(m) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n not) match(m *matcher) bool {
+	return !n.f.match(m)
+}
@@ -1,119 +1,177 @@
 package libtrace
 
-import "os/exec"
+import (
+	"log"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+)
 
 func NewTracer(cmd *exec.Cmd) Tracer {
-	return &tracerImpl{
-		cmd: cmd,
-		globalCallbacksOnEnter: make([]TracerCb, 0, 1),
-		globalCallbacksOnExit:  make([]TracerCb, 0, 1),
-		callbacksOnEnter:       make(map[string][]TracerCb),
-		callbacksOnExit:        make(map[string][]TracerCb),
-		globalChannelsOnEnter:  make([]chan<- *Trace, 0, 1),
-		globalChannelsOnExit:   make([]chan<- *Trace, 0, 1),
-		channelsOnEnter:        make(map[string][]chan<- *Trace),
-		channelsOnExit:         make(map[string][]chan<- *Trace),
+	t := &tracerImpl{
+		cmd:        cmd,
+		deliveries: make(map[chan<- *Trace]*channelDelivery),
+
+		logger: stdLogger{},
 
 		maxStringSize: 32,
 		maxBufferSize: 32,
 	}
+	t.reg.Store(newRegistry())
+	return t
 }
 
 type tracerImpl struct {
+	// First, to be 64 bits aligned for the atomic operations on 386
+	traces uint64 // Traces dispatched (atomic)
+
 	cmd *exec.Cmd
 
-	globalCallbacksOnEnter []TracerCb
-	globalCallbacksOnExit  []TracerCb
-	callbacksOnEnter       map[string][]TracerCb
-	callbacksOnExit        map[string][]TracerCb
+	// Current *registry
+	reg          atomic.Value
+	registryLock sync.Mutex // Held while changing the registry
+	lastEntryId  uint64
+
+	filter atomic.Value // filterBox
+	policy atomic.Value // *Policy
+
+	deliveriesLock sync.Mutex
+	deliveries     map[chan<- *Trace]*channelDelivery
+	deliveryOrder  []*channelDelivery // In registration order
+
+	clock       *Clock
+	clockBases  map[int]int64 // Real time of the virtual clocks at the start of Run, in ns
+	followForks bool
+	seccomp     bool
+	useSeccomp  bool // Seccomp filter installed for this run
+	tasks       map[int]*task
 
-	globalChannelsOnEnter []chan<- *Trace
-	globalChannelsOnExit  []chan<- *Trace
-	channelsOnEnter       map[string][]chan<- *Trace
-	channelsOnExit        map[string][]chan<- *Trace
+	// PTRACE_GET_SYSCALL_INFO not supported by the kernel
+	noSyscallInfo bool
+
+	logger Logger
 
 	maxStringSize uint64
 	maxBufferSize uint64
+	decodeOnEnter bool
 }
 
-func (t *tracerImpl) RegisterCb(cb TracerCb, fnNames ...string) {
-	t.RegisterCbOnEnter(cb, fnNames...)
-	t.RegisterCbOnExit(cb, fnNames...)
+func (t *tracerImpl) RegisterCb(cb TracerCb, fnNames ...string) Handle {
+	return handles{
+		t.RegisterCbOnEnter(cb, fnNames...),
+		t.RegisterCbOnExit(cb, fnNames...),
+	}
 }
 
-func (t *tracerImpl) RegisterCbOnEnter(cb TracerCb, fnNames ...string) {
-	var cbs []TracerCb
-	for _, name := range fnNames {
-		if cbs = t.callbacksOnEnter[name]; cbs == nil {
-			cbs = make([]TracerCb, 0, 1)
-		}
-		cbs = append(cbs, cb)
-		t.callbacksOnEnter[name] = cbs
-	}
+func (t *tracerImpl) RegisterCbOnEnter(cb TracerCb, fnNames ...string) Handle {
+	return t.register(entry{cb: cb}, func(r *registry, e entry) {
+		addNamed(r.callbacksOnEnter, e, fnNames)
+	})
+}
+
+func (t *tracerImpl) RegisterCbOnExit(cb TracerCb, fnNames ...string) Handle {
+	return t.register(entry{cb: cb}, func(r *registry, e entry) {
+		addNamed(r.callbacksOnExit, e, fnNames)
+	})
 }
 
-func (t *tracerImpl) RegisterCbOnExit(cb TracerCb, fnNames ...string) {
-	var cbs []TracerCb
-	for _, name := range fnNames {
-		if cbs = t.callbacksOnExit[name]; cbs == nil {
-			cbs = make([]TracerCb, 0, 1)
-		}
-		cbs = append(cbs, cb)
-		t.callbacksOnExit[name] = cbs
+func (t *tracerImpl) RegisterGlobalCb(cb TracerCb) Handle {
+	return handles{
+		t.RegisterGlobalCbOnEnter(cb),
+		t.RegisterGlobalCbOnExit(cb),
 	}
 }
 
-func (t *tracerImpl) RegisterGlobalCb(cb TracerCb) {
-	t.RegisterGlobalCbOnEnter(cb)
-	t.RegisterGlobalCbOnExit(cb)
+func (t *tracerImpl) RegisterGlobalCbOnEnter(cb TracerCb) Handle {
+	return t.register(entry{cb: cb}, func(r *registry, e entry) {
+		r.globalCallbacksOnEnter = append(r.globalCallbacksOnEnter, e)
+	})
 }
 
-func (t *tracerImpl) RegisterGlobalCbOnEnter(cb TracerCb) {
-	t.globalCallbacksOnEnter = append(t.globalCallbacksOnEnter, cb)
+func (t *tracerImpl) RegisterGlobalCbOnExit(cb TracerCb) Handle {
+	return t.register(entry{cb: cb}, func(r *registry, e entry) {
+		r.globalCallbacksOnExit = append(r.globalCallbacksOnExit, e)
+	})
 }
 
-func (t *tracerImpl) RegisterGlobalCbOnExit(cb TracerCb) {
-	t.globalCallbacksOnExit = append(t.globalCallbacksOnExit, cb)
+func (t *tracerImpl) RegisterChannel(out chan<- *Trace, fnNames ...string) Handle {
+	return handles{
+		t.RegisterChannelOnEnter(out, fnNames...),
+		t.RegisterChannelOnExit(out, fnNames...),
+	}
 }
 
-func (t *tracerImpl) RegisterChannel(out chan<- *Trace, fnNames ...string) {
-	t.RegisterChannelOnEnter(out, fnNames...)
-	t.RegisterChannelOnExit(out, fnNames...)
+func (t *tracerImpl) RegisterChannelOnEnter(out chan<- *Trace, fnNames ...string) Handle {
+	return t.register(entry{out: out}, func(r *registry, e entry) {
+		addNamed(r.channelsOnEnter, e, fnNames)
+	})
 }
 
-func (t *tracerImpl) RegisterChannelOnEnter(out chan<- *Trace, fnNames ...string) {
-	var cbs []chan<- *Trace
-	for _, name := range fnNames {
-		if cbs = t.channelsOnEnter[name]; cbs == nil {
-			cbs = make([]chan<- *Trace, 0, 1)
-		}
-		cbs = append(cbs, out)
-		t.channelsOnEnter[name] = cbs
-	}
+func (t *tracerImpl) RegisterChannelOnExit(out chan<- *Trace, fnNames ...string) Handle {
+	return t.register(entry{out: out}, func(r *registry, e entry) {
+		addNamed(r.channelsOnExit, e, fnNames)
+	})
 }
 
-func (t *tracerImpl) RegisterChannelOnExit(out chan<- *Trace, fnNames ...string) {
-	var cbs []chan<- *Trace
-	for _, name := range fnNames {
-		if cbs = t.channelsOnExit[name]; cbs == nil {
-			cbs = make([]chan<- *Trace, 0, 1)
-		}
-		cbs = append(cbs, out)
-		t.channelsOnExit[name] = cbs
+func (t *tracerImpl) RegisterGlobalChannel(out chan<- *Trace) Handle {
+	return handles{
+		t.RegisterGlobalChannelOnEnter(out),
+		t.RegisterGlobalChannelOnExit(out),
 	}
 }
 
-func (t *tracerImpl) RegisterGlobalChannel(out chan<- *Trace) {
-	t.RegisterGlobalChannelOnEnter(out)
-	t.RegisterGlobalChannelOnExit(out)
+func (t *tracerImpl) RegisterGlobalChannelOnEnter(out chan<- *Trace) Handle {
+	return t.register(entry{out: out}, func(r *registry, e entry) {
+		r.globalChannelsOnEnter = append(r.globalChannelsOnEnter, e)
+	})
+}
+
+func (t *tracerImpl) RegisterGlobalChannelOnExit(out chan<- *Trace) Handle {
+	return t.register(entry{out: out}, func(r *registry, e entry) {
+		r.globalChannelsOnExit = append(r.globalChannelsOnExit, e)
+	})
+}
+
+func (t *tracerImpl) RegisterEventCb(cb EventCb) Handle {
+	return t.register(entry{eventCb: cb}, func(r *registry, e entry) {
+		r.eventCallbacks = append(r.eventCallbacks, e)
+	})
+}
+
+func (t *tracerImpl) RegisterEventChannel(out chan<- *Event) Handle {
+	return t.register(entry{eventOut: out}, func(r *registry, e entry) {
+		r.eventChannels = append(r.eventChannels, e)
+	})
 }
 
-func (t *tracerImpl) RegisterGlobalChannelOnEnter(out chan<- *Trace) {
-	t.globalChannelsOnEnter = append(t.globalChannelsOnEnter, out)
+func (t *tracerImpl) RegisterDelay(rule DelayRule, fnNames ...string) Handle {
+	return t.register(entry{delay: rule}, func(r *registry, e entry) {
+		addNamed(r.delays, e, fnNames)
+	})
 }
 
-func (t *tracerImpl) RegisterGlobalChannelOnExit(out chan<- *Trace) {
-	t.globalChannelsOnExit = append(t.globalChannelsOnExit, out)
+func (t *tracerImpl) RegisterGlobalDelay(rule DelayRule) Handle {
+	return t.register(entry{delay: rule}, func(r *registry, e entry) {
+		r.globalDelays = append(r.globalDelays, e)
+	})
+}
+
+func (t *tracerImpl) RegisterRedirect(rule RedirectRule) Handle {
+	return t.register(entry{redirect: rule}, func(r *registry, e entry) {
+		r.redirects = append(r.redirects, e)
+	})
+}
+
+func (t *tracerImpl) SetFollowForks(follow bool) {
+	t.followForks = follow
+}
+
+func (t *tracerImpl) SetClock(clock *Clock) {
+	t.clock = clock
+}
+
+func (t *tracerImpl) SetSeccomp(enabled bool) {
+	t.seccomp = enabled
 }
 
 func (t *tracerImpl) SetMaxStringSize(strSize uint64) {
@@ -123,3 +181,24 @@ func (t *tracerImpl) SetMaxStringSize(strSize uint64) {
 func (t *tracerImpl) SetMaxBufferSize(bufferSize uint64) {
 	t.maxBufferSize = bufferSize
 }
+
+func (t *tracerImpl) SetDecodeArgsOnEnter(enabled bool) {
+	t.decodeOnEnter = enabled
+}
+
+func (t *tracerImpl) SetLogger(logger Logger) {
+	t.logger = logger
+}
+
+func (t *tracerImpl) logf(format string, v ...interface{}) {
+	if t.logger != nil {
+		t.logger.Printf(format, v...)
+	}
+}
+
+// Logger of the standard log package
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
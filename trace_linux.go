@@ -3,12 +3,28 @@ package libtrace
 import (
 	"encoding/binary"
 	"fmt"
-	"log"
+	"os"
 	"reflect"
 	"runtime"
+	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
+// Max time to sleep between two polls of the tasks
+// while some of them are held by a delay
+const heldPollInterval = time.Millisecond
+
+// A traced task (process or thread)
+type task struct {
+	pid       int
+	started   bool          // Initial stop received
+	inSyscall bool          // Between the enter and the exit stop of a syscall
+	entry     *syscallState // State at the enter stop of the current syscall
+	heldUntil time.Time     // Held by a delay until then when not zero
+}
+
 func (t *tracerImpl) Run() (err error) {
 
 	if t.cmd.SysProcAttr == nil {
@@ -17,15 +33,25 @@ func (t *tracerImpl) Run() (err error) {
 		t.cmd.SysProcAttr.Ptrace = true
 	}
 
+	var rules []seccompRule
+	t.useSeccomp = false
+	if t.seccomp {
+		if rules, t.useSeccomp = t.seccompRules(); !t.useSeccomp {
+			t.logf("All the syscalls are traced, the seccomp filter is not installed")
+		}
+	}
+
 	runtime.LockOSThread()
+	defer t.closeDeliveries()
 
 	if err = t.cmd.Start(); err != nil {
 		return
 	}
 
+	pid := t.cmd.Process.Pid
 	var waitStatus syscall.WaitStatus
 
-	if _, err = syscall.Wait4(t.cmd.Process.Pid, &waitStatus, 0, nil); err != nil {
+	if _, err = syscall.Wait4(pid, &waitStatus, 0, nil); err != nil {
 		return
 	}
 
@@ -33,65 +59,248 @@ func (t *tracerImpl) Run() (err error) {
 		return
 	}
 
+	if t.useSeccomp {
+		// Stopped after the exec of the command
+		if err := installSeccomp(pid, rules); err != nil {
+			t.logf("Can't install the seccomp filter in %d, all the syscalls are traced: %s", pid, err)
+			t.useSeccomp = false
+		}
+	}
+
+	if t.clock != nil {
+		t.startClock()
+		// Stopped after the exec of the command
+		t.hideVdso(pid)
+	}
+
 	// Set options to detect our syscalls
-	if err = syscall.PtraceSetOptions(t.cmd.Process.Pid, syscall.PTRACE_O_TRACESYSGOOD); err != nil {
+	// and to get the exec events instead of a SIGTRAP
+	options := syscall.PTRACE_O_TRACESYSGOOD | syscall.PTRACE_O_TRACEEXEC
+	if t.followForks {
+		options |= syscall.PTRACE_O_TRACECLONE | syscall.PTRACE_O_TRACEFORK | syscall.PTRACE_O_TRACEVFORK
+	}
+	if t.useSeccomp {
+		options |= _PTRACE_O_TRACESECCOMP
+	}
+	if err = syscall.PtraceSetOptions(pid, options); err != nil {
 		return
 	}
 
-	var regsEntry, regsExit syscall.PtraceRegs
-	// Get first syscall
-	if err = syscall.PtraceGetRegs(t.cmd.Process.Pid, &regsEntry); err != nil {
+	t.tasks = map[int]*task{pid: &task{pid: pid, started: true}}
+	if err = t.resume(t.tasks[pid], 0); err != nil {
 		return
 	}
 
-	var exited bool
-	for {
-		if exited, err = wait_for_syscall(t.cmd.Process.Pid); exited || err != nil {
+	for len(t.tasks) > 0 {
+		if pid, err = t.wait(&waitStatus); err != nil {
 			return
 		}
-
-		// Get syscall info
-		if err = syscall.PtraceGetRegs(t.cmd.Process.Pid, &regsEntry); err != nil {
+		if err = t.handleStop(pid, waitStatus); err != nil {
 			return
 		}
+	}
+	return
+}
 
-		// Enter syscall
-		t.callback(regsEntry, false)
-
-		if exited, err = wait_for_syscall(t.cmd.Process.Pid); exited || err != nil {
+// Wait for the next state change of a traced task.
+// The held tasks are resumed when their delay is over,
+// without blocking the other tasks meanwhile.
+func (t *tracerImpl) wait(waitStatus *syscall.WaitStatus) (pid int, err error) {
+	waitPid := t.cmd.Process.Pid
+	if t.followForks {
+		// New tasks are not known before their first stop
+		waitPid = -1
+	}
+	for {
+		var next time.Time
+		if next, err = t.resumeHeld(); err != nil {
 			return
 		}
-
-		// Get syscall returned value
-		if err = syscall.PtraceGetRegs(t.cmd.Process.Pid, &regsExit); err != nil {
+		options := syscall.WALL
+		if !next.IsZero() {
+			options |= syscall.WNOHANG
+		}
+		pid, err = syscall.Wait4(waitPid, waitStatus, options, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || pid != 0 {
 			return
 		}
-		t.callback(regsExit, true)
+		sleep := time.Until(next)
+		if sleep > heldPollInterval {
+			sleep = heldPollInterval
+		}
+		time.Sleep(sleep)
 	}
 }
 
-func wait_for_syscall(pid int) (exited bool, err error) {
-	var waitStatus syscall.WaitStatus
-	for {
-		// Entering a syscall
-		if err = syscall.PtraceSyscall(pid, 0); err != nil {
-			return
+// Resume the held tasks whose delay is over
+// and return the end of the next delay (zero if no task is held)
+func (t *tracerImpl) resumeHeld() (next time.Time, err error) {
+	now := time.Now()
+	for _, tsk := range t.tasks {
+		if tsk.heldUntil.IsZero() {
+			continue
+		}
+		if !now.Before(tsk.heldUntil) {
+			tsk.heldUntil = time.Time{}
+			if err = t.resume(tsk, 0); err != nil {
+				return
+			}
+			continue
+		}
+		if next.IsZero() || tsk.heldUntil.Before(next) {
+			next = tsk.heldUntil
 		}
+	}
+	return
+}
 
-		if _, err = syscall.Wait4(pid, &waitStatus, 0, nil); err != nil {
-			return
+// Resume the task, delivering the signal if not 0
+func (t *tracerImpl) resume(tsk *task, sig syscall.Signal) (err error) {
+	if t.useSeccomp && !tsk.inSyscall {
+		// The next syscall to trace will be reported by a seccomp stop
+		err = syscall.PtraceCont(tsk.pid, int(sig))
+	} else {
+		err = syscall.PtraceSyscall(tsk.pid, int(sig))
+	}
+	if err == syscall.ESRCH {
+		// Killed meanwhile, its exit will be reported by wait
+		return nil
+	}
+	return err
+}
+
+func (t *tracerImpl) handleStop(pid int, waitStatus syscall.WaitStatus) (err error) {
+	if waitStatus.Exited() || waitStatus.Signaled() {
+		if _, ok := t.tasks[pid]; ok {
+			event := Event{Type: EventExit, Pid: pid}
+			if waitStatus.Exited() {
+				event.ExitCode = waitStatus.ExitStatus()
+			} else {
+				event.Signal = waitStatus.Signal()
+				event.CoreDump = waitStatus.CoreDump()
+			}
+			t.event(&event)
 		}
+		delete(t.tasks, pid)
+		return
+	}
+	if !waitStatus.Stopped() {
+		return
+	}
+
+	tsk, ok := t.tasks[pid]
+	if !ok {
+		// The first stop of a new task can be reported
+		// before the fork event of its parent
+		tsk = &task{pid: pid}
+		t.tasks[pid] = tsk
+	}
 
-		// Is it for us ?
-		if waitStatus.Stopped() && waitStatus.StopSignal()&0x80 == 0x80 {
+	var sig syscall.Signal
+	switch {
+	case !tsk.started:
+		// Initial SIGSTOP of a new task
+		tsk.started = true
+	case waitStatus.StopSignal() == syscall.SIGTRAP|0x80:
+		var held bool
+		if held, err = t.syscallStop(tsk, false); err != nil || held {
 			return
 		}
-
-		if waitStatus.Exited() {
-			exited = true
+	case waitStatus.TrapCause() == _PTRACE_EVENT_SECCOMP:
+		// Since Linux 4.8, the seccomp stop replaces the enter stop
+		// and is followed by the exit stop
+		var held bool
+		if held, err = t.syscallStop(tsk, true); err != nil || held {
+			return
+		}
+	case waitStatus.TrapCause() == syscall.PTRACE_EVENT_EXEC:
+		var msg uint
+		if msg, err = syscall.PtraceGetEventMsg(pid); err != nil {
 			return
 		}
+		if former, ok := t.tasks[int(msg)]; ok && int(msg) != pid {
+			// A thread other than the leader did the exec:
+			// it took over the leader pid
+			delete(t.tasks, former.pid)
+			former.pid = pid
+			t.tasks[pid] = former
+			tsk = former
+		}
+		event := Event{Type: EventExec, Pid: pid}
+		if int(msg) != pid {
+			event.FormerPid = int(msg)
+		}
+		event.Path, _ = os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+		if t.clock != nil {
+			t.hideVdso(pid)
+		}
+		t.event(&event)
+	case waitStatus.TrapCause() == syscall.PTRACE_EVENT_CLONE,
+		waitStatus.TrapCause() == syscall.PTRACE_EVENT_FORK,
+		waitStatus.TrapCause() == syscall.PTRACE_EVENT_VFORK:
+		var msg uint
+		if msg, err = syscall.PtraceGetEventMsg(pid); err != nil {
+			return
+		}
+		if _, ok := t.tasks[int(msg)]; !ok {
+			t.tasks[int(msg)] = &task{pid: int(msg)}
+		}
+		event := Event{Type: EventClone, Pid: pid, ChildPid: int(msg)}
+		switch waitStatus.TrapCause() {
+		case syscall.PTRACE_EVENT_FORK:
+			event.Type = EventFork
+		case syscall.PTRACE_EVENT_VFORK:
+			event.Type = EventVfork
+		}
+		t.event(&event)
+	case waitStatus.TrapCause() != -1:
+		// Other ptrace events
+	default:
+		// Signal delivery stop: deliver it, unless it is a group stop
+		// (the signal has already been delivered)
+		if !isGroupStop(pid) {
+			sig = waitStatus.StopSignal()
+			t.event(&Event{Type: EventSignal, Pid: pid, Signal: sig})
+		}
 	}
+
+	return t.resume(tsk, sig)
+}
+
+// Dispatch the event to the event callbacks
+func (t *tracerImpl) event(event *Event) {
+	event.Time = time.Now()
+	t.dispatchEvent(event)
+}
+
+func (t *tracerImpl) dispatchEvent(event *Event) {
+	r := t.registry()
+	for _, e := range r.eventCallbacks {
+		e.eventCb(event)
+	}
+	for _, e := range r.eventChannels {
+		e.eventOut <- event
+	}
+}
+
+// Handle a syscall enter or exit stop.
+// Returns true when the task is held by a delay.
+func (t *tracerImpl) syscallStop(tsk *task, seccompStop bool) (held bool, err error) {
+	var state *syscallState
+	if state, err = t.getSyscallState(tsk, seccompStop); err != nil {
+		return
+	}
+
+	trace := t.callback(tsk.pid, state)
+
+	if d := t.delay(trace, state); d > 0 {
+		tsk.heldUntil = time.Now().Add(d)
+		held = true
+	}
+	return
 }
 
 var unknownSignature Signature = Signature{
@@ -102,127 +311,184 @@ var unknownSignature Signature = Signature{
 
 type decodeReturnCodeFn func(trace *Trace)
 
-func (t *tracerImpl) callback_generic(regs syscall.PtraceRegs, exit bool) {
+func (t *tracerImpl) callback_generic(pid int, state *syscallState) *Trace {
 
-	id, argOffset := getSyscallId(regs)
+	id, exit := state.id, state.exit
 
 	trace := Trace{
-		Exit: exit,
-	}
-	if id < SyscallId(len(syscalls)) {
-		trace.Signature = syscalls[id]
-		if trace.Signature == &unknownSignature {
-			trace.Signature = &Signature{}
-			*trace.Signature = unknownSignature
-			trace.Signature.Id = id
-			trace.Signature.Name = fmt.Sprintf("*UNKNOWN(%d)*", id)
+		Pid:                pid,
+		Exit:               exit,
+		Personality:        state.abi.personality,
+		Arch:               state.arch,
+		InstructionPointer: state.ip,
+		StackPointer:       state.sp,
+		Time:               state.time,
+		params:             &state.args,
+	}
+	trace.Signature = state.abi.signature(id)
+
+	fm := &matcher{t: t, trace: &trace, state: state}
+	if exit {
+		trace.Return.Code = state.ret
+		trace.Policy = state.policy
+		if state.redirected != nil {
+			t.restoreRedirected(&trace, state)
+		}
+		if state.policy == PolicyDeny {
+			t.denyReturn(&trace, state)
+		} else if t.clock != nil {
+			t.clockExit(&trace, state)
+		}
+		t.decodeReturnCode(&trace, state)
+		if !state.start.IsZero() {
+			trace.Duration = state.time.Sub(state.start)
 		}
 	} else {
-		trace.Signature = &Signature{}
-		*trace.Signature = unknownSignature
-		trace.Signature.Id = id
-		trace.Signature.Name = fmt.Sprintf("*UNKNOWN(%d)*", id)
+		t.enforce(fm)
+		if state.policy == PolicyAllow || state.policy == PolicyLog {
+			t.redirect(&trace, state)
+			if t.clock != nil {
+				t.clockEnter(&trace, state)
+			}
+		}
 	}
 
-	if exit {
-		trace.Return.Code = getReturnCode(regs)
-		t.decodeReturnCode(&trace)
+	t.dispatch(fm)
+	return &trace
+}
+
+// Dispatch the trace to the callbacks and channels if it matches the filter.
+// The state is nil for the replayed traces, their args are already decoded.
+func (t *tracerImpl) dispatch(fm *matcher) {
+	if filter := t.currentFilter(); filter != nil && !filter.match(fm) {
+		return
+	}
+	atomic.AddUint64(&t.traces, 1)
+
+	trace := fm.trace
+	exit := trace.Exit
+	if (exit || t.decodeOnEnter) && fm.state != nil {
 		// Populate args values
-		t.decodeArgs(&trace, regs, argOffset)
+		if fm.argsRead {
+			trace.Args = fm.args
+		} else {
+			t.decodeArgs(trace, fm.state)
+		}
 	}
 
-	var l []TracerCb
+	r := t.registry()
+	var l []entry
 	if !exit {
-		l = t.globalCallbacksOnEnter
+		l = r.globalCallbacksOnEnter
 	} else {
-		l = t.globalCallbacksOnExit
+		l = r.globalCallbacksOnExit
 	}
-	for _, cb := range l {
-		cb(&trace)
+	for _, e := range l {
+		e.cb(trace)
 	}
-	var m map[string][]TracerCb
+	var m map[string][]entry
 	if !exit {
-		m = t.callbacksOnEnter
+		m = r.callbacksOnEnter
 	} else {
-		m = t.callbacksOnExit
+		m = r.callbacksOnExit
 	}
 
 	if c, ok := m[trace.Signature.Name]; ok {
-		for _, cb := range c {
-			cb(&trace)
+		for _, e := range c {
+			e.cb(trace)
 		}
 	}
 
-	var lc []chan<- *Trace
+	var lc []entry
 	if !exit {
-		lc = t.globalChannelsOnEnter
+		lc = r.globalChannelsOnEnter
 	} else {
-		lc = t.globalChannelsOnExit
+		lc = r.globalChannelsOnExit
 	}
-	for _, in := range lc {
-		in <- &trace
+	for _, e := range lc {
+		t.deliver(e.delivery, trace)
 	}
-	var mc map[string][]chan<- *Trace
+	var mc map[string][]entry
 	if !exit {
-		mc = t.channelsOnEnter
+		mc = r.channelsOnEnter
 	} else {
-		mc = t.channelsOnExit
+		mc = r.channelsOnExit
 	}
 	if c, ok := mc[trace.Signature.Name]; ok {
-		for _, in := range c {
-			in <- &trace
+		for _, e := range c {
+			t.deliver(e.delivery, trace)
 		}
 	}
 }
 
-func (t *tracerImpl) decodeReturnCode(trace *Trace) {
-	if fn, ok := decodeReturnCodeFnMap[trace.Id]; ok {
+func (t *tracerImpl) decodeReturnCode(trace *Trace, state *syscallState) {
+	if fn, ok := state.abi.decodeReturnCodeFns[trace.Id]; ok {
 		fn(trace)
+	} else {
+		decodeReturnCodeLinux(trace)
 	}
 }
 
-func (t *tracerImpl) decodeArgs(trace *Trace, regs syscall.PtraceRegs, argsOffset int) {
+func (t *tracerImpl) decodeArgs(trace *Trace, state *syscallState) {
 	if trace.Signature.Args == nil {
 		trace.Args = []ArgValue{
 			ArgValue{Str: "*ARGSNOTDEFINED*"},
 		}
 		return
 	}
-	if len(trace.Signature.Args) <= argsOffset {
-		return
+	trace.Args = make([]ArgValue, len(trace.Signature.Args))
+	for i := range trace.Args {
+		trace.Args[i].Raw = uint64(state.param(i))
 	}
 
-	trace.Args = make([]ArgValue, len(trace.Signature.Args)-argsOffset)
+	if state.argsErr != nil {
+		// The args of the multiplexed syscall could not be read
+		for i := range trace.Args {
+			trace.Args[i].Str = "?"
+			trace.Args[i].Err = state.argsErr
+		}
+		return
+	}
 
-	defaultDecode := t.customDecodeArgs(trace, regs)
+	defaultDecode := t.customDecodeArgs(trace, state)
 
 	if defaultDecode {
 		var stringBuffers []int = make([]int, 0, len(trace.Args))
-		for i, arg := range trace.Signature.Args[argsOffset:] {
+		for i, arg := range trace.Signature.Args {
+			if i >= len(state.args) {
+				trace.Args[i].Str = "?"
+				trace.Args[i].Err = fmt.Errorf("arg index out of range: %d", i)
+				continue
+			}
 			switch arg.Type.(type) {
 			case Buffer:
 				stringBuffers = append(stringBuffers, i)
 			default:
-				t.decodeArg(arg.Type, getParam(regs, i), &trace.Args[i])
+				t.decodeArg(trace.Pid, arg.Type, state.param(i), &trace.Args[i])
 			}
 		}
 		for _, i := range stringBuffers {
 			size := uint64(0)
-			v := trace.Signature.Args[argsOffset+i].Type.(Buffer)
-			switch v {
-			case -1:
-				size = uint64(trace.Return.Code)
-			case 0, 1, 2, 3, 4, 5, 6:
-				size = uint64(getParam(regs, int(v)))
+			v := trace.Signature.Args[i].Type.(Buffer)
+			switch {
+			case v == -1:
+				if trace.Return.Code > 0 {
+					size = uint64(trace.Return.Code)
+				}
+			case v >= 0 && int(v) < len(state.args):
+				size = uint64(state.param(int(v)))
 			default:
-				log.Printf("StringBuffer CountPos is invalid: %d\n", v)
+				trace.Args[i].Value = state.param(i)
+				trace.Args[i].Str = fmt.Sprintf("%#x", state.param(i))
+				trace.Args[i].Err = fmt.Errorf("invalid buffer size position: %d", v)
+				continue
 			}
-			trace.Args[i].Value, trace.Args[i].Str = t.decodeArgBuffer(getParam(regs, i), size)
+			trace.Args[i].Value, trace.Args[i].Str, trace.Args[i].Err = t.decodeArgBuffer(trace.Pid, state.param(i), size)
 		}
 	}
 }
 
-func (t *tracerImpl) decodeArg(typ interface{}, value regParam, argValue *ArgValue) {
+func (t *tracerImpl) decodeArg(pid int, typ interface{}, value regParam, argValue *ArgValue) {
 
 	if reflect.TypeOf(typ).Kind() == reflect.Ptr && value == 0 {
 		argValue.Str = "NULL"
@@ -232,7 +498,7 @@ func (t *tracerImpl) decodeArg(typ interface{}, value regParam, argValue *ArgVal
 
 	switch typ.(type) {
 	case StringC:
-		argValue.Str = t.decodeArgStringC(value)
+		argValue.Str, argValue.Err = t.decodeArgStringC(pid, value)
 		argValue.Value = argValue.Str
 
 	case int, int8, int16,
@@ -243,12 +509,18 @@ func (t *tracerImpl) decodeArg(typ interface{}, value regParam, argValue *ArgVal
 		argValue.Str = fmt.Sprintf("%d", argValue.Value)
 	case *uint64:
 		var out []byte = make([]byte, 8)
-		count, err := syscall.PtracePeekData(t.cmd.Process.Pid, uintptr(value), out)
+		count, err := syscall.PtracePeekData(pid, uintptr(value), out)
 		if err != nil {
-			log.Printf("Error while reading syscall arg: %s", err)
+			argValue.Value = value
+			argValue.Str = fmt.Sprintf("%#x", value)
+			argValue.Err = fmt.Errorf("reading syscall arg: %s", err)
+			return
 		}
 		if count != 8 {
-			log.Printf("Error while reading syscall arg: count = %d (should be 8)", count)
+			argValue.Value = value
+			argValue.Str = fmt.Sprintf("%#x", value)
+			argValue.Err = fmt.Errorf("reading syscall arg: count = %d (should be 8)", count)
+			return
 		}
 		argValue.Value = binary.LittleEndian.Uint64(out)
 		argValue.Str = fmt.Sprintf("%d", argValue.Value)
@@ -258,13 +530,19 @@ func (t *tracerImpl) decodeArg(typ interface{}, value regParam, argValue *ArgVal
 	}
 }
 
-func (t *tracerImpl) decodeArgStringC(value regParam) string {
+func (t *tracerImpl) decodeArgStringC(pid int, value regParam) (string, error) {
 	out := []byte{0}
 	str := make([]byte, 0, 10)
 	i := uint64(0)
 	extra := false
 	for {
-		count, err := syscall.PtracePeekData(t.cmd.Process.Pid, uintptr(value+regParam(i)), out)
+		count, err := syscall.PtracePeekData(pid, uintptr(value+regParam(i)), out)
+		if err != nil {
+			return fmt.Sprintf("%#x", value), fmt.Errorf("reading syscall arg: %s", err)
+		}
+		if count != 1 {
+			return fmt.Sprintf("%#x", value), fmt.Errorf("reading syscall arg: count = %d (should be 1)", count)
+		}
 		if out[0] == 0 {
 			break
 		}
@@ -272,25 +550,7 @@ func (t *tracerImpl) decodeArgStringC(value regParam) string {
 			extra = true
 			break
 		}
-		if err != nil {
-			log.Printf("Error while reading syscall arg: %s", err)
-		}
-		if count != 1 {
-			log.Printf("Error while reading syscall arg: count = %d (should be 1)", count)
-		}
-		switch {
-		case out[0] == '\n':
-			str = append(str, '\\', 'n')
-		case out[0] == '\r':
-			str = append(str, '\\', 'r')
-		case out[0] == '\t':
-			str = append(str, '\\', 't')
-		case out[0] >= ' ' && out[0] <= '~':
-			str = append(str, out[0])
-		default:
-			str = append(str, []byte(fmt.Sprintf("\\%d", out[0]))...)
-		}
-
+		str = appendEscaped(str, out[0])
 		i++
 	}
 
@@ -299,15 +559,12 @@ func (t *tracerImpl) decodeArgStringC(value regParam) string {
 		result += "..."
 	}
 
-	return result
+	return result, nil
 }
 
-func (t *tracerImpl) decodeArgBuffer(value regParam, size uint64) (buffer []byte, str string) {
-	if size < 0 {
-		return nil, ""
-	}
+func (t *tracerImpl) decodeArgBuffer(pid int, value regParam, size uint64) (buffer []byte, str string, err error) {
 	if size == 0 {
-		return []byte{}, ""
+		return []byte{}, "", nil
 	}
 
 	bufferSize := size
@@ -317,30 +574,17 @@ func (t *tracerImpl) decodeArgBuffer(value regParam, size uint64) (buffer []byte
 		bufferSize = t.maxBufferSize
 	}
 	buffer = make([]byte, bufferSize)
-	count, err := syscall.PtracePeekData(t.cmd.Process.Pid, uintptr(value), buffer)
+	count, err := syscall.PtracePeekData(pid, uintptr(value), buffer)
 	if err != nil {
-		str = fmt.Sprintf("Error while reading syscall arg: %s", err)
-		return
+		return nil, fmt.Sprintf("%#x", value), fmt.Errorf("reading syscall arg: %s", err)
 	}
 	if uint64(count) != bufferSize {
-		str = fmt.Sprintf("Error while reading syscall arg: count = %d (should be %d)", count, bufferSize)
-		return
+		return nil, fmt.Sprintf("%#x", value), fmt.Errorf("reading syscall arg: count = %d (should be %d)", count, bufferSize)
 	}
 	strBuffer := make([]byte, 0, bufferSize+2)
 	strBuffer = append(strBuffer, '"')
 	for _, b := range buffer {
-		switch {
-		case b == '\n':
-			strBuffer = append(strBuffer, '\\', 'n')
-		case b == '\r':
-			strBuffer = append(strBuffer, '\\', 'r')
-		case b == '\t':
-			strBuffer = append(strBuffer, '\\', 't')
-		case b >= ' ' && b <= '~':
-			strBuffer = append(strBuffer, b)
-		default:
-			strBuffer = append(strBuffer, []byte(fmt.Sprintf("\\%d", b))...)
-		}
+		strBuffer = appendEscaped(strBuffer, b)
 	}
 
 	strBuffer = append(strBuffer, '"')
@@ -352,6 +596,62 @@ func (t *tracerImpl) decodeArgBuffer(value regParam, size uint64) (buffer []byte
 	return
 }
 
+// Append a byte of a string arg, escaped like strace does:
+// \n, \r, \t, \\, \" or 3 octal digits for the other bytes
+// not printable
+func appendEscaped(str []byte, b byte) []byte {
+	switch {
+	case b == '\n':
+		return append(str, '\\', 'n')
+	case b == '\r':
+		return append(str, '\\', 'r')
+	case b == '\t':
+		return append(str, '\\', 't')
+	case b == '\\' || b == '"':
+		return append(str, '\\', b)
+	case b >= ' ' && b <= '~':
+		return append(str, b)
+	}
+	return append(str, '\\', '0'+b>>6, '0'+b>>3&7, '0'+b&7)
+}
+
+// Reverse of appendEscaped, false if the string is not
+// quoted or truncated
+func unescapeString(str string) (string, bool) {
+	if strings.HasSuffix(str, "...") || len(str) < 2 || str[0] != '"' || str[len(str)-1] != '"' {
+		return "", false
+	}
+	str = str[1 : len(str)-1]
+	unescaped := make([]byte, 0, len(str))
+	for i := 0; i < len(str); i++ {
+		if str[i] != '\\' || i+1 == len(str) {
+			unescaped = append(unescaped, str[i])
+			continue
+		}
+		i++
+		switch c := str[i]; {
+		case c == 'n':
+			unescaped = append(unescaped, '\n')
+		case c == 'r':
+			unescaped = append(unescaped, '\r')
+		case c == 't':
+			unescaped = append(unescaped, '\t')
+		case '0' <= c && c <= '7':
+			// \NNN, 1 to 3 octal digits
+			b := c - '0'
+			for n := 1; n < 3 && i+1 < len(str) && '0' <= str[i+1] && str[i+1] <= '7'; n++ {
+				i++
+				b = b<<3 | (str[i] - '0')
+			}
+			unescaped = append(unescaped, b)
+		default:
+			// \\ and \"
+			unescaped = append(unescaped, c)
+		}
+	}
+	return string(unescaped), true
+}
+
 func decodeReturnCodeLinux(trace *Trace) {
 	if trace.Return.Code < 0 {
 		if d, ok := linuxReturnCodes[-int(trace.Return.Code)]; ok {
@@ -1,7 +1,6 @@
 package libtrace
 
 import (
-	"log"
 	"syscall"
 )
 
@@ -11,49 +10,109 @@ type ReturnCode int32
 
 type regParam int32
 
-func getParam(regs syscall.PtraceRegs, i int) regParam {
-	switch i {
-	case 0:
-		return regParam(regs.Ebx)
-	case 1:
-		return regParam(regs.Ecx)
-	case 2:
-		return regParam(regs.Edx)
-	case 3:
-		return regParam(regs.Esi)
-	case 4:
-		return regParam(regs.Edi)
-	case 5:
-		return regParam(regs.Ebp)
+var abis = []*abi{abiI386}
+
+func (s *syscallState) readRegs(pid int) error {
+	var regs syscall.PtraceRegs
+	if err := syscall.PtraceGetRegs(pid, &regs); err != nil {
+		return err
 	}
-	log.Fatalf("index out of range: %d", i)
-	return 0
+
+	s.abi = abiI386
+	s.setSyscall(pid, SyscallId(regs.Orig_eax), [6]regParam{
+		regParam(regs.Ebx),
+		regParam(regs.Ecx),
+		regParam(regs.Edx),
+		regParam(regs.Esi),
+		regParam(regs.Edi),
+		regParam(regs.Ebp),
+	})
+	s.ret = ReturnCode(regs.Eax)
+	s.arch = s.abi.arch
+	s.ip = uint64(uint32(regs.Eip))
+	s.sp = uint64(uint32(regs.Esp))
+	return nil
 }
 
-func getReturnCode(regs syscall.PtraceRegs) ReturnCode {
-	return ReturnCode(regs.Eax)
+// Stack pointer and personality of a stopped task
+func taskStack(pid int) (sp uint64, personality Personality, err error) {
+	var regs syscall.PtraceRegs
+	if err = syscall.PtraceGetRegs(pid, &regs); err != nil {
+		return
+	}
+	return uint64(uint32(regs.Esp)), PersonalityI386, nil
 }
 
-func getSyscallId(regs syscall.PtraceRegs) (SyscallId, int) {
-	if regs.Orig_eax == 102 /*socketcall*/ {
-		return SyscallId(regs.Ebx + 400), 1
-	} else if regs.Orig_eax == 117 /* ipc */ {
-		return SyscallId(regs.Ebx + 420), 1
-	} else {
-		return SyscallId(regs.Orig_eax), 0
+// Skip the syscall at its enter stop, it returns -ENOSYS
+func skipSyscall(pid int) error {
+	var regs syscall.PtraceRegs
+	if err := syscall.PtraceGetRegs(pid, &regs); err != nil {
+		return err
 	}
+	regs.Orig_eax = -1
+	return syscall.PtraceSetRegs(pid, &regs)
 }
 
-func (t *tracerImpl) callback(regs syscall.PtraceRegs, exit bool) {
-	// params: %ebx, %ecx, %edx, %esi, %edi, %ebp
-	t.callback_generic(regs, exit)
+// Set the return code of the syscall at its exit stop
+func setSyscallReturn(pid int, code ReturnCode) error {
+	var regs syscall.PtraceRegs
+	if err := syscall.PtraceGetRegs(pid, &regs); err != nil {
+		return err
+	}
+	regs.Eax = int32(code)
+	return syscall.PtraceSetRegs(pid, &regs)
 }
 
-func (t *tracerImpl) customDecodeArgs(trace *Trace, regs syscall.PtraceRegs) bool {
-	return true
+// Set the args registers of the syscall
+func setSyscallArgs(pid int, personality Personality, args map[int]regParam) error {
+	var regs syscall.PtraceRegs
+	if err := syscall.PtraceGetRegs(pid, &regs); err != nil {
+		return err
+	}
+	params := []*int32{&regs.Ebx, &regs.Ecx, &regs.Edx, &regs.Esi, &regs.Edi, &regs.Ebp}
+	for i, value := range args {
+		*params[i] = int32(value)
+	}
+	return syscall.PtraceSetRegs(pid, &regs)
+}
+
+// Execute a syscall in the stopped task, with an int 0x80 at its
+// instruction pointer, then restore its registers
+func injectSyscall(pid int, id SyscallId, args ...regParam) (ret ReturnCode, err error) {
+	var saved, regs syscall.PtraceRegs
+	if err = syscall.PtraceGetRegs(pid, &saved); err != nil {
+		return
+	}
+	regs = saved
+	params := []*int32{&regs.Ebx, &regs.Ecx, &regs.Edx, &regs.Esi, &regs.Edi, &regs.Ebp}
+	for i, arg := range args {
+		*params[i] = int32(arg)
+	}
+	// Not in a syscall: no restart of the syscall on the resume
+	regs.Eax, regs.Orig_eax = int32(id), -1
+	if err = syscall.PtraceSetRegs(pid, &regs); err != nil {
+		return
+	}
+	defer func() {
+		if restoreErr := syscall.PtraceSetRegs(pid, &saved); err == nil {
+			err = restoreErr
+		}
+	}()
+
+	if err = stepInstruction(pid, uint64(uint32(regs.Eip)), insnInt80); err != nil {
+		return
+	}
+	if err = syscall.PtraceGetRegs(pid, &regs); err != nil {
+		return
+	}
+	return ReturnCode(regs.Eax), nil
 }
 
-var decodeReturnCodeFnMap = map[SyscallId]decodeReturnCodeFn{
-	3 /*open*/ : decodeReturnCodeLinux,
-	5 /*open*/ : decodeReturnCodeLinux,
+func (t *tracerImpl) callback(pid int, state *syscallState) *Trace {
+	// params: %ebx, %ecx, %edx, %esi, %edi, %ebp
+	return t.callback_generic(pid, state)
+}
+
+func (t *tracerImpl) customDecodeArgs(trace *Trace, state *syscallState) bool {
+	return true
 }
@@ -2,70 +2,277 @@ package libtrace
 
 import (
 	"fmt"
-	"log"
 	"syscall"
+	"unsafe"
 )
 
 type SyscallId uint64
 
-type ReturnCode int32
+type ReturnCode int64
 
 type regParam uint64
 
-// Get the value of the param (0 from 5 allowed)
-func getParam(regs syscall.PtraceRegs, i int) regParam {
-	switch i {
-	case 0:
-		return regParam(regs.Rdi)
-	case 1:
-		return regParam(regs.Rsi)
-	case 2:
-		return regParam(regs.Rdx)
-	case 3:
-		return regParam(regs.Rcx)
-	case 4:
-		return regParam(regs.R8)
-	case 5:
-		return regParam(regs.R9)
-	}
-	log.Fatalf("index out of range: %d", i)
-	return 0
+// AUDIT_ARCH_X86_64
+const auditArchX86_64 = 0xc000003e
+
+var abiX86_64 = &abi{
+	personality:         PersonalityX86_64,
+	arch:                auditArchX86_64,
+	syscalls:            syscallsX86_64,
+	decodeReturnCodeFns: decodeReturnCodeFnMap,
+	demux: func(pid int, id SyscallId, args [6]regParam) (SyscallId, [6]regParam, error) {
+		return id, args, nil
+	},
+	seccompSyscallId: func(id SyscallId) SyscallId {
+		return id
+	},
+}
+
+// The native ABI first
+var abis = []*abi{abiX86_64, abiI386}
+
+// struct user_regs_struct of an ia32 task
+type i386Regs struct {
+	Ebx, Ecx, Edx, Esi, Edi, Ebp, Eax      uint32
+	Xds, Xes, Xfs, Xgs, Orig_eax, Eip, Xcs uint32
+	Eflags, Esp, Xss                       uint32
+}
+
+// The personality is detected from the size of the general
+// registers set, which is the ia32 one for the ia32 tasks.
+// An int 0x80 from a 64 bits task is detected from the instruction
+// before the instruction pointer: if it can't be read, the syscall
+// is seen as x86_64 with abiUnknown set.
+func (s *syscallState) readRegs(pid int) error {
+	var regs syscall.PtraceRegs
+	iov := syscall.Iovec{Base: (*byte)(unsafe.Pointer(&regs))}
+	iov.SetLen(int(unsafe.Sizeof(regs)))
+	if err := getRegSet(pid, _NT_PRSTATUS, &iov); err != nil {
+		return err
+	}
+
+	if iov.Len == uint64(unsafe.Sizeof(i386Regs{})) {
+		regs32 := (*i386Regs)(unsafe.Pointer(&regs))
+		// params: %ebx, %ecx, %edx, %esi, %edi, %ebp
+		s.abi = abiI386
+		s.setSyscall(pid, SyscallId(regs32.Orig_eax), [6]regParam{
+			regParam(regs32.Ebx),
+			regParam(regs32.Ecx),
+			regParam(regs32.Edx),
+			regParam(regs32.Esi),
+			regParam(regs32.Edi),
+			regParam(regs32.Ebp),
+		})
+		s.ret = ReturnCode(int32(regs32.Eax))
+		s.arch = s.abi.arch
+		s.ip = uint64(regs32.Eip)
+		s.sp = uint64(regs32.Esp)
+		return nil
+	}
+
+	s.ip = regs.Rip
+	s.sp = regs.Rsp
+	int80, err := isInt80(pid, regs.Rip)
+	if err != nil {
+		s.abiUnknown = true
+	}
+	if int80 {
+		// ia32 syscall: params: %ebx, %ecx, %edx, %esi, %edi, %ebp
+		s.abi = abiI386
+		s.setSyscall(pid, SyscallId(uint32(regs.Orig_rax)), [6]regParam{
+			regParam(uint32(regs.Rbx)),
+			regParam(uint32(regs.Rcx)),
+			regParam(uint32(regs.Rdx)),
+			regParam(uint32(regs.Rsi)),
+			regParam(uint32(regs.Rdi)),
+			regParam(uint32(regs.Rbp)),
+		})
+		s.ret = ReturnCode(int32(regs.Rax))
+		s.arch = s.abi.arch
+		return nil
+	}
+
+	// params: %rdi, %rsi, %rdx, %r10, %r8, %r9
+	s.abi = abiX86_64
+	s.setSyscall(pid, SyscallId(regs.Orig_rax), [6]regParam{
+		regParam(regs.Rdi),
+		regParam(regs.Rsi),
+		regParam(regs.Rdx),
+		regParam(regs.R10),
+		regParam(regs.R8),
+		regParam(regs.R9),
+	})
+	s.ret = ReturnCode(regs.Rax)
+	s.arch = s.abi.arch
+	return nil
+}
+
+// True if the instruction before ip is an int 0x80
+func isInt80(pid int, ip uint64) (bool, error) {
+	insn := make([]byte, 2)
+	if n, err := syscall.PtracePeekText(pid, uintptr(ip-2), insn); err != nil {
+		return false, err
+	} else if n != len(insn) {
+		return false, syscall.EFAULT
+	}
+	return insn[0] == 0xcd && insn[1] == 0x80, nil
+}
+
+// Stack pointer and personality of a stopped task
+func taskStack(pid int) (sp uint64, personality Personality, err error) {
+	var regs syscall.PtraceRegs
+	iov := syscall.Iovec{Base: (*byte)(unsafe.Pointer(&regs))}
+	iov.SetLen(int(unsafe.Sizeof(regs)))
+	if err = getRegSet(pid, _NT_PRSTATUS, &iov); err != nil {
+		return
+	}
+	if iov.Len == uint64(unsafe.Sizeof(i386Regs{})) {
+		return uint64((*i386Regs)(unsafe.Pointer(&regs)).Esp), PersonalityI386, nil
+	}
+	return regs.Rsp, PersonalityX86_64, nil
+}
+
+// Change the general registers of the task, in the layout
+// of its personality (ia32 or x86_64)
+func modifyRegs(pid int, set64 func(regs *syscall.PtraceRegs), set32 func(regs *i386Regs)) error {
+	var regs syscall.PtraceRegs
+	iov := syscall.Iovec{Base: (*byte)(unsafe.Pointer(&regs))}
+	iov.SetLen(int(unsafe.Sizeof(regs)))
+	if err := getRegSet(pid, _NT_PRSTATUS, &iov); err != nil {
+		return err
+	}
+	if iov.Len == uint64(unsafe.Sizeof(i386Regs{})) {
+		set32((*i386Regs)(unsafe.Pointer(&regs)))
+	} else {
+		set64(&regs)
+	}
+	return setRegSet(pid, _NT_PRSTATUS, &iov)
+}
+
+// Skip the syscall at its enter stop, it returns -ENOSYS
+func skipSyscall(pid int) error {
+	return modifyRegs(pid, func(regs *syscall.PtraceRegs) {
+		regs.Orig_rax = ^uint64(0)
+	}, func(regs *i386Regs) {
+		regs.Orig_eax = ^uint32(0)
+	})
 }
 
-func getReturnCode(regs syscall.PtraceRegs) ReturnCode {
-	return ReturnCode(regs.Rax)
+// Set the return code of the syscall at its exit stop
+func setSyscallReturn(pid int, code ReturnCode) error {
+	return modifyRegs(pid, func(regs *syscall.PtraceRegs) {
+		regs.Rax = uint64(code)
+	}, func(regs *i386Regs) {
+		regs.Eax = uint32(code)
+	})
 }
 
-func getSyscallId(regs syscall.PtraceRegs) (SyscallId, int) {
-	return SyscallId(regs.Orig_rax), 0
+// Set the args registers of the syscall
+// (an int 0x80 from a 64 bits task uses the 64 bits registers)
+func setSyscallArgs(pid int, personality Personality, args map[int]regParam) error {
+	return modifyRegs(pid, func(regs *syscall.PtraceRegs) {
+		params := []*uint64{&regs.Rdi, &regs.Rsi, &regs.Rdx, &regs.R10, &regs.R8, &regs.R9}
+		if personality == PersonalityI386 {
+			params = []*uint64{&regs.Rbx, &regs.Rcx, &regs.Rdx, &regs.Rsi, &regs.Rdi, &regs.Rbp}
+		}
+		for i, value := range args {
+			*params[i] = uint64(value)
+		}
+	}, func(regs *i386Regs) {
+		params := []*uint32{&regs.Ebx, &regs.Ecx, &regs.Edx, &regs.Esi, &regs.Edi, &regs.Ebp}
+		for i, value := range args {
+			*params[i] = uint32(value)
+		}
+	})
+}
+
+// Execute a syscall in the stopped task, with the syscall instruction
+// of its personality at its instruction pointer, then restore its
+// registers
+func injectSyscall(pid int, id SyscallId, args ...regParam) (ret ReturnCode, err error) {
+	var saved, regs syscall.PtraceRegs
+	iov := syscall.Iovec{Base: (*byte)(unsafe.Pointer(&saved))}
+	iov.SetLen(int(unsafe.Sizeof(saved)))
+	if err = getRegSet(pid, _NT_PRSTATUS, &iov); err != nil {
+		return
+	}
+	size := int(iov.Len)
+	is32 := iov.Len == uint64(unsafe.Sizeof(i386Regs{}))
+
+	regs = saved
+	var ip uint64
+	var insn []byte
+	if is32 {
+		regs32 := (*i386Regs)(unsafe.Pointer(&regs))
+		params := []*uint32{&regs32.Ebx, &regs32.Ecx, &regs32.Edx, &regs32.Esi, &regs32.Edi, &regs32.Ebp}
+		for i, arg := range args {
+			*params[i] = uint32(arg)
+		}
+		// Not in a syscall: no restart of the syscall on the resume
+		regs32.Eax, regs32.Orig_eax = uint32(id), ^uint32(0)
+		ip, insn = uint64(regs32.Eip), insnInt80
+	} else {
+		params := []*uint64{&regs.Rdi, &regs.Rsi, &regs.Rdx, &regs.R10, &regs.R8, &regs.R9}
+		for i, arg := range args {
+			*params[i] = uint64(arg)
+		}
+		regs.Rax, regs.Orig_rax = uint64(id), ^uint64(0)
+		ip, insn = regs.Rip, insnSyscall
+	}
+	iov = syscall.Iovec{Base: (*byte)(unsafe.Pointer(&regs))}
+	iov.SetLen(size)
+	if err = setRegSet(pid, _NT_PRSTATUS, &iov); err != nil {
+		return
+	}
+	defer func() {
+		iov := syscall.Iovec{Base: (*byte)(unsafe.Pointer(&saved))}
+		iov.SetLen(size)
+		if restoreErr := setRegSet(pid, _NT_PRSTATUS, &iov); err == nil {
+			err = restoreErr
+		}
+	}()
+
+	if err = stepInstruction(pid, ip, insn); err != nil {
+		return
+	}
+	iov.SetLen(int(unsafe.Sizeof(regs)))
+	if err = getRegSet(pid, _NT_PRSTATUS, &iov); err != nil {
+		return
+	}
+	if is32 {
+		return ReturnCode(int32((*i386Regs)(unsafe.Pointer(&regs)).Eax)), nil
+	}
+	return ReturnCode(regs.Rax), nil
 }
 
-func (t *tracerImpl) callback(regs syscall.PtraceRegs, exit bool) {
-	// params: %rdi, %rsi, %rdx, %rcx, %r8, %r9
-	t.callback_generic(regs, exit)
+func (t *tracerImpl) callback(pid int, state *syscallState) *Trace {
+	return t.callback_generic(pid, state)
 }
 
-func (t *tracerImpl) customDecodeArgs(trace *Trace, regs syscall.PtraceRegs) bool {
+func (t *tracerImpl) customDecodeArgs(trace *Trace, state *syscallState) bool {
+	if trace.Personality != PersonalityX86_64 {
+		return true
+	}
 	switch trace.Id {
 	case 158 /*arch_prctl*/ :
-		code := getParam(regs, 0)
+		code := state.param(0)
 		trace.Args[0].Value = code
 		switch code {
 		case /*ARCH_SET_GS*/ 0x1001:
 			trace.Args[0].Str = "ARCH_SET_GS"
-			trace.Args[1].Value = getParam(regs, 1)
+			trace.Args[1].Value = state.param(1)
 		case /*ARCH_SET_FS*/ 0x1002:
 			trace.Args[0].Str = "ARCH_SET_FS"
-			trace.Args[1].Value = getParam(regs, 1)
+			trace.Args[1].Value = state.param(1)
 		case /*ARCH_GET_FS*/ 0x1003:
 			trace.Args[0].Str = "ARCH_GET_FS"
-			t.decodeArg(&type_uint64, getParam(regs, 1), &trace.Args[1])
+			t.decodeArg(trace.Pid, &type_uint64, state.param(1), &trace.Args[1])
 		case /*ARCH_GET_GS*/ 0x1004:
 			trace.Args[0].Str = "ARCH_GET_GS"
-			t.decodeArg(&type_uint64, getParam(regs, 1), &trace.Args[1])
+			t.decodeArg(trace.Pid, &type_uint64, state.param(1), &trace.Args[1])
 		default:
 			trace.Args[0].Str = "*Unknown*"
-			trace.Args[1].Value = getParam(regs, 1)
+			trace.Args[1].Value = state.param(1)
 		}
 		trace.Args[1].Str = fmt.Sprintf("%d", trace.Args[1].Value)
 		return false
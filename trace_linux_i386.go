@@ -0,0 +1,141 @@
+package libtrace
+
+import (
+	"encoding/binary"
+	"syscall"
+)
+
+// i386 ABI, native on 386 and ia32 emulation on amd64
+
+// AUDIT_ARCH_I386
+const auditArchI386 = 0x40000003
+
+// The socketcall and ipc subcalls are in the subcalls table
+// from these ids (id = base + call), above the real syscall numbers
+const (
+	socketcallBase = 1000
+	ipcBase        = 1100
+)
+
+// Number of the socketcall subcalls, up to sendmmsg
+const socketcallCount = 21
+
+var abiI386 = &abi{
+	personality:         PersonalityI386,
+	arch:                auditArchI386,
+	syscalls:            syscallsI386,
+	subcalls:            subcallsI386,
+	subcallBase:         socketcallBase,
+	decodeReturnCodeFns: decodeReturnCodeFnMapI386,
+	demux:               demuxI386,
+	seccompSyscallId:    seccompSyscallIdI386,
+}
+
+// Get the id and args of the socketcall and ipc subcalls
+func demuxI386(pid int, id SyscallId, args [6]regParam) (SyscallId, [6]regParam, error) {
+	switch id {
+	case 102 /*socketcall*/ :
+		return demuxSocketcall(pid, args)
+	case 117 /* ipc */ :
+		return demuxIpc(pid, args)
+	}
+	return id, args, nil
+}
+
+// socketcall(call, args): the args of the subcall are packed
+// in an array of 32 bits words in the tracee memory
+func demuxSocketcall(pid int, args [6]regParam) (id SyscallId, subArgs [6]regParam, err error) {
+	call := uint32(args[0])
+	if call >= socketcallCount {
+		// Invalid call: the socketcall itself
+		return 102 /*socketcall*/, args, nil
+	}
+	id = SyscallId(call) + socketcallBase
+	n := len(subcallsI386[call].Args)
+	if n == 0 {
+		return
+	}
+	err = peekWords32(pid, args[1], subArgs[:n])
+	return
+}
+
+// Position of the subcall args in the args of
+// ipc(call, first, second, third, ptr, fifth)
+var ipcArgs = map[SyscallId][]int{
+	1101 /*semop*/ :      {1, 4, 2},
+	1102 /*semget*/ :     {1, 2, 3},
+	1103 /*semctl*/ :     {1, 2, 3, 4},
+	1104 /*semtimedop*/ : {1, 4, 2, 5},
+	1111 /*msgsnd*/ :     {1, 4, 2, 3},
+	1112 /*msgrcv*/ :     {1, 4, 2, 5, 3},
+	1113 /*msgget*/ :     {1, 2},
+	1114 /*msgctl*/ :     {1, 2, 4},
+	1121 /*shmat*/ :      {1, 4, 2},
+	1122 /*shmdt*/ :      {4},
+	1123 /*shmget*/ :     {1, 2, 3},
+	1124 /*shmctl*/ :     {1, 2, 4},
+}
+
+// ipc(call, first, second, third, ptr, fifth): the version
+// of the subcall is in the upper 16 bits of call
+func demuxIpc(pid int, args [6]regParam) (id SyscallId, subArgs [6]regParam, err error) {
+	call := uint32(args[0])
+	id = SyscallId(call&0xffff) + ipcBase
+	if id == 1112 /*msgrcv*/ && call>>16 == 0 {
+		// msgp and msgtyp are in a struct ipc_kludge pointed to by ptr
+		var kludge [2]regParam
+		if err = peekWords32(pid, args[4], kludge[:]); err != nil {
+			return
+		}
+		subArgs = [6]regParam{args[1], kludge[0], args[2], kludge[1], args[3]}
+		return
+	}
+	for i, pos := range ipcArgs[id] {
+		subArgs[i] = args[pos]
+	}
+	if id == 1103 /*semctl*/ && args[4] != 0 {
+		// The union semun arg is pointed to by ptr
+		var arg [1]regParam
+		if err = peekWords32(pid, args[4], arg[:]); err != nil {
+			return
+		}
+		subArgs[3] = arg[0]
+	}
+	return
+}
+
+// Read the tracee memory, replaced by the tests
+var peekData = syscall.PtracePeekData
+
+// Read 32 bits words from the tracee memory
+func peekWords32(pid int, addr regParam, words []regParam) error {
+	buf := make([]byte, 4*len(words))
+	count, err := peekData(pid, uintptr(addr), buf)
+	if err != nil {
+		return err
+	}
+	if count != len(buf) {
+		return syscall.EFAULT
+	}
+	for i := range words {
+		words[i] = regParam(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return nil
+}
+
+// The socketcall and ipc subcalls can only be filtered
+// by their multiplexer
+func seccompSyscallIdI386(id SyscallId) SyscallId {
+	switch {
+	case id >= ipcBase:
+		return 117 /* ipc */
+	case id >= socketcallBase:
+		return 102 /*socketcall*/
+	}
+	return id
+}
+
+var decodeReturnCodeFnMapI386 = map[SyscallId]decodeReturnCodeFn{
+	3 /*open*/ : decodeReturnCodeLinux,
+	5 /*open*/ : decodeReturnCodeLinux,
+}
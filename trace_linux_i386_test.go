@@ -0,0 +1,157 @@
+package libtrace
+
+import (
+	"encoding/binary"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// Fake tracee memory, from the address memBase
+const memBase = 0x1000
+
+func fakeMemory(t *testing.T, words ...uint32) {
+	mem := make([]byte, 4*len(words))
+	for i, w := range words {
+		binary.LittleEndian.PutUint32(mem[4*i:], w)
+	}
+	peekData = func(pid int, addr uintptr, out []byte) (int, error) {
+		if addr < memBase || addr >= memBase+uintptr(len(mem)) {
+			return 0, syscall.EIO
+		}
+		return copy(out, mem[addr-memBase:]), nil
+	}
+	t.Cleanup(func() { peekData = syscall.PtracePeekData })
+}
+
+func TestPeekWords32(t *testing.T) {
+	fakeMemory(t, 1, 0xffffffff, 3)
+	allOnes := uint32(0xffffffff)
+	tests := []struct {
+		addr  regParam
+		n     int
+		words []regParam
+		err   error
+	}{
+		{memBase, 3, []regParam{1, regParam(allOnes), 3}, nil},
+		{memBase + 4, 2, []regParam{regParam(allOnes), 3}, nil},
+		{memBase + 8, 2, []regParam{3, 0}, syscall.EFAULT},
+		{0, 1, []regParam{0}, syscall.EIO},
+	}
+	for _, test := range tests {
+		words := make([]regParam, test.n)
+		err := peekWords32(0, test.addr, words)
+		if err != test.err {
+			t.Errorf("peekWords32(%#x, %d): error %v, want %v", test.addr, test.n, err, test.err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(words, test.words) {
+			t.Errorf("peekWords32(%#x, %d) = %v, want %v", test.addr, test.n, words, test.words)
+		}
+	}
+}
+
+func TestDemuxSocketcall(t *testing.T) {
+	// connect(3, 0x2000, 16), sendto(4, 0x3000, 10, 0, 0x4000, 16),
+	// then sendmmsg(5, 0x5000, 2, 0)
+	fakeMemory(t, 3, 0x2000, 16, 4, 0x3000, 10, 0, 0x4000, 16, 5, 0x5000, 2, 0)
+	tests := []struct {
+		args    [6]regParam
+		id      SyscallId
+		name    string
+		subArgs [6]regParam
+		err     bool
+	}{
+		{[6]regParam{3, memBase}, 1003, "connect", [6]regParam{3, 0x2000, 16}, false},
+		{[6]regParam{11, memBase + 12}, 1011, "sendto", [6]regParam{4, 0x3000, 10, 0, 0x4000, 16}, false},
+		{[6]regParam{20, memBase + 36}, 1020, "sendmmsg", [6]regParam{5, 0x5000, 2, 0}, false},
+		{[6]regParam{1, 0}, 1001, "socket", [6]regParam{}, true},
+		{[6]regParam{0, memBase}, 1000, "socket_subcall", [6]regParam{}, false},
+		{[6]regParam{21, memBase}, 102, "socketcall", [6]regParam{21, memBase}, false},
+		{[6]regParam{99, memBase}, 102, "socketcall", [6]regParam{99, memBase}, false},
+	}
+	for _, test := range tests {
+		id, subArgs, err := demuxI386(0, 102, test.args)
+		if id != test.id || abiI386.signature(id).Name != test.name {
+			t.Errorf("socketcall%v: id %d (%s), want %d (%s)", test.args, id, abiI386.signature(id).Name, test.id, test.name)
+		}
+		if (err != nil) != test.err {
+			t.Errorf("socketcall%v: error %v", test.args, err)
+		}
+		if err == nil && subArgs != test.subArgs {
+			t.Errorf("socketcall%v: args %v, want %v", test.args, subArgs, test.subArgs)
+		}
+	}
+}
+
+func TestDemuxIpc(t *testing.T) {
+	// struct ipc_kludge{msgp 0x5000, msgtyp 7}, then union semun 42
+	fakeMemory(t, 0x5000, 7, 42)
+	tests := []struct {
+		args    [6]regParam
+		id      SyscallId
+		name    string
+		subArgs [6]regParam
+	}{
+		// semop(semid, tsops, nsops): ipc(1, semid, nsops, 0, tsops)
+		{[6]regParam{1, 5, 2, 0, 0x6000}, 1101, "semop", [6]regParam{5, 0x6000, 2}},
+		// semtimedop(semid, tsops, nsops, timeout): ipc(4, semid, nsops, 0, tsops, timeout)
+		{[6]regParam{4, 5, 2, 0, 0x6000, 0x7000}, 1104, "semtimedop", [6]regParam{5, 0x6000, 2, 0x7000}},
+		// semctl(semid, semnum, cmd, arg): ipc(3, semid, semnum, cmd, &arg)
+		{[6]regParam{3, 5, 1, 16, memBase + 8}, 1103, "semctl", [6]regParam{5, 1, 16, 42}},
+		// msgsnd(msqid, msgp, msgsz, msgflg): ipc(11, msqid, msgsz, msgflg, msgp)
+		{[6]regParam{11, 6, 100, 2048, 0x8000}, 1111, "msgsnd", [6]regParam{6, 0x8000, 100, 2048}},
+		// msgrcv version 0: ipc(12, msqid, msgsz, msgflg, &kludge)
+		{[6]regParam{12, 6, 100, 2048, memBase}, 1112, "msgrcv", [6]regParam{6, 0x5000, 100, 7, 2048}},
+		// msgrcv version 1: ipc(1<<16 | 12, msqid, msgsz, msgflg, msgp, msgtyp)
+		{[6]regParam{1<<16 | 12, 6, 100, 2048, 0x9000, 3}, 1112, "msgrcv", [6]regParam{6, 0x9000, 100, 3, 2048}},
+		// shmdt(shmaddr): ipc(22, 0, 0, 0, shmaddr)
+		{[6]regParam{22, 0, 0, 0, 0xa000}, 1122, "shmdt", [6]regParam{0xa000}},
+		// shmat(shmid, shmaddr, shmflg): ipc(21, shmid, shmflg, &raddr, shmaddr)
+		{[6]regParam{21, 8, 4096, 0xb000, 0xc000}, 1121, "shmat", [6]regParam{8, 0xc000, 4096}},
+	}
+	for _, test := range tests {
+		id, subArgs, err := demuxI386(0, 117, test.args)
+		if err != nil {
+			t.Errorf("ipc%v: error %v", test.args, err)
+			continue
+		}
+		if id != test.id || abiI386.signature(id).Name != test.name {
+			t.Errorf("ipc%v: id %d (%s), want %d (%s)", test.args, id, abiI386.signature(id).Name, test.id, test.name)
+		}
+		if subArgs != test.subArgs {
+			t.Errorf("ipc%v: args %v, want %v", test.args, subArgs, test.subArgs)
+		}
+	}
+}
+
+func TestSeccompSyscallIdI386(t *testing.T) {
+	tests := []struct {
+		id, nr SyscallId
+		name   string
+	}{
+		{5, 5, "open"},
+		{362, 362, "connect"},
+		{403, 403, "clock_gettime64"},
+		{407, 407, "clock_nanosleep_time64"},
+		{435, 435, "clone3"},
+		{436, 436, "close_range"},
+		{437, 437, "openat2"},
+		{439, 439, "faccessat2"},
+		{444, 444, "landlock_create_ruleset"},
+		{1003, 102, "connect"},
+		{1019, 102, "recvmmsg"},
+		{1020, 102, "sendmmsg"},
+		{1100, 117, "ipc_subcall"},
+		{1103, 117, "semctl"},
+		{1124, 117, "shmctl"},
+	}
+	for _, test := range tests {
+		if nr := seccompSyscallIdI386(test.id); nr != test.nr {
+			t.Errorf("seccompSyscallIdI386(%d) = %d, want %d", test.id, nr, test.nr)
+		}
+		if name := abiI386.signature(test.id).Name; name != test.name {
+			t.Errorf("signature(%d) = %s, want %s", test.id, name, test.name)
+		}
+	}
+}
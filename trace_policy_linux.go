@@ -0,0 +1,440 @@
+package libtrace
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/jfrabaute/libtrace/internal/procfs"
+)
+
+const (
+	atFdcwd = -100
+
+	// Max size of a struct sockaddr read to match the policy rules
+	sockaddrMax = 128
+)
+
+func (t *tracerImpl) SetPolicy(policy *Policy) {
+	t.policy.Store(policy)
+}
+
+func (t *tracerImpl) currentPolicy() *Policy {
+	policy, _ := t.policy.Load().(*Policy)
+	return policy
+}
+
+// Apply the policy at the enter stop of the syscall
+func (t *tracerImpl) enforce(m *matcher) {
+	policy := t.currentPolicy()
+	if policy == nil {
+		return
+	}
+	trace, state := m.trace, m.state
+
+	rule := -1
+	action, errno := policy.Default, policy.Errno
+	if state.abiUnknown {
+		// May be an int 0x80 from a 64 bits task, matched as x86_64
+		action, errno = PolicyDeny, syscall.ENOSYS
+	} else {
+		for i, r := range policy.Rules {
+			if r.Match == nil || r.Match.match(m) {
+				rule = i
+				action, errno = r.Action, r.Errno
+				break
+			}
+		}
+	}
+	if errno == 0 {
+		errno = syscall.EPERM
+	}
+
+	trace.Policy = action
+	state.policy = action
+	if action == PolicyAllow {
+		return
+	}
+	// Decoded before the process is killed
+	audit := *trace
+	audit.Args = m.decodedArgs()
+
+	switch action {
+	case PolicyDeny:
+		state.denyErrno = errno
+		if err := skipSyscall(trace.Pid); err != nil {
+			t.logf("Policy: can't deny %s of %d: %s", trace.Name, trace.Pid, err)
+		}
+	case PolicyKill:
+		// The syscall must not run before the process is killed
+		if err := skipSyscall(trace.Pid); err != nil {
+			t.logf("Policy: can't skip %s of %d: %s", trace.Name, trace.Pid, err)
+		}
+		if err := syscall.Kill(procfs.Tgid(trace.Pid), syscall.SIGKILL); err != nil {
+			t.logf("Policy: can't kill %d: %s", trace.Pid, err)
+		}
+	}
+	t.event(&Event{Type: EventPolicy, Pid: trace.Pid, Action: action, Rule: rule, Trace: &audit})
+}
+
+// Return code of the denied syscall at its exit stop
+func (t *tracerImpl) denyReturn(trace *Trace, state *syscallState) {
+	state.ret = ReturnCode(-int64(state.denyErrno))
+	trace.Return.Code = state.ret
+	if err := setSyscallReturn(trace.Pid, state.ret); err != nil {
+		t.logf("Policy: can't set the return of %s of %d: %s", trace.Name, trace.Pid, err)
+	}
+}
+
+// Socket address passed to connect, bind, sendto or sendmsg
+type Sockaddr struct {
+	Family int
+	IP     net.IP // AF_INET, AF_INET6
+	Port   int    // AF_INET, AF_INET6
+	Path   string // AF_UNIX, starting with "@" for the abstract names
+}
+
+func (a Sockaddr) String() string {
+	switch a.Family {
+	case syscall.AF_INET, syscall.AF_INET6:
+		return net.JoinHostPort(a.IP.String(), strconv.Itoa(a.Port))
+	case syscall.AF_UNIX:
+		return a.Path
+	}
+	return fmt.Sprintf("family %d", a.Family)
+}
+
+// Match the syscalls having a socket address matching
+// (connect, bind, sendto and sendmsg, not on a replay)
+func MatchSockaddr(match func(addr Sockaddr) bool) Filter {
+	return predicate{costPeek, func(m *matcher) bool {
+		addr, ok := m.sockaddr()
+		return ok && match(addr)
+	}}
+}
+
+// Match the syscalls having a path arg matching, made absolute
+// from the current directory or the directory fd of the *at syscalls.
+// Unlike MatchPath, only the args named like a path are matched.
+// The symbolic links are not resolved.
+func MatchResolvedPath(match func(path string) bool) Filter {
+	return predicate{costPeek, func(m *matcher) bool {
+		for _, path := range m.resolvedPaths() {
+			if match(path) {
+				return true
+			}
+		}
+		return false
+	}}
+}
+
+// Match the syscalls having a path arg, made absolute,
+// starting with the prefix, like "/etc/"
+func MatchResolvedPathPrefix(prefix string) Filter {
+	return MatchResolvedPath(func(path string) bool {
+		return strings.HasPrefix(path, prefix)
+	})
+}
+
+// Match the execve and execveat of the executables matching,
+// their path made absolute (not on a replay)
+func MatchExec(match func(path string) bool) Filter {
+	return predicate{costPeek, func(m *matcher) bool {
+		path, ok := m.execPath()
+		return ok && match(path)
+	}}
+}
+
+// Socket address arg of the syscall
+func (m *matcher) sockaddr() (addr Sockaddr, ok bool) {
+	if m.state == nil {
+		return
+	}
+	var ptr, size regParam
+	switch m.trace.Name {
+	case "connect", "bind":
+		ptr, size = m.param(1), m.param(2)
+	case "sendto":
+		ptr, size = m.param(4), m.param(5)
+	case "sendmsg":
+		// msg_name and msg_namelen at the start of struct msghdr
+		word := 8
+		if m.trace.Personality == PersonalityI386 {
+			word = 4
+		}
+		hdr := make([]byte, 2*word)
+		if n, err := syscall.PtracePeekData(m.trace.Pid, uintptr(m.param(1)), hdr); err != nil || n != len(hdr) {
+			return
+		}
+		if word == 4 {
+			ptr = regParam(binary.LittleEndian.Uint32(hdr))
+		} else {
+			ptr = regParam(binary.LittleEndian.Uint64(hdr))
+		}
+		size = regParam(binary.LittleEndian.Uint32(hdr[word:]))
+	default:
+		return
+	}
+	if ptr == 0 || size < 2 {
+		return
+	}
+	if size > sockaddrMax {
+		size = sockaddrMax
+	}
+	buf := make([]byte, size)
+	if n, err := syscall.PtracePeekData(m.trace.Pid, uintptr(ptr), buf); err != nil || n != len(buf) {
+		return
+	}
+	return parseSockaddr(buf)
+}
+
+func parseSockaddr(buf []byte) (addr Sockaddr, ok bool) {
+	addr.Family = int(binary.LittleEndian.Uint16(buf))
+	switch addr.Family {
+	case syscall.AF_INET:
+		if len(buf) < 8 {
+			return
+		}
+		addr.Port = int(binary.BigEndian.Uint16(buf[2:]))
+		addr.IP = net.IP(append([]byte(nil), buf[4:8]...))
+	case syscall.AF_INET6:
+		if len(buf) < 24 {
+			return
+		}
+		addr.Port = int(binary.BigEndian.Uint16(buf[2:]))
+		addr.IP = net.IP(append([]byte(nil), buf[8:24]...))
+	case syscall.AF_UNIX:
+		path := buf[2:]
+		if len(path) > 0 && path[0] == 0 {
+			addr.Path = "@" + string(path[1:])
+		} else {
+			if i := strings.IndexByte(string(path), 0); i >= 0 {
+				path = path[:i]
+			}
+			addr.Path = string(path)
+		}
+	}
+	return addr, true
+}
+
+// Path args of the syscall, made absolute
+func (m *matcher) resolvedPaths() (paths []string) {
+	if m.state == nil {
+		// Replayed trace: the paths are only known as passed
+		for i, arg := range m.trace.Signature.Args {
+			if _, ok := arg.Type.(StringC); ok && pathArgs[arg.Name] && i < len(m.trace.Args) {
+				paths = append(paths, filepath.Clean(m.stringArg(i)))
+			}
+		}
+		return
+	}
+	for _, arg := range syscallPathArgs(m.trace) {
+		path, err := peekStringC(m.trace.Pid, m.state.param(arg.index), pathMax)
+		if err != nil {
+			continue
+		}
+		paths = append(paths, resolvePath(m.trace.Pid, arg.dirfdOf(m.state), path))
+	}
+	return
+}
+
+// Path arg of a syscall, with the index of its directory fd arg, -1 if none
+type pathArg struct {
+	index, dirfd int
+}
+
+// Directory fd of the path arg, AT_FDCWD if none
+func (a pathArg) dirfdOf(state *syscallState) int {
+	if a.dirfd < 0 {
+		return atFdcwd
+	}
+	return int(int32(state.param(a.dirfd)))
+}
+
+// Path args of the syscall, from its signature
+// or from extraPathArgs when the tables don't have its args
+func syscallPathArgs(trace *Trace) (args []pathArg) {
+	if trace.Signature.Args == nil {
+		return extraPathArgs[trace.Personality][trace.Id]
+	}
+	for i, arg := range trace.Signature.Args {
+		if _, ok := arg.Type.(StringC); !ok || !pathArgs[arg.Name] {
+			continue
+		}
+		dirfd := -1
+		if i > 0 && isDirfdArg(trace.Signature.Args[i-1].Name) {
+			dirfd = i - 1
+		}
+		args = append(args, pathArg{i, dirfd})
+	}
+	return
+}
+
+// Path arg of a syscall
+type PathArg struct {
+	Path  string // As passed to the syscall, maybe relative
+	Dirfd int    // Directory fd of a relative path, AT_FDCWD (-100) if none
+	Err   error  // Error while reading the path
+}
+
+// Path args of the syscall, in the order of its args, including the
+// syscalls without args in the tables (like the *at syscalls of i386).
+// The paths are read in the memory of the stopped tracee: only from a
+// callback, not from a channel receiver. On a replay, they are read from
+// the decoded args, an error if truncated.
+func PathArgs(trace *Trace) []PathArg {
+	args := syscallPathArgs(trace)
+	if len(args) == 0 {
+		return nil
+	}
+	paths := make([]PathArg, len(args))
+	for i, arg := range args {
+		p := &paths[i]
+		p.Dirfd = atFdcwd
+		if trace.params == nil {
+			// Replayed trace
+			if trace.Signature.Args == nil || arg.index >= len(trace.Args) || arg.dirfd >= len(trace.Args) {
+				p.Err = fmt.Errorf("path arg not recorded")
+				continue
+			}
+			if arg.dirfd >= 0 {
+				p.Dirfd = int(int32(trace.Args[arg.dirfd].Raw))
+			}
+			var ok bool
+			if p.Path, ok = unescapeString(trace.Args[arg.index].Str); !ok {
+				p.Err = fmt.Errorf("path arg truncated")
+			}
+			continue
+		}
+		if arg.dirfd >= 0 {
+			p.Dirfd = int(int32(trace.params[arg.dirfd]))
+		}
+		p.Path, p.Err = peekStringC(trace.Pid, trace.params[arg.index], pathMax)
+	}
+	return paths
+}
+
+// Path args of the syscalls without args (or missing) in the generated tables
+var extraPathArgs = map[Personality]map[SyscallId][]pathArg{
+	PersonalityX86_64: {
+		316: {{1, 0}, {3, 2}}, // renameat2
+		322: {{1, 0}},         // execveat
+		332: {{1, 0}},         // statx
+		437: {{1, 0}},         // openat2
+		439: {{1, 0}},         // faccessat2
+		452: {{1, 0}},         // fchmodat2
+	},
+	PersonalityI386: {
+		193: {{0, -1}},          // truncate64
+		195: {{0, -1}},          // stat64
+		196: {{0, -1}},          // lstat64
+		198: {{0, -1}},          // lchown32
+		212: {{0, -1}},          // chown32
+		217: {{0, -1}, {1, -1}}, // pivot_root
+		226: {{0, -1}},          // setxattr
+		227: {{0, -1}},          // lsetxattr
+		229: {{0, -1}},          // getxattr
+		230: {{0, -1}},          // lgetxattr
+		232: {{0, -1}},          // listxattr
+		233: {{0, -1}},          // llistxattr
+		235: {{0, -1}},          // removexattr
+		236: {{0, -1}},          // lremovexattr
+		268: {{0, -1}},          // statfs64
+		271: {{0, -1}},          // utimes
+		295: {{1, 0}},           // openat
+		296: {{1, 0}},           // mkdirat
+		297: {{1, 0}},           // mknodat
+		298: {{1, 0}},           // fchownat
+		299: {{1, 0}},           // futimesat
+		300: {{1, 0}},           // fstatat64
+		301: {{1, 0}},           // unlinkat
+		302: {{1, 0}, {3, 2}},   // renameat
+		303: {{1, 0}, {3, 2}},   // linkat
+		304: {{0, -1}, {2, 1}},  // symlinkat
+		305: {{1, 0}},           // readlinkat
+		306: {{1, 0}},           // fchmodat
+		307: {{1, 0}},           // faccessat
+		320: {{1, 0}},           // utimensat
+		353: {{1, 0}, {3, 2}},   // renameat2
+		358: {{1, 0}},           // execveat
+		383: {{1, 0}},           // statx
+		437: {{1, 0}},           // openat2
+		439: {{1, 0}},           // faccessat2
+		452: {{1, 0}},           // fchmodat2
+	},
+}
+
+// Names of the string args which are paths,
+// the others are like the buffer of write
+var pathArgs = map[string]bool{
+	"filename":    true,
+	"pathname":    true,
+	"path":        true,
+	"oldname":     true,
+	"newname":     true,
+	"dev_name":    true,
+	"dir_name":    true,
+	"new_root":    true,
+	"put_old":     true,
+	"special":     true,
+	"specialfile": true,
+	"library":     true,
+	"target":      true,
+}
+
+// Names of the directory fd args preceding the path of the *at syscalls
+func isDirfdArg(name string) bool {
+	switch name {
+	case "dfd", "oldfd", "newfd":
+		return true
+	}
+	return false
+}
+
+// Absolute path of a path relative to the directory fd
+func resolvePath(pid, dirfd int, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	var dir string
+	if dirfd == atFdcwd {
+		dir, _ = os.Readlink(fmt.Sprintf("/proc/%d/cwd", pid))
+	} else {
+		dir, _ = os.Readlink(fmt.Sprintf("/proc/%d/fd/%d", pid, dirfd))
+	}
+	if dir == "" {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(dir, path)
+}
+
+// Executable of execve or execveat, made absolute
+func (m *matcher) execPath() (string, bool) {
+	if m.state == nil {
+		return "", false
+	}
+	dirfd, arg := atFdcwd, 0
+	switch m.trace.Name {
+	case "execve":
+	case "execveat":
+		// execveat(dirfd, pathname, argv, envp, flags)
+		dirfd, arg = int(int32(m.state.param(0))), 1
+	default:
+		return "", false
+	}
+	path, err := peekStringC(m.trace.Pid, m.state.param(arg), pathMax)
+	if err != nil {
+		return "", false
+	}
+	if path == "" {
+		// AT_EMPTY_PATH: the fd is the executable
+		target, _ := os.Readlink(fmt.Sprintf("/proc/%d/fd/%d", m.trace.Pid, dirfd))
+		return target, target != ""
+	}
+	return resolvePath(m.trace.Pid, dirfd, path), true
+}
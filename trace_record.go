@@ -0,0 +1,436 @@
+package libtrace
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Recorded trace file:
+//
+//	header:  "LTRC", format version (uint16 LE),
+//	         arch, kernel release, syscall table version (strings)
+//	records: record type (byte) then the fields of the record
+//
+// The strings and byte slices are prefixed by their size (uvarint),
+// the integers are varints (or uvarints when unsigned).
+const (
+	recordMagic         = "LTRC"
+	recordFormatVersion = 1
+
+	recordTrace = 1
+	recordEvent = 2
+)
+
+// Version of the generated syscall tables,
+// changed when the ids or the signatures change
+const SyscallTableVersion = 2
+
+// Kinds of ArgValue.Value
+const (
+	valueNil = iota
+	valueReg
+	valueUint64
+	valueString
+	valueBytes
+)
+
+// Header of a recorded trace file
+type RecordHeader struct {
+	Arch                string // GOARCH of the recorder
+	KernelRelease       string
+	SyscallTableVersion int
+}
+
+// Records the traces and the events to a trace file,
+// the traces are recorded with their args when registered on exit.
+//
+//	recorder, err := libtrace.NewRecorder(file)
+//	tracer.RegisterGlobalCb(recorder.Trace)
+//	tracer.RegisterEventCb(recorder.Event)
+//	err = tracer.Run()
+//	err = recorder.Flush()
+type Recorder struct {
+	lock sync.Mutex
+	w    *bufio.Writer
+	buf  bytes.Buffer // Record being encoded
+	err  error        // First write error
+}
+
+func NewRecorder(w io.Writer) (*Recorder, error) {
+	r := &Recorder{w: bufio.NewWriter(w)}
+	r.buf.WriteString(recordMagic)
+	binary.Write(&r.buf, binary.LittleEndian, uint16(recordFormatVersion))
+	r.putString(runtime.GOARCH)
+	r.putString(kernelRelease())
+	r.putUvarint(SyscallTableVersion)
+	if _, err := r.w.Write(r.buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Record the trace, can be registered as a callback
+func (r *Recorder) Trace(trace *Trace) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.buf.Reset()
+	r.buf.WriteByte(recordTrace)
+	var flags byte
+	if trace.Exit {
+		flags |= 1
+	}
+	r.buf.WriteByte(flags)
+	r.putVarint(trace.Time.UnixNano())
+	r.putVarint(int64(trace.Duration))
+	r.putVarint(int64(trace.Pid))
+	r.putUvarint(uint64(trace.Personality))
+	r.putUvarint(uint64(trace.Arch))
+	r.putUvarint(uint64(trace.Id))
+	r.putString(trace.Name)
+	r.putUvarint(trace.InstructionPointer)
+	r.putUvarint(trace.StackPointer)
+	r.putVarint(int64(trace.Return.Code))
+	r.putString(trace.Return.Description)
+
+	r.putUvarint(uint64(len(trace.Args)))
+	for i, arg := range trace.Args {
+		name := ""
+		if i < len(trace.Signature.Args) {
+			name = trace.Signature.Args[i].Name
+		}
+		r.putString(name)
+		r.putUvarint(arg.Raw)
+		r.putString(arg.Str)
+		errStr := ""
+		if arg.Err != nil {
+			errStr = arg.Err.Error()
+		}
+		r.putString(errStr)
+		switch v := arg.Value.(type) {
+		case regParam:
+			r.buf.WriteByte(valueReg)
+			r.putUvarint(uint64(v))
+		case uint64:
+			r.buf.WriteByte(valueUint64)
+			r.putUvarint(v)
+		case string:
+			r.buf.WriteByte(valueString)
+			r.putString(v)
+		case []byte:
+			r.buf.WriteByte(valueBytes)
+			r.putBytes(v)
+		default:
+			r.buf.WriteByte(valueNil)
+		}
+	}
+	r.write()
+}
+
+// Record the event, can be registered as an event callback
+func (r *Recorder) Event(event *Event) {
+	if event.Type == EventPolicy {
+		// Not in the format: the policy is not applied on a replay
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.buf.Reset()
+	r.buf.WriteByte(recordEvent)
+	r.putUvarint(uint64(event.Type))
+	r.putVarint(event.Time.UnixNano())
+	r.putVarint(int64(event.Pid))
+	r.putVarint(int64(event.ChildPid))
+	r.putVarint(int64(event.FormerPid))
+	r.putString(event.Path)
+	r.putVarint(int64(event.ExitCode))
+	r.putVarint(int64(event.Signal))
+	var flags byte
+	if event.CoreDump {
+		flags |= 1
+	}
+	r.buf.WriteByte(flags)
+	r.write()
+}
+
+// Flush the records to the writer, and return the first write error
+func (r *Recorder) Flush() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err := r.w.Flush(); err != nil && r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+func (r *Recorder) write() {
+	if r.err != nil {
+		return
+	}
+	_, r.err = r.w.Write(r.buf.Bytes())
+}
+
+func (r *Recorder) putUvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	r.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (r *Recorder) putVarint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	r.buf.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+func (r *Recorder) putString(s string) {
+	r.putUvarint(uint64(len(s)))
+	r.buf.WriteString(s)
+}
+
+func (r *Recorder) putBytes(b []byte) {
+	r.putUvarint(uint64(len(b)))
+	r.buf.Write(b)
+}
+
+func kernelRelease() string {
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err != nil {
+		return ""
+	}
+	var release []byte
+	for _, c := range uts.Release {
+		if c == 0 {
+			break
+		}
+		release = append(release, byte(c))
+	}
+	return string(release)
+}
+
+// Reader of a recorded trace file
+type recordReader struct {
+	r *bufio.Reader
+}
+
+var errRecordFormat = errors.New("invalid trace file")
+
+func (rr *recordReader) header() (h RecordHeader, err error) {
+	magic := make([]byte, len(recordMagic)+2)
+	if _, err = io.ReadFull(rr.r, magic); err != nil {
+		return
+	}
+	if string(magic[:len(recordMagic)]) != recordMagic {
+		return h, errRecordFormat
+	}
+	if v := binary.LittleEndian.Uint16(magic[len(recordMagic):]); v != recordFormatVersion {
+		return h, fmt.Errorf("unsupported trace file version: %d", v)
+	}
+	var version uint64
+	if h.Arch, err = rr.string(); err != nil {
+		return
+	}
+	if h.KernelRelease, err = rr.string(); err != nil {
+		return
+	}
+	if version, err = binary.ReadUvarint(rr.r); err != nil {
+		return
+	}
+	h.SyscallTableVersion = int(version)
+	return
+}
+
+// Read the next record: a trace or an event
+func (rr *recordReader) next() (trace *Trace, argNames []string, event *Event, err error) {
+	var kind byte
+	if kind, err = rr.r.ReadByte(); err != nil {
+		return
+	}
+	switch kind {
+	case recordTrace:
+		trace, argNames, err = rr.trace()
+	case recordEvent:
+		event, err = rr.event()
+	default:
+		err = errRecordFormat
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
+
+func (rr *recordReader) trace() (trace *Trace, argNames []string, err error) {
+	trace = &Trace{Signature: &Signature{}}
+	var flags byte
+	if flags, err = rr.r.ReadByte(); err != nil {
+		return
+	}
+	trace.Exit = flags&1 != 0
+	var v int64
+	var u uint64
+	if v, err = binary.ReadVarint(rr.r); err != nil {
+		return
+	}
+	trace.Time = time.Unix(0, v)
+	if v, err = binary.ReadVarint(rr.r); err != nil {
+		return
+	}
+	trace.Duration = time.Duration(v)
+	if v, err = binary.ReadVarint(rr.r); err != nil {
+		return
+	}
+	trace.Pid = int(v)
+	if u, err = binary.ReadUvarint(rr.r); err != nil {
+		return
+	}
+	trace.Personality = Personality(u)
+	if u, err = binary.ReadUvarint(rr.r); err != nil {
+		return
+	}
+	trace.Arch = uint32(u)
+	if u, err = binary.ReadUvarint(rr.r); err != nil {
+		return
+	}
+	trace.Signature.Id = SyscallId(u)
+	if trace.Signature.Name, err = rr.string(); err != nil {
+		return
+	}
+	if trace.InstructionPointer, err = binary.ReadUvarint(rr.r); err != nil {
+		return
+	}
+	if trace.StackPointer, err = binary.ReadUvarint(rr.r); err != nil {
+		return
+	}
+	if v, err = binary.ReadVarint(rr.r); err != nil {
+		return
+	}
+	trace.Return.Code = ReturnCode(v)
+	if trace.Return.Description, err = rr.string(); err != nil {
+		return
+	}
+
+	var n uint64
+	if n, err = binary.ReadUvarint(rr.r); err != nil {
+		return
+	}
+	if n > 64 {
+		return nil, nil, errRecordFormat
+	}
+	if n > 0 {
+		trace.Args = make([]ArgValue, n)
+		argNames = make([]string, n)
+	}
+	for i := range trace.Args {
+		arg := &trace.Args[i]
+		if argNames[i], err = rr.string(); err != nil {
+			return
+		}
+		if arg.Raw, err = binary.ReadUvarint(rr.r); err != nil {
+			return
+		}
+		if arg.Str, err = rr.string(); err != nil {
+			return
+		}
+		var errStr string
+		if errStr, err = rr.string(); err != nil {
+			return
+		}
+		if errStr != "" {
+			arg.Err = errors.New(errStr)
+		}
+		var kind byte
+		if kind, err = rr.r.ReadByte(); err != nil {
+			return
+		}
+		switch kind {
+		case valueNil:
+		case valueReg:
+			if u, err = binary.ReadUvarint(rr.r); err != nil {
+				return
+			}
+			arg.Value = regParam(u)
+		case valueUint64:
+			if u, err = binary.ReadUvarint(rr.r); err != nil {
+				return
+			}
+			arg.Value = u
+		case valueString:
+			if arg.Value, err = rr.string(); err != nil {
+				return
+			}
+		case valueBytes:
+			if arg.Value, err = rr.bytes(); err != nil {
+				return
+			}
+		default:
+			return nil, nil, errRecordFormat
+		}
+	}
+	return
+}
+
+func (rr *recordReader) event() (event *Event, err error) {
+	event = &Event{}
+	var v int64
+	var u uint64
+	if u, err = binary.ReadUvarint(rr.r); err != nil {
+		return
+	}
+	event.Type = EventType(u)
+	if v, err = binary.ReadVarint(rr.r); err != nil {
+		return
+	}
+	event.Time = time.Unix(0, v)
+	ints := []*int{&event.Pid, &event.ChildPid, &event.FormerPid}
+	for _, p := range ints {
+		if v, err = binary.ReadVarint(rr.r); err != nil {
+			return
+		}
+		*p = int(v)
+	}
+	if event.Path, err = rr.string(); err != nil {
+		return
+	}
+	if v, err = binary.ReadVarint(rr.r); err != nil {
+		return
+	}
+	event.ExitCode = int(v)
+	if v, err = binary.ReadVarint(rr.r); err != nil {
+		return
+	}
+	event.Signal = syscall.Signal(v)
+	var flags byte
+	if flags, err = rr.r.ReadByte(); err != nil {
+		return
+	}
+	event.CoreDump = flags&1 != 0
+	return
+}
+
+// Max size of a recorded string or byte slice
+const maxRecordString = 1 << 20
+
+func (rr *recordReader) bytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxRecordString {
+		return nil, errRecordFormat
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(rr.r, b)
+	return b, err
+}
+
+func (rr *recordReader) string() (string, error) {
+	b, err := rr.bytes()
+	return string(b), err
+}
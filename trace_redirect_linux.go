@@ -0,0 +1,81 @@
+package libtrace
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Bytes below the stack pointer the tracee may use without moving it
+// (x86_64 ABI), the redirected paths are written below
+const redZone = 128
+
+// Rewrite the path args matching a redirection at the enter stop:
+// the new paths are written on the tracee stack, below the red zone,
+// and the arg registers point to them until the exit stop.
+// A tracee running on a small stack (like a goroutine stack) may not
+// have room for the paths.
+func (t *tracerImpl) redirect(trace *Trace, state *syscallState) {
+	rules := t.registry().redirects
+	if len(rules) == 0 || state.abi.seccompSyscallId(state.id) != state.id {
+		// No path in the multiplexed syscalls
+		return
+	}
+
+	addr := state.sp - redZone
+	args := make(map[int]regParam)
+	for _, arg := range syscallPathArgs(trace) {
+		i := arg.index
+		path, err := peekStringC(trace.Pid, state.param(i), pathMax)
+		if err != nil || path == "" {
+			continue
+		}
+		dirfd := arg.dirfdOf(state)
+		newPath, ok := redirectPath(rules, resolvePath(trace.Pid, dirfd, path))
+		if !ok {
+			continue
+		}
+		addr = (addr - uint64(len(newPath)) - 1) &^ 15
+		if _, err := syscall.PtracePokeData(trace.Pid, uintptr(addr), append([]byte(newPath), 0)); err != nil {
+			t.logf("Redirect: can't write %s in %d: %s", newPath, trace.Pid, err)
+			continue
+		}
+		args[i] = regParam(addr)
+	}
+	if len(args) == 0 {
+		return
+	}
+	if err := setSyscallArgs(trace.Pid, trace.Personality, args); err != nil {
+		t.logf("Redirect: can't set the args of %s of %d: %s", trace.Name, trace.Pid, err)
+		return
+	}
+	state.redirected = make(map[int]regParam, len(args))
+	for i := range args {
+		state.redirected[i] = state.param(i)
+	}
+}
+
+// Restore the args registers changed by the redirection at the exit stop
+func (t *tracerImpl) restoreRedirected(trace *Trace, state *syscallState) {
+	if (trace.Name == "execve" || trace.Name == "execveat") && !trace.Return.Failed() {
+		// The registers are the ones of the new program
+		return
+	}
+	if err := setSyscallArgs(trace.Pid, trace.Personality, state.redirected); err != nil {
+		t.logf("Redirect: can't restore the args of %s of %d: %s", trace.Name, trace.Pid, err)
+	}
+}
+
+// Path redirected by the first matching rule
+func redirectPath(rules []entry, path string) (string, bool) {
+	for _, e := range rules {
+		from := filepath.Clean(e.redirect.From)
+		switch {
+		case path == from:
+			return e.redirect.To, true
+		case strings.HasPrefix(path, from+"/") || from == "/":
+			return filepath.Join(e.redirect.To, strings.TrimPrefix(path, from)), true
+		}
+	}
+	return "", false
+}
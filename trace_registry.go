@@ -0,0 +1,202 @@
+package libtrace
+
+// A registered callback, channel, delay or redirection
+type entry struct {
+	id       uint64
+	cb       TracerCb
+	out      chan<- *Trace
+	delivery *channelDelivery // Delivery state of out
+	delay    DelayRule
+	redirect RedirectRule
+	eventCb  EventCb
+	eventOut chan<- *Event
+}
+
+// Callbacks, channels, delays and redirections registered.
+// A registry is never modified once published: the changes are made
+// on a copy, so Run reads it without locking.
+type registry struct {
+	globalCallbacksOnEnter []entry
+	globalCallbacksOnExit  []entry
+	callbacksOnEnter       map[string][]entry
+	callbacksOnExit        map[string][]entry
+
+	globalChannelsOnEnter []entry
+	globalChannelsOnExit  []entry
+	channelsOnEnter       map[string][]entry
+	channelsOnExit        map[string][]entry
+
+	globalDelays []entry
+	delays       map[string][]entry
+
+	redirects []entry
+
+	eventCallbacks []entry
+	eventChannels  []entry
+}
+
+func newRegistry() *registry {
+	return &registry{
+		callbacksOnEnter: make(map[string][]entry),
+		callbacksOnExit:  make(map[string][]entry),
+		channelsOnEnter:  make(map[string][]entry),
+		channelsOnExit:   make(map[string][]entry),
+		delays:           make(map[string][]entry),
+	}
+}
+
+func (r *registry) lists() []*[]entry {
+	return []*[]entry{
+		&r.globalCallbacksOnEnter, &r.globalCallbacksOnExit,
+		&r.globalChannelsOnEnter, &r.globalChannelsOnExit,
+		&r.globalDelays,
+		&r.redirects,
+		&r.eventCallbacks,
+		&r.eventChannels,
+	}
+}
+
+func (r *registry) maps() []*map[string][]entry {
+	return []*map[string][]entry{
+		&r.callbacksOnEnter, &r.callbacksOnExit,
+		&r.channelsOnEnter, &r.channelsOnExit,
+		&r.delays,
+	}
+}
+
+func (r *registry) clone() *registry {
+	c := *r
+	for _, l := range c.lists() {
+		*l = append([]entry(nil), *l...)
+	}
+	for _, m := range c.maps() {
+		cm := make(map[string][]entry, len(*m))
+		for name, l := range *m {
+			cm[name] = append([]entry(nil), l...)
+		}
+		*m = cm
+	}
+	return &c
+}
+
+// Remove the entries of the ids (the registry must be a copy)
+func (r *registry) remove(ids []uint64) {
+	keep := func(l []entry) []entry {
+		kept := l[:0]
+		for _, e := range l {
+			removed := false
+			for _, id := range ids {
+				if e.id == id {
+					removed = true
+					break
+				}
+			}
+			if !removed {
+				kept = append(kept, e)
+			}
+		}
+		return kept
+	}
+	for _, l := range r.lists() {
+		*l = keep(*l)
+	}
+	for _, m := range r.maps() {
+		for name, l := range *m {
+			if l = keep(l); len(l) > 0 {
+				(*m)[name] = l
+			} else {
+				delete(*m, name)
+			}
+		}
+	}
+}
+
+// Channels having a registration
+func (r *registry) channels() map[chan<- *Trace]bool {
+	channels := make(map[chan<- *Trace]bool)
+	for _, l := range r.lists() {
+		for _, e := range *l {
+			if e.out != nil {
+				channels[e.out] = true
+			}
+		}
+	}
+	for _, m := range r.maps() {
+		for _, l := range *m {
+			for _, e := range l {
+				if e.out != nil {
+					channels[e.out] = true
+				}
+			}
+		}
+	}
+	return channels
+}
+
+// Names having a registration
+func (r *registry) names() map[string]bool {
+	names := make(map[string]bool)
+	for _, m := range r.maps() {
+		for name := range *m {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// Current registry
+func (t *tracerImpl) registry() *registry {
+	return t.reg.Load().(*registry)
+}
+
+// Register a new entry by changing a copy of the registry
+func (t *tracerImpl) register(e entry, add func(r *registry, e entry)) Handle {
+	t.registryLock.Lock()
+	defer t.registryLock.Unlock()
+	t.lastEntryId++
+	e.id = t.lastEntryId
+	if e.out != nil {
+		e.delivery = t.delivery(e.out)
+	}
+	r := t.registry().clone()
+	add(r, e)
+	t.reg.Store(r)
+	return &registration{t: t, ids: []uint64{e.id}}
+}
+
+type registration struct {
+	t   *tracerImpl
+	ids []uint64
+}
+
+func (h *registration) Remove() {
+	h.t.registryLock.Lock()
+	defer h.t.registryLock.Unlock()
+	old := h.t.registry()
+	r := old.clone()
+	r.remove(h.ids)
+	h.t.reg.Store(r)
+
+	kept := r.channels()
+	for out := range old.channels() {
+		if !kept[out] {
+			h.t.removeDelivery(out)
+		}
+	}
+}
+
+// Handle of the shortcuts registering on enter and exit
+type handles []Handle
+
+func (hs handles) Remove() {
+	for _, h := range hs {
+		h.Remove()
+	}
+}
+
+// Add the entry to the named lists of the map
+func addNamed(m map[string][]entry, e entry, fnNames []string) {
+	for _, name := range fnNames {
+		m[name] = append(m[name], e)
+	}
+}
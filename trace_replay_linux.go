@@ -0,0 +1,91 @@
+package libtrace
+
+import (
+	"bufio"
+	"io"
+)
+
+// Tracer replaying a recorded trace file
+type replayer struct {
+	*tracerImpl
+	r io.Reader
+
+	// Signatures rebuilt from the recorded names
+	signatures map[replayedSyscall]*Signature
+}
+
+type replayedSyscall struct {
+	arch uint32
+	id   SyscallId
+	name string
+}
+
+// Tracer replaying a trace file recorded by a Recorder:
+// the traces and the events are dispatched to the callbacks and
+// channels as during the recording Run, through the filter.
+// The delays, seccomp and follow forks settings have no effect.
+func NewReplayer(r io.Reader) Tracer {
+	return &replayer{
+		tracerImpl: NewTracer(nil).(*tracerImpl),
+		r:          r,
+		signatures: make(map[replayedSyscall]*Signature),
+	}
+}
+
+func (p *replayer) Run() error {
+	defer p.closeDeliveries()
+
+	rr := recordReader{r: bufio.NewReader(p.r)}
+	header, err := rr.header()
+	if err != nil {
+		return err
+	}
+	if header.SyscallTableVersion != SyscallTableVersion {
+		p.logf("Trace recorded with the syscall table version %d (current %d), the signatures of the syscalls are rebuilt",
+			header.SyscallTableVersion, SyscallTableVersion)
+	}
+
+	for {
+		trace, argNames, event, err := rr.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if event != nil {
+			p.dispatchEvent(event)
+			continue
+		}
+		trace.Signature = p.signature(trace, argNames)
+		p.dispatch(&matcher{t: p.tracerImpl, trace: trace})
+	}
+}
+
+// Signature of the current table if it matches the recorded syscall,
+// rebuilt from the recorded names otherwise
+func (p *replayer) signature(trace *Trace, argNames []string) *Signature {
+	for _, a := range abis {
+		if a.arch != trace.Arch {
+			continue
+		}
+		if sig := a.signature(trace.Id); sig.Name == trace.Name && (!trace.Exit || sig.Args == nil || len(sig.Args) == len(argNames)) {
+			return sig
+		}
+	}
+
+	key := replayedSyscall{trace.Arch, trace.Id, trace.Name}
+	sig, ok := p.signatures[key]
+	if !ok {
+		sig = &Signature{Id: trace.Id, Name: trace.Name}
+		p.signatures[key] = sig
+	}
+	if sig.Args == nil && len(argNames) > 0 {
+		// Known once an exit trace is replayed
+		sig.Args = make([]Arg, len(argNames))
+		for i, name := range argNames {
+			sig.Args[i] = Arg{Name: name, Type: type_unknownstruct}
+		}
+	}
+	return sig
+}
@@ -0,0 +1,177 @@
+package libtrace
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+)
+
+// The seccomp filter can't be installed by the tracer in the child
+// between fork and exec, and the filter of the tracer must not be
+// inherited by the other children. So it is installed after the exec,
+// before the first instruction of the command: the prctl syscalls are
+// injected in the command (see installSeccomp), no code of the tracer
+// runs in the child.
+
+const (
+	_PTRACE_O_TRACESECCOMP = 0x80
+	_PTRACE_EVENT_SECCOMP  = 7
+
+	_PR_SET_NO_NEW_PRIVS = 38
+	_SECCOMP_MODE_FILTER = 2
+
+	_SECCOMP_RET_TRACE = 0x7ff00000
+	_SECCOMP_RET_ALLOW = 0x7fff0000
+
+	_BPF_LD_W_ABS  = 0x20 // BPF_LD | BPF_W | BPF_ABS
+	_BPF_JEQ_K     = 0x15 // BPF_JMP | BPF_JEQ | BPF_K
+	_BPF_RET_K     = 0x06 // BPF_RET | BPF_K
+	_SECCOMP_NR    = 0    // offsetof(struct seccomp_data, nr)
+	_SECCOMP_ARCH  = 4    // offsetof(struct seccomp_data, arch)
+	maxSeccompJump = 255  // BPF jump offsets are 8 bits
+)
+
+// Syscalls to trace for an arch
+type seccompRule struct {
+	arch uint32 // AUDIT_ARCH_*
+	ids  []SyscallId
+}
+
+// Install the seccomp filter in the command, stopped after its exec.
+// The struct sock_fprog and the filter are written below its stack,
+// then the prctl syscalls are injected at its instruction pointer.
+func installSeccomp(pid int, rules []seccompRule) error {
+	sp, personality, err := taskStack(pid)
+	if err != nil {
+		return err
+	}
+	word := uint64(timeWord(personality))
+
+	// struct sock_fprog { unsigned short len; struct sock_filter *filter; }
+	filter := seccompFilter(rules)
+	buf := make([]byte, 2*word+8*uint64(len(filter)))
+	addr := (sp - redZone - uint64(len(buf))) &^ 15
+	binary.LittleEndian.PutUint16(buf, uint16(len(filter)))
+	if word == 4 {
+		binary.LittleEndian.PutUint32(buf[word:], uint32(addr+2*word))
+	} else {
+		binary.LittleEndian.PutUint64(buf[word:], addr+2*word)
+	}
+	for i, f := range filter {
+		b := buf[2*word+8*uint64(i):]
+		binary.LittleEndian.PutUint16(b, f.Code)
+		b[2], b[3] = f.Jt, f.Jf
+		binary.LittleEndian.PutUint32(b[4:], f.K)
+	}
+	if _, err := syscall.PtracePokeData(pid, uintptr(addr), buf); err != nil {
+		return err
+	}
+
+	for _, args := range [][]regParam{
+		{_PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0},
+		{syscall.PR_SET_SECCOMP, _SECCOMP_MODE_FILTER, regParam(addr)},
+	} {
+		ret, err := injectSyscall(pid, prctlIds[personality], args...)
+		if err != nil {
+			return err
+		}
+		if ret < 0 {
+			return fmt.Errorf("prctl(%d): %s", args[0], syscall.Errno(-ret))
+		}
+	}
+	return nil
+}
+
+// Execute the instruction insn at ip, instead of the code of the
+// stopped task, then restore its code. The registers are not restored.
+func stepInstruction(pid int, ip uint64, insn []byte) error {
+	code := make([]byte, len(insn))
+	if _, err := syscall.PtracePeekText(pid, uintptr(ip), code); err != nil {
+		return err
+	}
+	if _, err := syscall.PtracePokeText(pid, uintptr(ip), insn); err != nil {
+		return err
+	}
+	err := syscall.PtraceSingleStep(pid)
+	if err == nil {
+		var waitStatus syscall.WaitStatus
+		if _, err = syscall.Wait4(pid, &waitStatus, syscall.WALL, nil); err == nil &&
+			(!waitStatus.Stopped() || waitStatus.StopSignal() != syscall.SIGTRAP) {
+			err = fmt.Errorf("unexpected status %#x after a single step", waitStatus)
+		}
+	}
+	if _, pokeErr := syscall.PtracePokeText(pid, uintptr(ip), code); err == nil {
+		err = pokeErr
+	}
+	return err
+}
+
+// Instructions of the injected syscalls
+var (
+	insnSyscall = []byte{0x0f, 0x05} // syscall
+	insnInt80   = []byte{0xcd, 0x80} // int $0x80
+)
+
+// Id of prctl, by personality
+var prctlIds = map[Personality]SyscallId{
+	PersonalityX86_64: 157,
+	PersonalityI386:   172,
+}
+
+// BPF program returning SECCOMP_RET_TRACE for the syscalls of the rules.
+// All the syscalls of an arch without rule are traced.
+func seccompFilter(rules []seccompRule) []syscall.SockFilter {
+	filter := []syscall.SockFilter{
+		{Code: _BPF_LD_W_ABS, K: _SECCOMP_ARCH},
+	}
+	for _, rule := range rules {
+		n := len(rule.ids)
+		// Skip the block of the arch if not matching
+		filter = append(filter,
+			syscall.SockFilter{Code: _BPF_JEQ_K, Jf: uint8(n + 3), K: rule.arch},
+			syscall.SockFilter{Code: _BPF_LD_W_ABS, K: _SECCOMP_NR},
+		)
+		for i, id := range rule.ids {
+			// Jump to the RET_TRACE of the block
+			filter = append(filter, syscall.SockFilter{Code: _BPF_JEQ_K, Jt: uint8(n - i), K: uint32(id)})
+		}
+		filter = append(filter,
+			syscall.SockFilter{Code: _BPF_RET_K, K: _SECCOMP_RET_ALLOW},
+			syscall.SockFilter{Code: _BPF_RET_K, K: _SECCOMP_RET_TRACE},
+		)
+	}
+	return append(filter, syscall.SockFilter{Code: _BPF_RET_K, K: _SECCOMP_RET_TRACE})
+}
+
+// Syscalls which need to be traced for each supported arch.
+// Returns false when all the syscalls need to be traced.
+func (t *tracerImpl) seccompRules() (rules []seccompRule, ok bool) {
+	r := t.registry()
+	if len(r.globalCallbacksOnEnter) > 0 || len(r.globalCallbacksOnExit) > 0 ||
+		len(r.globalChannelsOnEnter) > 0 || len(r.globalChannelsOnExit) > 0 ||
+		len(r.globalDelays) > 0 || len(r.redirects) > 0 || t.currentPolicy() != nil || t.clock != nil {
+		return nil, false
+	}
+
+	names := r.names()
+
+	for _, a := range abis {
+		rule := seccompRule{arch: a.arch}
+		seen := make(map[SyscallId]bool)
+		for _, sig := range a.signatures() {
+			if sig == &unknownSignature || !names[sig.Name] {
+				continue
+			}
+			id := a.seccompSyscallId(sig.Id)
+			if !seen[id] {
+				seen[id] = true
+				rule.ids = append(rule.ids, id)
+			}
+		}
+		if len(rule.ids)+3 > maxSeccompJump {
+			return nil, false
+		}
+		rules = append(rules, rule)
+	}
+	return rules, true
+}
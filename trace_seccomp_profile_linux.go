@@ -0,0 +1,381 @@
+package libtrace
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Generates a seccomp profile allowing the observed syscalls,
+// in the Docker/OCI JSON format or as a compiled BPF program.
+//
+//	profile := libtrace.NewSeccompProfile(libtrace.SeccompProfileOptions{SocketFamilies: true})
+//	tracer.RegisterGlobalCb(profile.Trace)
+//	err = tracer.Run()
+//	err = profile.WriteJSON(file)
+//
+// The syscalls are seen on enter (exit_group never returns) and their
+// args on exit. The JSON profile allows the names observed on any arch,
+// as libseccomp applies the names to all the archs of the profile: the
+// BPF program allows only the syscalls observed on each arch.
+// The socketcall and ipc subcalls of i386 are allowed through their
+// multiplexer in the BPF program, without arg constraints.
+type SeccompProfile struct {
+	lock  sync.Mutex
+	opts  SeccompProfileOptions
+	archs map[Personality]*seccompProfileArch
+}
+
+type SeccompProfileOptions struct {
+	SocketFamilies bool          // Allow socket only for the observed families
+	CloneFlags     bool          // Forbid the namespace flags of clone if never observed
+	DefaultErrno   syscall.Errno // Returned by the other syscalls, EPERM if 0
+}
+
+type seccompProfileArch struct {
+	abi       *abi
+	names     map[string]bool
+	nrs       map[SyscallId]string // Syscall numbers of the filter, with the name if not multiplexed
+	unnamed   map[SyscallId]bool   // Ids without a name, only in the BPF program
+	families  map[uint64]bool      // Observed socket families
+	socketAny bool                 // socket observed without its args
+	clone     bool                 // clone observed with its flags
+	cloneNs   bool                 // clone observed with namespace flags
+}
+
+const (
+	_SECCOMP_RET_ERRNO        = 0x00050000
+	_SECCOMP_RET_KILL_PROCESS = 0x80000000
+
+	_BPF_JA       = 0x05 // BPF_JMP | BPF_JA
+	_BPF_JGE_K    = 0x35 // BPF_JMP | BPF_JGE | BPF_K
+	_BPF_JSET_K   = 0x45 // BPF_JMP | BPF_JSET | BPF_K
+	_SECCOMP_ARG  = 16   // offsetof(struct seccomp_data, args), low word on little endian
+	_BPF_MAXINSNS = 4096
+
+	_X32_SYSCALL_BIT = 0x40000000
+
+	// CLONE_NEWNS | CLONE_NEWCGROUP | CLONE_NEWUTS | CLONE_NEWIPC |
+	// CLONE_NEWUSER | CLONE_NEWPID | CLONE_NEWNET
+	cloneNamespaceFlags = 0x7e020000
+)
+
+var seccompArchNames = map[Personality]string{
+	PersonalityX86_64: "SCMP_ARCH_X86_64",
+	PersonalityI386:   "SCMP_ARCH_X86",
+}
+
+func NewSeccompProfile(opts SeccompProfileOptions) *SeccompProfile {
+	if opts.DefaultErrno == 0 {
+		opts.DefaultErrno = syscall.EPERM
+	}
+	return &SeccompProfile{
+		opts:  opts,
+		archs: make(map[Personality]*seccompProfileArch),
+	}
+}
+
+// Record the syscall, to register on enter and on exit
+func (p *SeccompProfile) Trace(trace *Trace) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	a := p.arch(trace.Personality)
+	if a == nil {
+		return
+	}
+	name := seccompSyscallName(trace)
+	nr := a.abi.seccompSyscallId(trace.Id)
+	if nr != trace.Id {
+		a.nrs[nr] = ""
+	} else if _, ok := a.nrs[nr]; !ok {
+		a.nrs[nr] = name
+	}
+	if name == "" {
+		a.unnamed[trace.Id] = true
+		return
+	}
+	a.names[name] = true
+
+	if !trace.Exit {
+		return
+	}
+	switch {
+	case name == "socket" && trace.Args == nil:
+		a.socketAny = true
+	case name == "socket":
+		a.families[trace.Args[0].Raw] = true
+	case name == "clone" && trace.Args != nil:
+		a.clone = true
+		if trace.Args[0].Raw&cloneNamespaceFlags != 0 {
+			a.cloneNs = true
+		}
+	}
+}
+
+// Names of the syscalls observed on the arch, sorted
+func (p *SeccompProfile) Syscalls(personality Personality) []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	a := p.archs[personality]
+	if a == nil {
+		return nil
+	}
+	return sortedNames(a.names)
+}
+
+// Ids of the syscalls observed on the arch without a known name,
+// missing in the JSON profile
+func (p *SeccompProfile) Unnamed(personality Personality) []SyscallId {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	a := p.archs[personality]
+	if a == nil {
+		return nil
+	}
+	var ids []SyscallId
+	for id := range a.unnamed {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Docker seccomp profile, also valid as the linux.seccomp of an OCI runtime config
+type seccompProfileJSON struct {
+	DefaultAction   string               `json:"defaultAction"`
+	DefaultErrnoRet uint                 `json:"defaultErrnoRet"`
+	Architectures   []string             `json:"architectures"`
+	Syscalls        []seccompSyscallJSON `json:"syscalls"`
+}
+
+type seccompSyscallJSON struct {
+	Names  []string         `json:"names"`
+	Action string           `json:"action"`
+	Args   []seccompArgJSON `json:"args,omitempty"`
+}
+
+type seccompArgJSON struct {
+	Index    uint   `json:"index"`
+	Value    uint64 `json:"value"`
+	ValueTwo uint64 `json:"valueTwo"`
+	Op       string `json:"op"`
+}
+
+// Write the profile in the Docker/OCI JSON format
+func (p *SeccompProfile) WriteJSON(w io.Writer) error {
+	p.lock.Lock()
+	profile := seccompProfileJSON{
+		DefaultAction:   "SCMP_ACT_ERRNO",
+		DefaultErrnoRet: uint(p.opts.DefaultErrno),
+		Architectures:   []string{},
+		Syscalls:        []seccompSyscallJSON{},
+	}
+	names := make(map[string]bool)
+	families := make(map[uint64]bool)
+	socketAny, clone, cloneNs := false, false, false
+	for _, personality := range p.personalities() {
+		a := p.archs[personality]
+		profile.Architectures = append(profile.Architectures, seccompArchNames[personality])
+		for name := range a.names {
+			names[name] = true
+		}
+		for family := range a.families {
+			families[family] = true
+		}
+		socketAny = socketAny || a.socketAny
+		clone = clone || a.clone
+		cloneNs = cloneNs || a.cloneNs
+	}
+	p.lock.Unlock()
+
+	var constrained []seccompSyscallJSON
+	if p.opts.SocketFamilies && names["socket"] && len(families) > 0 && !socketAny {
+		delete(names, "socket")
+		for _, family := range sortedUint64s(families) {
+			constrained = append(constrained, seccompSyscallJSON{
+				Names:  []string{"socket"},
+				Action: "SCMP_ACT_ALLOW",
+				Args:   []seccompArgJSON{{Index: 0, Value: family, Op: "SCMP_CMP_EQ"}},
+			})
+		}
+	}
+	if p.opts.CloneFlags && names["clone"] && clone && !cloneNs {
+		delete(names, "clone")
+		constrained = append(constrained, seccompSyscallJSON{
+			Names:  []string{"clone"},
+			Action: "SCMP_ACT_ALLOW",
+			Args:   []seccompArgJSON{{Index: 0, Value: cloneNamespaceFlags, ValueTwo: 0, Op: "SCMP_CMP_MASKED_EQ"}},
+		})
+	}
+	if len(names) > 0 {
+		profile.Syscalls = append(profile.Syscalls, seccompSyscallJSON{
+			Names:  sortedNames(names),
+			Action: "SCMP_ACT_ALLOW",
+		})
+	}
+	profile.Syscalls = append(profile.Syscalls, constrained...)
+
+	data, err := json.MarshalIndent(profile, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// Compiled BPF program of the profile, for seccomp(SECCOMP_SET_MODE_FILTER).
+// The syscalls of the other archs kill the process.
+func (p *SeccompProfile) BPF() ([]syscall.SockFilter, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	deny := syscall.SockFilter{Code: _BPF_RET_K, K: _SECCOMP_RET_ERRNO | uint32(p.opts.DefaultErrno)&0xffff}
+	allow := syscall.SockFilter{Code: _BPF_RET_K, K: _SECCOMP_RET_ALLOW}
+
+	filter := []syscall.SockFilter{{Code: _BPF_LD_W_ABS, K: _SECCOMP_ARCH}}
+	for _, personality := range p.personalities() {
+		a := p.archs[personality]
+		block := []syscall.SockFilter{{Code: _BPF_LD_W_ABS, K: _SECCOMP_NR}}
+		if personality == PersonalityX86_64 {
+			// x32 syscalls
+			block = append(block, syscall.SockFilter{Code: _BPF_JGE_K, Jf: 1, K: _X32_SYSCALL_BIT}, deny)
+		}
+		nrs := make([]SyscallId, 0, len(a.nrs))
+		for nr := range a.nrs {
+			nrs = append(nrs, nr)
+		}
+		sort.Slice(nrs, func(i, j int) bool { return nrs[i] < nrs[j] })
+		for _, nr := range nrs {
+			var check []syscall.SockFilter
+			switch {
+			case a.nrs[nr] == "socket" && p.opts.SocketFamilies && len(a.families) > 0 && !a.socketAny:
+				families := sortedUint64s(a.families)
+				check = append(check, syscall.SockFilter{Code: _BPF_LD_W_ABS, K: _SECCOMP_ARG})
+				for i, family := range families {
+					check = append(check, syscall.SockFilter{Code: _BPF_JEQ_K, Jt: uint8(len(families) - i), K: uint32(family)})
+				}
+				check = append(check, deny, allow)
+			case a.nrs[nr] == "clone" && p.opts.CloneFlags && a.clone && !a.cloneNs:
+				check = []syscall.SockFilter{
+					{Code: _BPF_LD_W_ABS, K: _SECCOMP_ARG},
+					{Code: _BPF_JSET_K, Jf: 1, K: cloneNamespaceFlags},
+					deny,
+					allow,
+				}
+			default:
+				check = []syscall.SockFilter{allow}
+			}
+			if len(check) > maxSeccompJump {
+				return nil, fmt.Errorf("seccomp profile: too many constraints for %s", a.nrs[nr])
+			}
+			block = append(block, syscall.SockFilter{Code: _BPF_JEQ_K, Jf: uint8(len(check)), K: uint32(nr)})
+			block = append(block, check...)
+		}
+		block = append(block, deny)
+		// Skip the block of the arch if not matching
+		filter = append(filter,
+			syscall.SockFilter{Code: _BPF_JEQ_K, Jt: 1, K: a.abi.arch},
+			syscall.SockFilter{Code: _BPF_JA, K: uint32(len(block))},
+		)
+		filter = append(filter, block...)
+	}
+	filter = append(filter, syscall.SockFilter{Code: _BPF_RET_K, K: _SECCOMP_RET_KILL_PROCESS})
+	if len(filter) > _BPF_MAXINSNS {
+		return nil, fmt.Errorf("seccomp profile: program too long (%d instructions)", len(filter))
+	}
+	return filter, nil
+}
+
+// Write the BPF program as an array of struct sock_filter,
+// like the compiled filters read by bwrap --seccomp
+func (p *SeccompProfile) WriteBPF(w io.Writer) error {
+	filter, err := p.BPF()
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 8*len(filter))
+	for i, insn := range filter {
+		binary.LittleEndian.PutUint16(buf[8*i:], insn.Code)
+		buf[8*i+2] = insn.Jt
+		buf[8*i+3] = insn.Jf
+		binary.LittleEndian.PutUint32(buf[8*i+4:], insn.K)
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
+func (p *SeccompProfile) arch(personality Personality) *seccompProfileArch {
+	if a, ok := p.archs[personality]; ok {
+		return a
+	}
+	for _, abi := range abis {
+		if abi.personality == personality {
+			a := &seccompProfileArch{
+				abi:      abi,
+				names:    make(map[string]bool),
+				nrs:      make(map[SyscallId]string),
+				unnamed:  make(map[SyscallId]bool),
+				families: make(map[uint64]bool),
+			}
+			// The exec of the traced command is before the tracing
+			for _, sig := range abi.syscalls {
+				if sig.Name == "execve" {
+					a.names[sig.Name] = true
+					a.nrs[sig.Id] = sig.Name
+				}
+			}
+			p.archs[personality] = a
+			return a
+		}
+	}
+	return nil
+}
+
+// Observed personalities, in the order of the abis
+func (p *SeccompProfile) personalities() []Personality {
+	var personalities []Personality
+	for _, abi := range abis {
+		if _, ok := p.archs[abi.personality]; ok {
+			personalities = append(personalities, abi.personality)
+		}
+	}
+	return personalities
+}
+
+// Name of the syscall for libseccomp, empty if unknown
+func seccompSyscallName(trace *Trace) string {
+	switch {
+	case strings.HasPrefix(trace.Name, "*UNKNOWN("):
+		return ""
+	case trace.Name == "socket_subcall":
+		// Invalid subcall
+		return "socketcall"
+	case trace.Name == "ipc_subcall":
+		return "ipc"
+	}
+	return trace.Name
+}
+
+func sortedNames(names map[string]bool) []string {
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func sortedUint64s(values map[uint64]bool) []uint64 {
+	sorted := make([]uint64, 0, len(values))
+	for v := range values {
+		sorted = append(sorted, v)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
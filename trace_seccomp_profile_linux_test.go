@@ -0,0 +1,26 @@
+package libtrace
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSeccompProfileI386Ids(t *testing.T) {
+	p := NewSeccompProfile(SeccompProfileOptions{})
+	for _, id := range []SyscallId{403, 436, 1003, 362} {
+		p.Trace(&Trace{Signature: abiI386.signature(id), Personality: PersonalityI386})
+	}
+	want := []string{"clock_gettime64", "close_range", "connect", "execve"}
+	if names := p.Syscalls(PersonalityI386); !reflect.DeepEqual(names, want) {
+		t.Errorf("Syscalls = %v, want %v", names, want)
+	}
+	a := p.archs[PersonalityI386]
+	for nr, name := range map[SyscallId]string{403: "clock_gettime64", 436: "close_range", 362: "connect", 102: ""} {
+		if got, ok := a.nrs[nr]; !ok || got != name {
+			t.Errorf("nr %d: %q (%v), want %q", nr, got, ok, name)
+		}
+	}
+	if len(a.nrs) != 5 {
+		t.Errorf("nrs = %v, want 4 syscalls and execve", a.nrs)
+	}
+}
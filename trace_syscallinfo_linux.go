@@ -0,0 +1,184 @@
+package libtrace
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	_PTRACE_GETSIGINFO       = 0x4202
+	_PTRACE_GETREGSET        = 0x4204
+	_PTRACE_SETREGSET        = 0x4205
+	_PTRACE_GET_SYSCALL_INFO = 0x420e
+
+	_PTRACE_SYSCALL_INFO_NONE    = 0
+	_PTRACE_SYSCALL_INFO_ENTRY   = 1
+	_PTRACE_SYSCALL_INFO_EXIT    = 2
+	_PTRACE_SYSCALL_INFO_SECCOMP = 3
+)
+
+// struct ptrace_syscall_info (Linux >= 5.3)
+type syscallInfo struct {
+	Op                 uint8
+	_                  [3]uint8
+	Arch               uint32
+	InstructionPointer uint64
+	StackPointer       uint64
+	// entry/seccomp: nr, args[6] (seccomp: + ret_data)
+	// exit: rval, is_error
+	Data [8]uint64
+}
+
+func getSyscallInfo(pid int, info *syscallInfo) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, _PTRACE_GET_SYSCALL_INFO,
+		uintptr(pid), unsafe.Sizeof(*info), uintptr(unsafe.Pointer(info)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+const _NT_PRSTATUS = 1
+
+func getRegSet(pid int, nt int, iov *syscall.Iovec) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, _PTRACE_GETREGSET,
+		uintptr(pid), uintptr(nt), uintptr(unsafe.Pointer(iov)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func setRegSet(pid int, nt int, iov *syscall.Iovec) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, _PTRACE_SETREGSET,
+		uintptr(pid), uintptr(nt), uintptr(unsafe.Pointer(iov)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// True if the signal stop of the task is a group stop
+// (the signal has already been delivered)
+func isGroupStop(pid int) bool {
+	var siginfo [128]byte
+	_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, _PTRACE_GETSIGINFO,
+		uintptr(pid), 0, uintptr(unsafe.Pointer(&siginfo[0])), 0, 0)
+	return errno == syscall.EINVAL
+}
+
+// State of a syscall at an enter or exit stop
+type syscallState struct {
+	abi     *abi
+	id      SyscallId
+	args    [6]regParam
+	argsErr error // The args of a multiplexed syscall could not be read
+	ret     ReturnCode
+	exit    bool
+	arch    uint32
+	ip      uint64
+	sp      uint64
+	time    time.Time // Time of the stop
+	start   time.Time // Time of the enter stop
+
+	abiUnknown bool // Read from the registers, the ABI of the syscall may be wrong
+
+	policy    PolicyAction  // Action of the policy applied at the enter stop
+	denyErrno syscall.Errno // Error of the denied syscall
+
+	redirected   map[int]regParam // Original values of the args redirected at the enter stop
+	sleepSkipped bool             // Sleep skipped by a frozen clock
+}
+
+// Get the state of the syscall the task is stopped in,
+// using PTRACE_GET_SYSCALL_INFO when the kernel supports it
+// or the registers and the enter/exit alternation otherwise
+func (t *tracerImpl) getSyscallState(tsk *task, seccompStop bool) (state *syscallState, err error) {
+	state = &syscallState{exit: tsk.inSyscall && !seccompStop, time: time.Now()}
+
+	fromInfo := false
+	if !t.noSyscallInfo {
+		var info syscallInfo
+		if err = getSyscallInfo(tsk.pid, &info); err == nil {
+			switch info.Op {
+			case _PTRACE_SYSCALL_INFO_ENTRY, _PTRACE_SYSCALL_INFO_SECCOMP:
+				state.exit = false
+				state.setEntry(tsk.pid, &info)
+				fromInfo = true
+			case _PTRACE_SYSCALL_INFO_EXIT:
+				state.exit = true
+				state.ret = ReturnCode(int64(info.Data[0]))
+				if tsk.entry != nil && tsk.entry.arch == info.Arch {
+					state.abi = tsk.entry.abi
+					state.id, state.args, state.argsErr = tsk.entry.id, tsk.entry.args, tsk.entry.argsErr
+					fromInfo = true
+				}
+			}
+			state.arch = info.Arch
+			state.ip = info.InstructionPointer
+			state.sp = info.StackPointer
+		} else if err == syscall.EIO || err == syscall.EINVAL {
+			// Not supported by the kernel
+			t.noSyscallInfo = true
+			t.logf("PTRACE_GET_SYSCALL_INFO not supported (%s), using the registers", err)
+		} else {
+			return
+		}
+		err = nil
+	}
+
+	if !fromInfo {
+		if err = state.readRegs(tsk.pid); err != nil {
+			return
+		}
+		if state.exit && tsk.entry != nil && tsk.entry.abi == state.abi && tsk.entry.id == state.id {
+			// The args registers may have been clobbered by the syscall
+			state.args, state.argsErr = tsk.entry.args, tsk.entry.argsErr
+		} else if state.exit && tsk.entry != nil && (tsk.entry.policy == PolicyDeny || tsk.entry.policy == PolicyKill || tsk.entry.sleepSkipped) {
+			// Skipped by the policy or the clock: the syscall number is -1
+			state.abi, state.id = tsk.entry.abi, tsk.entry.id
+			state.args, state.argsErr = tsk.entry.args, tsk.entry.argsErr
+		}
+	}
+
+	tsk.inSyscall = !state.exit
+	if state.exit {
+		if tsk.entry != nil {
+			state.start = tsk.entry.time
+			state.policy, state.denyErrno = tsk.entry.policy, tsk.entry.denyErrno
+			state.redirected = tsk.entry.redirected
+			state.sleepSkipped = tsk.entry.sleepSkipped
+		}
+		tsk.entry = nil
+	} else {
+		state.start = state.time
+		tsk.entry = state
+	}
+	return
+}
+
+func (s *syscallState) setEntry(pid int, info *syscallInfo) {
+	s.abi = abiOfArch(info.Arch)
+	var args [6]regParam
+	for i := range args {
+		args[i] = regParam(info.Data[1+i])
+		if s.abi.arch == auditArchI386 {
+			args[i] = regParam(uint32(info.Data[1+i]))
+		}
+	}
+	s.setSyscall(pid, SyscallId(info.Data[0]), args)
+}
+
+// Set the syscall id and args, demultiplexing the multiplexed syscalls
+func (s *syscallState) setSyscall(pid int, id SyscallId, args [6]regParam) {
+	s.id, s.args, s.argsErr = s.abi.demux(pid, id, args)
+}
+
+// Value of the arg i, 0 if out of range
+func (s *syscallState) param(i int) regParam {
+	if i < 0 || i >= len(s.args) {
+		return 0
+	}
+	return s.args[i]
+}
@@ -1,25 +1,6 @@
 package libtrace
 
-var (
-	type_int     = int(0)
-	type_int8    = int8(0)
-	type_int16   = int16(0)
-	type_int32   = int32(0)
-	type_int64   = int64(0)
-	type_uint8   = uint8(0)
-	type_uint16  = uint16(0)
-	type_uint32  = uint32(0)
-	type_uint64  = uint64(0)
-	type_uintptr = uintptr(0)
-	type_float32 = float32(0)
-	type_float64 = float64(0)
-	type_stringc = StringC("")
-	type_buffer  = []byte{}
-
-	type_unknownstruct = struct{}{}
-)
-
-var syscalls = []*Signature{
+var syscallsX86_64 = []*Signature{
 
 	&Signature{Id: 0, Name: "read", Args: []Arg{Arg{Name: "fd", Type: type_uint32, Const: false}, Arg{Name: "buf", Type: Buffer(-1), Const: false}, Arg{Name: "count", Type: type_uint64, Const: false}}},
 	&Signature{Id: 1, Name: "write", Args: []Arg{Arg{Name: "fd", Type: type_uint32, Const: false}, Arg{Name: "buf", Type: type_stringc, Const: true}, Arg{Name: "count", Type: type_uint64, Const: false}}},
@@ -195,6 +176,7 @@ var syscalls = []*Signature{
 	&Signature{Id: 171, Name: "setdomainname", Args: []Arg{Arg{Name: "name", Type: type_stringc, Const: false}, Arg{Name: "len", Type: type_int, Const: false}}},
 	&Signature{Id: 172, Name: "iopl", Args: []Arg{Arg{Name: "level", Type: type_uint32, Const: false}, Arg{Name: "regs", Type: &type_unknownstruct, Const: false}}},
 	&Signature{Id: 173, Name: "ioperm", Args: []Arg{Arg{Name: "from", Type: type_uint64, Const: false}, Arg{Name: "num", Type: type_uint64, Const: false}, Arg{Name: "turn_on", Type: type_int, Const: false}}},
+	&unknownSignature, // 174
 	&Signature{Id: 175, Name: "init_module", Args: []Arg{Arg{Name: "umod", Type: &type_uint8, Const: false}, Arg{Name: "len", Type: type_uint64, Const: false}, Arg{Name: "uargs", Type: type_stringc, Const: true}}},
 	&Signature{Id: 176, Name: "delete_module", Args: []Arg{Arg{Name: "name_user", Type: type_stringc, Const: true}, Arg{Name: "flags", Type: type_uint32, Const: false}}},
 	&unknownSignature, // 176
@@ -332,4 +314,145 @@ var syscalls = []*Signature{
 	&Signature{Id: 309, Name: "getcpu", Args: []Arg{Arg{Name: "cpup", Type: &type_uint32, Const: false}, Arg{Name: "nodep", Type: &type_uint32, Const: false}, Arg{Name: "unused", Type: &type_unknownstruct, Const: false}}},
 	&Signature{Id: 310, Name: "process_vm_readv", Args: []Arg{Arg{Name: "pid", Type: type_int32, Const: false}, Arg{Name: "lvec", Type: &type_unknownstruct, Const: true}, Arg{Name: "liovcnt", Type: type_uint64, Const: false}, Arg{Name: "rvec", Type: &type_unknownstruct, Const: true}, Arg{Name: "riovcnt", Type: type_uint64, Const: false}, Arg{Name: "flags", Type: type_uint64, Const: false}}},
 	&Signature{Id: 311, Name: "process_vm_writev", Args: []Arg{Arg{Name: "pid", Type: type_int32, Const: false}, Arg{Name: "lvec", Type: &type_unknownstruct, Const: true}, Arg{Name: "liovcnt", Type: type_uint64, Const: false}, Arg{Name: "rvec", Type: &type_unknownstruct, Const: true}, Arg{Name: "riovcnt", Type: type_uint64, Const: false}, Arg{Name: "flags", Type: type_uint64, Const: false}}},
+	&Signature{Id: 312, Name: "kcmp", Args: nil},
+	&Signature{Id: 313, Name: "finit_module", Args: nil},
+	&Signature{Id: 314, Name: "sched_setattr", Args: nil},
+	&Signature{Id: 315, Name: "sched_getattr", Args: nil},
+	&Signature{Id: 316, Name: "renameat2", Args: nil},
+	&Signature{Id: 317, Name: "seccomp", Args: nil},
+	&Signature{Id: 318, Name: "getrandom", Args: nil},
+	&Signature{Id: 319, Name: "memfd_create", Args: nil},
+	&Signature{Id: 320, Name: "kexec_file_load", Args: nil},
+	&Signature{Id: 321, Name: "bpf", Args: nil},
+	&Signature{Id: 322, Name: "execveat", Args: nil},
+	&Signature{Id: 323, Name: "userfaultfd", Args: nil},
+	&Signature{Id: 324, Name: "membarrier", Args: nil},
+	&Signature{Id: 325, Name: "mlock2", Args: nil},
+	&Signature{Id: 326, Name: "copy_file_range", Args: nil},
+	&Signature{Id: 327, Name: "preadv2", Args: nil},
+	&Signature{Id: 328, Name: "pwritev2", Args: nil},
+	&Signature{Id: 329, Name: "pkey_mprotect", Args: nil},
+	&Signature{Id: 330, Name: "pkey_alloc", Args: nil},
+	&Signature{Id: 331, Name: "pkey_free", Args: nil},
+	&Signature{Id: 332, Name: "statx", Args: nil},
+	&Signature{Id: 333, Name: "io_pgetevents", Args: nil},
+	&Signature{Id: 334, Name: "rseq", Args: nil},
+	&unknownSignature, // 335
+	&unknownSignature, // 336
+	&unknownSignature, // 337
+	&unknownSignature, // 338
+	&unknownSignature, // 339
+	&unknownSignature, // 340
+	&unknownSignature, // 341
+	&unknownSignature, // 342
+	&unknownSignature, // 343
+	&unknownSignature, // 344
+	&unknownSignature, // 345
+	&unknownSignature, // 346
+	&unknownSignature, // 347
+	&unknownSignature, // 348
+	&unknownSignature, // 349
+	&unknownSignature, // 350
+	&unknownSignature, // 351
+	&unknownSignature, // 352
+	&unknownSignature, // 353
+	&unknownSignature, // 354
+	&unknownSignature, // 355
+	&unknownSignature, // 356
+	&unknownSignature, // 357
+	&unknownSignature, // 358
+	&unknownSignature, // 359
+	&unknownSignature, // 360
+	&unknownSignature, // 361
+	&unknownSignature, // 362
+	&unknownSignature, // 363
+	&unknownSignature, // 364
+	&unknownSignature, // 365
+	&unknownSignature, // 366
+	&unknownSignature, // 367
+	&unknownSignature, // 368
+	&unknownSignature, // 369
+	&unknownSignature, // 370
+	&unknownSignature, // 371
+	&unknownSignature, // 372
+	&unknownSignature, // 373
+	&unknownSignature, // 374
+	&unknownSignature, // 375
+	&unknownSignature, // 376
+	&unknownSignature, // 377
+	&unknownSignature, // 378
+	&unknownSignature, // 379
+	&unknownSignature, // 380
+	&unknownSignature, // 381
+	&unknownSignature, // 382
+	&unknownSignature, // 383
+	&unknownSignature, // 384
+	&unknownSignature, // 385
+	&unknownSignature, // 386
+	&unknownSignature, // 387
+	&unknownSignature, // 388
+	&unknownSignature, // 389
+	&unknownSignature, // 390
+	&unknownSignature, // 391
+	&unknownSignature, // 392
+	&unknownSignature, // 393
+	&unknownSignature, // 394
+	&unknownSignature, // 395
+	&unknownSignature, // 396
+	&unknownSignature, // 397
+	&unknownSignature, // 398
+	&unknownSignature, // 399
+	&unknownSignature, // 400
+	&unknownSignature, // 401
+	&unknownSignature, // 402
+	&unknownSignature, // 403
+	&unknownSignature, // 404
+	&unknownSignature, // 405
+	&unknownSignature, // 406
+	&unknownSignature, // 407
+	&unknownSignature, // 408
+	&unknownSignature, // 409
+	&unknownSignature, // 410
+	&unknownSignature, // 411
+	&unknownSignature, // 412
+	&unknownSignature, // 413
+	&unknownSignature, // 414
+	&unknownSignature, // 415
+	&unknownSignature, // 416
+	&unknownSignature, // 417
+	&unknownSignature, // 418
+	&unknownSignature, // 419
+	&unknownSignature, // 420
+	&unknownSignature, // 421
+	&unknownSignature, // 422
+	&unknownSignature, // 423
+	&Signature{Id: 424, Name: "pidfd_send_signal", Args: nil},
+	&Signature{Id: 425, Name: "io_uring_setup", Args: nil},
+	&Signature{Id: 426, Name: "io_uring_enter", Args: nil},
+	&Signature{Id: 427, Name: "io_uring_register", Args: nil},
+	&Signature{Id: 428, Name: "open_tree", Args: nil},
+	&Signature{Id: 429, Name: "move_mount", Args: nil},
+	&Signature{Id: 430, Name: "fsopen", Args: nil},
+	&Signature{Id: 431, Name: "fsconfig", Args: nil},
+	&Signature{Id: 432, Name: "fsmount", Args: nil},
+	&Signature{Id: 433, Name: "fspick", Args: nil},
+	&Signature{Id: 434, Name: "pidfd_open", Args: nil},
+	&Signature{Id: 435, Name: "clone3", Args: nil},
+	&Signature{Id: 436, Name: "close_range", Args: nil},
+	&Signature{Id: 437, Name: "openat2", Args: nil},
+	&Signature{Id: 438, Name: "pidfd_getfd", Args: nil},
+	&Signature{Id: 439, Name: "faccessat2", Args: nil},
+	&Signature{Id: 440, Name: "process_madvise", Args: nil},
+	&Signature{Id: 441, Name: "epoll_pwait2", Args: nil},
+	&Signature{Id: 442, Name: "mount_setattr", Args: nil},
+	&Signature{Id: 443, Name: "quotactl_fd", Args: nil},
+	&Signature{Id: 444, Name: "landlock_create_ruleset", Args: nil},
+	&Signature{Id: 445, Name: "landlock_add_rule", Args: nil},
+	&Signature{Id: 446, Name: "landlock_restrict_self", Args: nil},
+	&Signature{Id: 447, Name: "memfd_secret", Args: nil},
+	&Signature{Id: 448, Name: "process_mrelease", Args: nil},
+	&Signature{Id: 449, Name: "futex_waitv", Args: nil},
+	&Signature{Id: 450, Name: "set_mempolicy_home_node", Args: nil},
+	&Signature{Id: 451, Name: "cachestat", Args: nil},
+	&Signature{Id: 452, Name: "fchmodat2", Args: nil},
 }
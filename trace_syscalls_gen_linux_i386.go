@@ -1,26 +1,6 @@
 package libtrace
 
-var (
-	type_int     = int(0)
-	type_uint    = uint(0)
-	type_int8    = int8(0)
-	type_int16   = int16(0)
-	type_int32   = int32(0)
-	type_int64   = int64(0)
-	type_uint8   = uint8(0)
-	type_uint16  = uint16(0)
-	type_uint32  = uint32(0)
-	type_uint64  = uint64(0)
-	type_uintptr = uintptr(0)
-	type_float32 = float32(0)
-	type_float64 = float64(0)
-	type_stringc = StringC("")
-	type_buffer  = []byte{}
-
-	type_unknownstruct = struct{}{}
-)
-
-var syscalls = []*Signature{
+var syscallsI386 = []*Signature{
 	&Signature{Id: 0, Name: "restart_syscall", Args: nil},
 	&Signature{Id: 1, Name: "exit", Args: []Arg{Arg{Name: "error_code", Type: type_int, Const: false}}},
 	&Signature{Id: 2, Name: "fork", Args: []Arg{Arg{Name: "regs", Type: &type_unknownstruct, Const: false}}},
@@ -373,98 +353,235 @@ var syscalls = []*Signature{
 	&Signature{Id: 348, Name: "process_vm_writev", Args: nil},
 	&Signature{Id: 349, Name: "kcmp", Args: nil},
 	&Signature{Id: 350, Name: "finit_module", Args: nil},
-	&unknownSignature, // 351
-	&unknownSignature, // 352
-	&unknownSignature, // 353
-	&unknownSignature, // 354
-	&unknownSignature, // 355
-	&unknownSignature, // 356
-	&unknownSignature, // 357
-	&unknownSignature, // 358
-	&unknownSignature, // 359
-	&unknownSignature, // 360
-	&unknownSignature, // 361
-	&unknownSignature, // 362
-	&unknownSignature, // 363
-	&unknownSignature, // 364
-	&unknownSignature, // 365
-	&unknownSignature, // 366
-	&unknownSignature, // 367
-	&unknownSignature, // 368
-	&unknownSignature, // 369
-	&unknownSignature, // 370
-	&unknownSignature, // 371
-	&unknownSignature, // 372
-	&unknownSignature, // 373
-	&unknownSignature, // 374
-	&unknownSignature, // 375
-	&unknownSignature, // 376
-	&unknownSignature, // 377
-	&unknownSignature, // 378
-	&unknownSignature, // 379
-	&unknownSignature, // 380
-	&unknownSignature, // 381
-	&unknownSignature, // 382
-	&unknownSignature, // 383
-	&unknownSignature, // 384
-	&unknownSignature, // 385
-	&unknownSignature, // 386
+	&Signature{Id: 351, Name: "sched_setattr", Args: nil},
+	&Signature{Id: 352, Name: "sched_getattr", Args: nil},
+	&Signature{Id: 353, Name: "renameat2", Args: nil},
+	&Signature{Id: 354, Name: "seccomp", Args: nil},
+	&Signature{Id: 355, Name: "getrandom", Args: nil},
+	&Signature{Id: 356, Name: "memfd_create", Args: nil},
+	&Signature{Id: 357, Name: "bpf", Args: nil},
+	&Signature{Id: 358, Name: "execveat", Args: nil},
+	&Signature{Id: 359, Name: "socket", Args: []Arg{Arg{Name: "family", Type: type_int, Const: false}, Arg{Name: "type", Type: type_int, Const: false}, Arg{Name: "protocol", Type: type_int, Const: false}}},
+	&Signature{Id: 360, Name: "socketpair", Args: []Arg{Arg{Name: "family", Type: type_int, Const: false}, Arg{Name: "type", Type: type_int, Const: false}, Arg{Name: "protocol", Type: type_int, Const: false}, Arg{Name: "usockvec", Type: &type_int, Const: false}}},
+	&Signature{Id: 361, Name: "bind", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "umyaddr", Type: &type_unknownstruct, Const: false}, Arg{Name: "addrlen", Type: type_int, Const: false}}},
+	&Signature{Id: 362, Name: "connect", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "uservaddr", Type: &type_unknownstruct, Const: false}, Arg{Name: "addrlen", Type: type_int, Const: false}}},
+	&Signature{Id: 363, Name: "listen", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "backlog", Type: type_int, Const: false}}},
+	&Signature{Id: 364, Name: "accept4", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "upeer_sockaddr", Type: &type_unknownstruct, Const: false}, Arg{Name: "upeer_addrlen", Type: &type_int, Const: false}, Arg{Name: "flags", Type: type_int, Const: false}}},
+	&Signature{Id: 365, Name: "getsockopt", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "level", Type: type_int, Const: false}, Arg{Name: "optname", Type: type_int, Const: false}, Arg{Name: "optval", Type: &type_unknownstruct, Const: false}, Arg{Name: "optlen", Type: &type_int, Const: false}}},
+	&Signature{Id: 366, Name: "setsockopt", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "level", Type: type_int, Const: false}, Arg{Name: "optname", Type: type_int, Const: false}, Arg{Name: "optval", Type: &type_unknownstruct, Const: false}, Arg{Name: "optlen", Type: type_int, Const: false}}},
+	&Signature{Id: 367, Name: "getsockname", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "usockaddr", Type: &type_unknownstruct, Const: false}, Arg{Name: "usockaddr_len", Type: &type_int, Const: false}}},
+	&Signature{Id: 368, Name: "getpeername", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "usockaddr", Type: &type_unknownstruct, Const: false}, Arg{Name: "usockaddr_len", Type: &type_int, Const: false}}},
+	&Signature{Id: 369, Name: "sendto", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "buff", Type: Buffer(2), Const: true}, Arg{Name: "len", Type: type_uint, Const: false}, Arg{Name: "flags", Type: type_uint, Const: false}, Arg{Name: "addr", Type: &type_unknownstruct, Const: false}, Arg{Name: "addr_len", Type: type_int, Const: false}}},
+	&Signature{Id: 370, Name: "sendmsg", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "msg", Type: &type_unknownstruct, Const: false}, Arg{Name: "flags", Type: type_uint, Const: false}}},
+	&Signature{Id: 371, Name: "recvfrom", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "ubuf", Type: Buffer(-1), Const: false}, Arg{Name: "size", Type: type_uint, Const: false}, Arg{Name: "flags", Type: type_uint, Const: false}, Arg{Name: "addr", Type: &type_unknownstruct, Const: false}, Arg{Name: "addr_len", Type: &type_int, Const: false}}},
+	&Signature{Id: 372, Name: "recvmsg", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "msg", Type: &type_unknownstruct, Const: false}, Arg{Name: "flags", Type: type_uint, Const: false}}},
+	&Signature{Id: 373, Name: "shutdown", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "how", Type: type_int, Const: false}}},
+	&Signature{Id: 374, Name: "userfaultfd", Args: nil},
+	&Signature{Id: 375, Name: "membarrier", Args: nil},
+	&Signature{Id: 376, Name: "mlock2", Args: nil},
+	&Signature{Id: 377, Name: "copy_file_range", Args: nil},
+	&Signature{Id: 378, Name: "preadv2", Args: nil},
+	&Signature{Id: 379, Name: "pwritev2", Args: nil},
+	&Signature{Id: 380, Name: "pkey_mprotect", Args: nil},
+	&Signature{Id: 381, Name: "pkey_alloc", Args: nil},
+	&Signature{Id: 382, Name: "pkey_free", Args: nil},
+	&Signature{Id: 383, Name: "statx", Args: nil},
+	&Signature{Id: 384, Name: "arch_prctl", Args: nil},
+	&Signature{Id: 385, Name: "io_pgetevents", Args: nil},
+	&Signature{Id: 386, Name: "rseq", Args: nil},
 	&unknownSignature, // 387
 	&unknownSignature, // 388
 	&unknownSignature, // 389
 	&unknownSignature, // 390
 	&unknownSignature, // 391
 	&unknownSignature, // 392
-	&unknownSignature, // 393
-	&unknownSignature, // 394
-	&unknownSignature, // 395
-	&unknownSignature, // 396
-	&unknownSignature, // 397
-	&unknownSignature, // 398
-	&unknownSignature, // 399
-	&Signature{Id: 400, Name: "socket_subcall", Args: nil},
-	&Signature{Id: 401, Name: "socket", Args: nil},
-	&Signature{Id: 402, Name: "bind", Args: nil},
-	&Signature{Id: 403, Name: "connect", Args: nil},
-	&Signature{Id: 404, Name: "listen", Args: nil},
-	&Signature{Id: 405, Name: "accept", Args: nil},
-	&Signature{Id: 406, Name: "getsockname", Args: nil},
-	&Signature{Id: 407, Name: "getpeername", Args: nil},
-	&Signature{Id: 408, Name: "socketpair", Args: nil},
-	&Signature{Id: 409, Name: "send", Args: nil},
-	&Signature{Id: 410, Name: "recv", Args: nil},
-	&Signature{Id: 411, Name: "sendto", Args: nil},
-	&Signature{Id: 412, Name: "recvfrom", Args: nil},
-	&Signature{Id: 413, Name: "shutdown", Args: nil},
-	&Signature{Id: 414, Name: "setsockopt", Args: nil},
-	&Signature{Id: 415, Name: "getsockopt", Args: nil},
-	&Signature{Id: 416, Name: "sendmsg", Args: nil},
-	&Signature{Id: 417, Name: "recvmsg", Args: nil},
-	&Signature{Id: 418, Name: "accept4", Args: nil},
-	&Signature{Id: 419, Name: "recvmmsg", Args: nil},
-	&Signature{Id: 420, Name: "ipc_subcall", Args: nil},
-	&Signature{Id: 421, Name: "semop", Args: nil},
-	&Signature{Id: 422, Name: "semget", Args: nil},
-	&Signature{Id: 423, Name: "semctl", Args: nil},
-	&Signature{Id: 424, Name: "semtimedop", Args: nil},
-	&Signature{Id: 425, Name: "ipc_subcall", Args: nil},
-	&Signature{Id: 426, Name: "ipc_subcall", Args: nil},
-	&Signature{Id: 427, Name: "ipc_subcall", Args: nil},
-	&Signature{Id: 428, Name: "ipc_subcall", Args: nil},
-	&Signature{Id: 429, Name: "ipc_subcall", Args: nil},
-	&Signature{Id: 430, Name: "ipc_subcall", Args: nil},
-	&Signature{Id: 431, Name: "msgsnd", Args: nil},
-	&Signature{Id: 432, Name: "msgrcv", Args: nil},
-	&Signature{Id: 433, Name: "msgget", Args: nil},
-	&Signature{Id: 434, Name: "msgctl", Args: nil},
-	&Signature{Id: 435, Name: "ipc_subcall", Args: nil},
-	&Signature{Id: 436, Name: "ipc_subcall", Args: nil},
-	&Signature{Id: 437, Name: "ipc_subcall", Args: nil},
-	&Signature{Id: 438, Name: "ipc_subcall", Args: nil},
-	&Signature{Id: 439, Name: "ipc_subcall", Args: nil},
-	&Signature{Id: 440, Name: "ipc_subcall", Args: nil},
-	&Signature{Id: 441, Name: "shmat", Args: nil},
-	&Signature{Id: 442, Name: "shmdt", Args: nil},
-	&Signature{Id: 443, Name: "shmget", Args: nil},
-	&Signature{Id: 444, Name: "shmctl", Args: nil},
+	&Signature{Id: 393, Name: "semget", Args: []Arg{Arg{Name: "key", Type: type_int32, Const: false}, Arg{Name: "nsems", Type: type_int, Const: false}, Arg{Name: "semflg", Type: type_int, Const: false}}},
+	&Signature{Id: 394, Name: "semctl", Args: []Arg{Arg{Name: "semid", Type: type_int, Const: false}, Arg{Name: "semnum", Type: type_int, Const: false}, Arg{Name: "cmd", Type: type_int, Const: false}, Arg{Name: "arg", Type: type_uint, Const: false}}},
+	&Signature{Id: 395, Name: "shmget", Args: []Arg{Arg{Name: "key", Type: type_int32, Const: false}, Arg{Name: "size", Type: type_uint, Const: false}, Arg{Name: "shmflg", Type: type_int, Const: false}}},
+	&Signature{Id: 396, Name: "shmctl", Args: []Arg{Arg{Name: "shmid", Type: type_int, Const: false}, Arg{Name: "cmd", Type: type_int, Const: false}, Arg{Name: "buf", Type: &type_unknownstruct, Const: false}}},
+	&Signature{Id: 397, Name: "shmat", Args: []Arg{Arg{Name: "shmid", Type: type_int, Const: false}, Arg{Name: "shmaddr", Type: type_uint, Const: false}, Arg{Name: "shmflg", Type: type_int, Const: false}}},
+	&Signature{Id: 398, Name: "shmdt", Args: []Arg{Arg{Name: "shmaddr", Type: type_uint, Const: false}}},
+	&Signature{Id: 399, Name: "msgget", Args: []Arg{Arg{Name: "key", Type: type_int32, Const: false}, Arg{Name: "msgflg", Type: type_int, Const: false}}},
+	&Signature{Id: 400, Name: "msgsnd", Args: []Arg{Arg{Name: "msqid", Type: type_int, Const: false}, Arg{Name: "msgp", Type: &type_unknownstruct, Const: false}, Arg{Name: "msgsz", Type: type_uint, Const: false}, Arg{Name: "msgflg", Type: type_int, Const: false}}},
+	&Signature{Id: 401, Name: "msgrcv", Args: []Arg{Arg{Name: "msqid", Type: type_int, Const: false}, Arg{Name: "msgp", Type: &type_unknownstruct, Const: false}, Arg{Name: "msgsz", Type: type_uint, Const: false}, Arg{Name: "msgtyp", Type: type_int32, Const: false}, Arg{Name: "msgflg", Type: type_int, Const: false}}},
+	&Signature{Id: 402, Name: "msgctl", Args: []Arg{Arg{Name: "msqid", Type: type_int, Const: false}, Arg{Name: "cmd", Type: type_int, Const: false}, Arg{Name: "buf", Type: &type_unknownstruct, Const: false}}},
+	&Signature{Id: 403, Name: "clock_gettime64", Args: []Arg{Arg{Name: "which_clock", Type: type_uint32, Const: true}, Arg{Name: "tp", Type: &type_unknownstruct, Const: false}}},
+	&Signature{Id: 404, Name: "clock_settime64", Args: []Arg{Arg{Name: "which_clock", Type: type_uint32, Const: true}, Arg{Name: "tp", Type: &type_unknownstruct, Const: true}}},
+	&Signature{Id: 405, Name: "clock_adjtime64", Args: nil},
+	&Signature{Id: 406, Name: "clock_getres_time64", Args: []Arg{Arg{Name: "which_clock", Type: type_uint32, Const: true}, Arg{Name: "tp", Type: &type_unknownstruct, Const: false}}},
+	&Signature{Id: 407, Name: "clock_nanosleep_time64", Args: []Arg{Arg{Name: "which_clock", Type: type_uint32, Const: true}, Arg{Name: "flags", Type: type_int, Const: false}, Arg{Name: "rqtp", Type: &type_unknownstruct, Const: true}, Arg{Name: "rmtp", Type: &type_unknownstruct, Const: false}}},
+	&Signature{Id: 408, Name: "timer_gettime64", Args: nil},
+	&Signature{Id: 409, Name: "timer_settime64", Args: nil},
+	&Signature{Id: 410, Name: "timerfd_gettime64", Args: nil},
+	&Signature{Id: 411, Name: "timerfd_settime64", Args: nil},
+	&Signature{Id: 412, Name: "utimensat_time64", Args: nil},
+	&Signature{Id: 413, Name: "pselect6_time64", Args: nil},
+	&Signature{Id: 414, Name: "ppoll_time64", Args: nil},
+	&unknownSignature, // 415
+	&Signature{Id: 416, Name: "io_pgetevents_time64", Args: nil},
+	&Signature{Id: 417, Name: "recvmmsg_time64", Args: nil},
+	&Signature{Id: 418, Name: "mq_timedsend_time64", Args: nil},
+	&Signature{Id: 419, Name: "mq_timedreceive_time64", Args: nil},
+	&Signature{Id: 420, Name: "semtimedop_time64", Args: []Arg{Arg{Name: "semid", Type: type_int, Const: false}, Arg{Name: "tsops", Type: &type_unknownstruct, Const: false}, Arg{Name: "nsops", Type: type_uint, Const: false}, Arg{Name: "timeout", Type: &type_unknownstruct, Const: true}}},
+	&Signature{Id: 421, Name: "rt_sigtimedwait_time64", Args: nil},
+	&Signature{Id: 422, Name: "futex_time64", Args: nil},
+	&Signature{Id: 423, Name: "sched_rr_get_interval_time64", Args: nil},
+	&Signature{Id: 424, Name: "pidfd_send_signal", Args: nil},
+	&Signature{Id: 425, Name: "io_uring_setup", Args: nil},
+	&Signature{Id: 426, Name: "io_uring_enter", Args: nil},
+	&Signature{Id: 427, Name: "io_uring_register", Args: nil},
+	&Signature{Id: 428, Name: "open_tree", Args: nil},
+	&Signature{Id: 429, Name: "move_mount", Args: nil},
+	&Signature{Id: 430, Name: "fsopen", Args: nil},
+	&Signature{Id: 431, Name: "fsconfig", Args: nil},
+	&Signature{Id: 432, Name: "fsmount", Args: nil},
+	&Signature{Id: 433, Name: "fspick", Args: nil},
+	&Signature{Id: 434, Name: "pidfd_open", Args: nil},
+	&Signature{Id: 435, Name: "clone3", Args: nil},
+	&Signature{Id: 436, Name: "close_range", Args: nil},
+	&Signature{Id: 437, Name: "openat2", Args: nil},
+	&Signature{Id: 438, Name: "pidfd_getfd", Args: nil},
+	&Signature{Id: 439, Name: "faccessat2", Args: nil},
+	&Signature{Id: 440, Name: "process_madvise", Args: nil},
+	&Signature{Id: 441, Name: "epoll_pwait2", Args: nil},
+	&Signature{Id: 442, Name: "mount_setattr", Args: nil},
+	&Signature{Id: 443, Name: "quotactl_fd", Args: nil},
+	&Signature{Id: 444, Name: "landlock_create_ruleset", Args: nil},
+	&Signature{Id: 445, Name: "landlock_add_rule", Args: nil},
+	&Signature{Id: 446, Name: "landlock_restrict_self", Args: nil},
+	&Signature{Id: 447, Name: "memfd_secret", Args: nil},
+	&Signature{Id: 448, Name: "process_mrelease", Args: nil},
+	&Signature{Id: 449, Name: "futex_waitv", Args: nil},
+	&Signature{Id: 450, Name: "set_mempolicy_home_node", Args: nil},
+	&Signature{Id: 451, Name: "cachestat", Args: nil},
+	&Signature{Id: 452, Name: "fchmodat2", Args: nil},
+}
+
+// Subcalls of socketcall and ipc, from the id socketcallBase
+var subcallsI386 = []*Signature{
+	&Signature{Id: 1000, Name: "socket_subcall", Args: nil},
+	&Signature{Id: 1001, Name: "socket", Args: []Arg{Arg{Name: "family", Type: type_int, Const: false}, Arg{Name: "type", Type: type_int, Const: false}, Arg{Name: "protocol", Type: type_int, Const: false}}},
+	&Signature{Id: 1002, Name: "bind", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "umyaddr", Type: &type_unknownstruct, Const: false}, Arg{Name: "addrlen", Type: type_int, Const: false}}},
+	&Signature{Id: 1003, Name: "connect", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "uservaddr", Type: &type_unknownstruct, Const: false}, Arg{Name: "addrlen", Type: type_int, Const: false}}},
+	&Signature{Id: 1004, Name: "listen", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "backlog", Type: type_int, Const: false}}},
+	&Signature{Id: 1005, Name: "accept", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "upeer_sockaddr", Type: &type_unknownstruct, Const: false}, Arg{Name: "upeer_addrlen", Type: &type_int, Const: false}}},
+	&Signature{Id: 1006, Name: "getsockname", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "usockaddr", Type: &type_unknownstruct, Const: false}, Arg{Name: "usockaddr_len", Type: &type_int, Const: false}}},
+	&Signature{Id: 1007, Name: "getpeername", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "usockaddr", Type: &type_unknownstruct, Const: false}, Arg{Name: "usockaddr_len", Type: &type_int, Const: false}}},
+	&Signature{Id: 1008, Name: "socketpair", Args: []Arg{Arg{Name: "family", Type: type_int, Const: false}, Arg{Name: "type", Type: type_int, Const: false}, Arg{Name: "protocol", Type: type_int, Const: false}, Arg{Name: "usockvec", Type: &type_int, Const: false}}},
+	&Signature{Id: 1009, Name: "send", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "buff", Type: Buffer(2), Const: true}, Arg{Name: "len", Type: type_uint, Const: false}, Arg{Name: "flags", Type: type_uint, Const: false}}},
+	&Signature{Id: 1010, Name: "recv", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "ubuf", Type: Buffer(-1), Const: false}, Arg{Name: "size", Type: type_uint, Const: false}, Arg{Name: "flags", Type: type_uint, Const: false}}},
+	&Signature{Id: 1011, Name: "sendto", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "buff", Type: Buffer(2), Const: true}, Arg{Name: "len", Type: type_uint, Const: false}, Arg{Name: "flags", Type: type_uint, Const: false}, Arg{Name: "addr", Type: &type_unknownstruct, Const: false}, Arg{Name: "addr_len", Type: type_int, Const: false}}},
+	&Signature{Id: 1012, Name: "recvfrom", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "ubuf", Type: Buffer(-1), Const: false}, Arg{Name: "size", Type: type_uint, Const: false}, Arg{Name: "flags", Type: type_uint, Const: false}, Arg{Name: "addr", Type: &type_unknownstruct, Const: false}, Arg{Name: "addr_len", Type: &type_int, Const: false}}},
+	&Signature{Id: 1013, Name: "shutdown", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "how", Type: type_int, Const: false}}},
+	&Signature{Id: 1014, Name: "setsockopt", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "level", Type: type_int, Const: false}, Arg{Name: "optname", Type: type_int, Const: false}, Arg{Name: "optval", Type: &type_unknownstruct, Const: false}, Arg{Name: "optlen", Type: type_int, Const: false}}},
+	&Signature{Id: 1015, Name: "getsockopt", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "level", Type: type_int, Const: false}, Arg{Name: "optname", Type: type_int, Const: false}, Arg{Name: "optval", Type: &type_unknownstruct, Const: false}, Arg{Name: "optlen", Type: &type_int, Const: false}}},
+	&Signature{Id: 1016, Name: "sendmsg", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "msg", Type: &type_unknownstruct, Const: false}, Arg{Name: "flags", Type: type_uint, Const: false}}},
+	&Signature{Id: 1017, Name: "recvmsg", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "msg", Type: &type_unknownstruct, Const: false}, Arg{Name: "flags", Type: type_uint, Const: false}}},
+	&Signature{Id: 1018, Name: "accept4", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "upeer_sockaddr", Type: &type_unknownstruct, Const: false}, Arg{Name: "upeer_addrlen", Type: &type_int, Const: false}, Arg{Name: "flags", Type: type_int, Const: false}}},
+	&Signature{Id: 1019, Name: "recvmmsg", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "mmsg", Type: &type_unknownstruct, Const: false}, Arg{Name: "vlen", Type: type_uint, Const: false}, Arg{Name: "flags", Type: type_uint, Const: false}, Arg{Name: "timeout", Type: &type_unknownstruct, Const: false}}},
+	&Signature{Id: 1020, Name: "sendmmsg", Args: []Arg{Arg{Name: "fd", Type: type_int, Const: false}, Arg{Name: "mmsg", Type: &type_unknownstruct, Const: false}, Arg{Name: "vlen", Type: type_uint, Const: false}, Arg{Name: "flags", Type: type_uint, Const: false}}},
+	&unknownSignature, // 1021
+	&unknownSignature, // 1022
+	&unknownSignature, // 1023
+	&unknownSignature, // 1024
+	&unknownSignature, // 1025
+	&unknownSignature, // 1026
+	&unknownSignature, // 1027
+	&unknownSignature, // 1028
+	&unknownSignature, // 1029
+	&unknownSignature, // 1030
+	&unknownSignature, // 1031
+	&unknownSignature, // 1032
+	&unknownSignature, // 1033
+	&unknownSignature, // 1034
+	&unknownSignature, // 1035
+	&unknownSignature, // 1036
+	&unknownSignature, // 1037
+	&unknownSignature, // 1038
+	&unknownSignature, // 1039
+	&unknownSignature, // 1040
+	&unknownSignature, // 1041
+	&unknownSignature, // 1042
+	&unknownSignature, // 1043
+	&unknownSignature, // 1044
+	&unknownSignature, // 1045
+	&unknownSignature, // 1046
+	&unknownSignature, // 1047
+	&unknownSignature, // 1048
+	&unknownSignature, // 1049
+	&unknownSignature, // 1050
+	&unknownSignature, // 1051
+	&unknownSignature, // 1052
+	&unknownSignature, // 1053
+	&unknownSignature, // 1054
+	&unknownSignature, // 1055
+	&unknownSignature, // 1056
+	&unknownSignature, // 1057
+	&unknownSignature, // 1058
+	&unknownSignature, // 1059
+	&unknownSignature, // 1060
+	&unknownSignature, // 1061
+	&unknownSignature, // 1062
+	&unknownSignature, // 1063
+	&unknownSignature, // 1064
+	&unknownSignature, // 1065
+	&unknownSignature, // 1066
+	&unknownSignature, // 1067
+	&unknownSignature, // 1068
+	&unknownSignature, // 1069
+	&unknownSignature, // 1070
+	&unknownSignature, // 1071
+	&unknownSignature, // 1072
+	&unknownSignature, // 1073
+	&unknownSignature, // 1074
+	&unknownSignature, // 1075
+	&unknownSignature, // 1076
+	&unknownSignature, // 1077
+	&unknownSignature, // 1078
+	&unknownSignature, // 1079
+	&unknownSignature, // 1080
+	&unknownSignature, // 1081
+	&unknownSignature, // 1082
+	&unknownSignature, // 1083
+	&unknownSignature, // 1084
+	&unknownSignature, // 1085
+	&unknownSignature, // 1086
+	&unknownSignature, // 1087
+	&unknownSignature, // 1088
+	&unknownSignature, // 1089
+	&unknownSignature, // 1090
+	&unknownSignature, // 1091
+	&unknownSignature, // 1092
+	&unknownSignature, // 1093
+	&unknownSignature, // 1094
+	&unknownSignature, // 1095
+	&unknownSignature, // 1096
+	&unknownSignature, // 1097
+	&unknownSignature, // 1098
+	&unknownSignature, // 1099
+	&Signature{Id: 1100, Name: "ipc_subcall", Args: nil},
+	&Signature{Id: 1101, Name: "semop", Args: []Arg{Arg{Name: "semid", Type: type_int, Const: false}, Arg{Name: "tsops", Type: &type_unknownstruct, Const: false}, Arg{Name: "nsops", Type: type_uint, Const: false}}},
+	&Signature{Id: 1102, Name: "semget", Args: []Arg{Arg{Name: "key", Type: type_int32, Const: false}, Arg{Name: "nsems", Type: type_int, Const: false}, Arg{Name: "semflg", Type: type_int, Const: false}}},
+	&Signature{Id: 1103, Name: "semctl", Args: []Arg{Arg{Name: "semid", Type: type_int, Const: false}, Arg{Name: "semnum", Type: type_int, Const: false}, Arg{Name: "cmd", Type: type_int, Const: false}, Arg{Name: "arg", Type: type_uint, Const: false}}},
+	&Signature{Id: 1104, Name: "semtimedop", Args: []Arg{Arg{Name: "semid", Type: type_int, Const: false}, Arg{Name: "tsops", Type: &type_unknownstruct, Const: false}, Arg{Name: "nsops", Type: type_uint, Const: false}, Arg{Name: "timeout", Type: &type_unknownstruct, Const: true}}},
+	&Signature{Id: 1105, Name: "ipc_subcall", Args: nil},
+	&Signature{Id: 1106, Name: "ipc_subcall", Args: nil},
+	&Signature{Id: 1107, Name: "ipc_subcall", Args: nil},
+	&Signature{Id: 1108, Name: "ipc_subcall", Args: nil},
+	&Signature{Id: 1109, Name: "ipc_subcall", Args: nil},
+	&Signature{Id: 1110, Name: "ipc_subcall", Args: nil},
+	&Signature{Id: 1111, Name: "msgsnd", Args: []Arg{Arg{Name: "msqid", Type: type_int, Const: false}, Arg{Name: "msgp", Type: &type_unknownstruct, Const: false}, Arg{Name: "msgsz", Type: type_uint, Const: false}, Arg{Name: "msgflg", Type: type_int, Const: false}}},
+	&Signature{Id: 1112, Name: "msgrcv", Args: []Arg{Arg{Name: "msqid", Type: type_int, Const: false}, Arg{Name: "msgp", Type: &type_unknownstruct, Const: false}, Arg{Name: "msgsz", Type: type_uint, Const: false}, Arg{Name: "msgtyp", Type: type_int32, Const: false}, Arg{Name: "msgflg", Type: type_int, Const: false}}},
+	&Signature{Id: 1113, Name: "msgget", Args: []Arg{Arg{Name: "key", Type: type_int32, Const: false}, Arg{Name: "msgflg", Type: type_int, Const: false}}},
+	&Signature{Id: 1114, Name: "msgctl", Args: []Arg{Arg{Name: "msqid", Type: type_int, Const: false}, Arg{Name: "cmd", Type: type_int, Const: false}, Arg{Name: "buf", Type: &type_unknownstruct, Const: false}}},
+	&Signature{Id: 1115, Name: "ipc_subcall", Args: nil},
+	&Signature{Id: 1116, Name: "ipc_subcall", Args: nil},
+	&Signature{Id: 1117, Name: "ipc_subcall", Args: nil},
+	&Signature{Id: 1118, Name: "ipc_subcall", Args: nil},
+	&Signature{Id: 1119, Name: "ipc_subcall", Args: nil},
+	&Signature{Id: 1120, Name: "ipc_subcall", Args: nil},
+	&Signature{Id: 1121, Name: "shmat", Args: []Arg{Arg{Name: "shmid", Type: type_int, Const: false}, Arg{Name: "shmaddr", Type: type_uint, Const: false}, Arg{Name: "shmflg", Type: type_int, Const: false}}},
+	&Signature{Id: 1122, Name: "shmdt", Args: []Arg{Arg{Name: "shmaddr", Type: type_uint, Const: false}}},
+	&Signature{Id: 1123, Name: "shmget", Args: []Arg{Arg{Name: "key", Type: type_int32, Const: false}, Arg{Name: "size", Type: type_uint, Const: false}, Arg{Name: "shmflg", Type: type_int, Const: false}}},
+	&Signature{Id: 1124, Name: "shmctl", Args: []Arg{Arg{Name: "shmid", Type: type_int, Const: false}, Arg{Name: "cmd", Type: type_int, Const: false}, Arg{Name: "buf", Type: &type_unknownstruct, Const: false}}},
 }
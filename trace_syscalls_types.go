@@ -0,0 +1,21 @@
+package libtrace
+
+var (
+	type_int     = int(0)
+	type_uint    = uint(0)
+	type_int8    = int8(0)
+	type_int16   = int16(0)
+	type_int32   = int32(0)
+	type_int64   = int64(0)
+	type_uint8   = uint8(0)
+	type_uint16  = uint16(0)
+	type_uint32  = uint32(0)
+	type_uint64  = uint64(0)
+	type_uintptr = uintptr(0)
+	type_float32 = float32(0)
+	type_float64 = float64(0)
+	type_stringc = StringC("")
+	type_buffer  = []byte{}
+
+	type_unknownstruct = struct{}{}
+)